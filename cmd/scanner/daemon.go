@@ -0,0 +1,612 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"network-scanner-go/internal/database"
+	"network-scanner-go/internal/discovery/dhcp"
+	"network-scanner-go/internal/discovery/mdns"
+	"network-scanner-go/internal/discovery/names"
+	"network-scanner-go/internal/discovery/ssdp"
+	"network-scanner-go/internal/history"
+	"network-scanner-go/internal/notifications"
+	"network-scanner-go/internal/scanner"
+	"network-scanner-go/internal/security"
+	"network-scanner-go/internal/snmp"
+	"network-scanner-go/internal/telemetry"
+	"network-scanner-go/internal/web"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	scanDuration       = telemetry.NewHistogram("scanner_scan_duration_seconds", "Time taken by a full discovery and enrichment cycle of a scan target.", telemetry.ScanBuckets, "target")
+	enrichmentDuration = telemetry.NewHistogram("scanner_enrichment_duration_seconds", "Time taken to identify, enrich and save one device.", telemetry.ScanBuckets, "target")
+)
+
+// daemon holds the components shared by all scan targets
+type daemon struct {
+	inventory           *database.Inventory
+	server              *web.Server
+	notificationManager *notifications.Manager
+	discoverer          scanner.Discoverer
+	defaultInterval     time.Duration
+	passive             bool
+	mdns                *mdns.Browser  // nil when the mDNS groups could not be joined
+	ssdp                *ssdp.Listener // nil when the SSDP group could not be joined
+	resolver            *names.Resolver
+	dhcp                *dhcp.Snooper // nil when the DHCP ports could not be bound
+	snmp                *snmp.Poller  // nil unless SNMP polling is enabled
+
+	notifyNewDevices   bool
+	notifyDisconnected bool
+	notifyPortChanges  bool
+	notifyCertExpiring bool
+	notifyHostKeys     bool
+	certExpiryWindow   time.Duration
+
+	mu     sync.Mutex
+	latest map[string][]*database.Device // Target name -> devices found by its last scan
+}
+
+// runTargets scans every target until ctx is done and returns once all scans
+// have stopped. Each target runs on its own schedule with its own change
+// detector, so devices of other targets are never reported as disconnected.
+// known seeds the detectors with the devices already in the inventory.
+func (d *daemon) runTargets(ctx context.Context, targets []scanner.ScanTarget, known []*database.Device) {
+	// Results of targets that were removed must not count as current devices
+	d.mu.Lock()
+	for name := range d.latest {
+		if !hasTarget(targets, name) {
+			delete(d.latest, name)
+		}
+	}
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		detector := notifications.NewDetector()
+		detector.CertExpiryWindow = d.certExpiryWindow
+		if devices := devicesInTarget(known, target); len(devices) > 0 {
+			detector.UpdateState(devices)
+		}
+
+		wg.Add(1)
+		go func(target scanner.ScanTarget) {
+			defer wg.Done()
+			d.runTarget(ctx, target, detector)
+		}(target)
+	}
+	wg.Wait()
+}
+
+// runTarget scans a target on its own schedule until ctx is done
+func (d *daemon) runTarget(ctx context.Context, target scanner.ScanTarget, detector *notifications.Detector) {
+	interval := target.IntervalDuration(d.defaultInterval)
+	for {
+		d.scanTarget(ctx, target, detector)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[%s] Scan complete. Sleeping for %s...", target.Name, interval)
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// scanTarget runs one discovery and enrichment cycle for a target. A cycle
+// cut short by ctx saves nothing it has not finished.
+func (d *daemon) scanTarget(ctx context.Context, target scanner.ScanTarget, detector *notifications.Detector) {
+	log.Printf("[%s] Starting network scan for %v", target.Name, target.Ranges)
+	start := time.Now()
+
+	profile, err := scanner.ResolvePortProfile(target.PortProfile)
+	if err != nil {
+		log.Printf("[%s] %v", target.Name, err)
+		return
+	}
+	timing, err := scanner.ResolveTiming(target.Timing)
+	if err != nil {
+		log.Printf("[%s] %v", target.Name, err)
+		return
+	}
+	if d.snmp != nil {
+		// Credentials edited since the last cycle apply from this one on
+		if err := d.snmp.Refresh(); err != nil {
+			log.Printf("[%s] Failed to load SNMP credentials: %v", target.Name, err)
+		}
+	}
+
+	// Discover devices
+	discoveredDevices, err := scanner.DiscoverTarget(ctx, d.discoverer, target, nil)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Scan error: %v", target.Name, err)
+		return
+	}
+
+	log.Printf("[%s] Found %d active devices", target.Name, len(discoveredDevices))
+
+	// Ask mDNS responders and UPnP devices to announce themselves; passive
+	// mode only listens
+	if !d.passive {
+		var browseWg sync.WaitGroup
+		if d.mdns != nil {
+			browseWg.Add(1)
+			go func() {
+				defer browseWg.Done()
+				d.mdns.Browse(2 * time.Second)
+			}()
+		}
+		if d.ssdp != nil {
+			browseWg.Add(1)
+			go func() {
+				defer browseWg.Done()
+				if err := d.ssdp.Search(2 * time.Second); err != nil {
+					log.Printf("[%s] SSDP search failed: %v", target.Name, err)
+				}
+			}()
+		}
+		browseWg.Wait()
+	}
+
+	// Enrich devices in parallel, as many at once as the timing template allows
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, timing.ParallelHosts)
+	for _, device := range discoveredDevices {
+		wg.Add(1)
+		go func(dev *database.Device) {
+			defer wg.Done()
+			sem <- struct{}{}        // Acquire
+			defer func() { <-sem }() // Release
+
+			enrichStart := time.Now()
+			d.enrichDevice(ctx, dev, profile, timing)
+			enrichmentDuration.ObserveDuration(enrichStart, target.Name)
+		}(device)
+	}
+
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	// Place devices on switch ports now that the switches have been polled
+	if d.snmp != nil {
+		d.locateDevices(discoveredDevices)
+	}
+
+	d.mu.Lock()
+	d.latest[target.Name] = discoveredDevices
+	d.mu.Unlock()
+
+	d.server.Broadcast(map[string]interface{}{
+		"type": "discovery_complete",
+		"data": discoveredDevices,
+	})
+
+	// Detect and notify changes
+	previousDevices := make([]*database.Device, 0)
+	for _, dev := range detector.GetPreviousDevices() {
+		previousDevices = append(previousDevices, dev)
+	}
+
+	changes := detector.CompareDeviceStates(previousDevices, discoveredDevices)
+	for _, change := range changes {
+		// Check if notification is enabled for this type
+		shouldNotify := false
+		switch change.Type {
+		case "new_device":
+			shouldNotify = d.notifyNewDevices
+		case "disconnected":
+			shouldNotify = d.notifyDisconnected
+		case "port_change":
+			shouldNotify = d.notifyPortChanges
+		case "cert_expiring":
+			shouldNotify = d.notifyCertExpiring
+		case "host_key_changed":
+			shouldNotify = d.notifyHostKeys
+		}
+
+		if shouldNotify {
+			if err := d.notificationManager.NotifyChange(change); err != nil {
+				log.Printf("Failed to send notification: %v", err)
+			}
+			d.server.Broadcast(map[string]interface{}{
+				"type": "notification",
+				"data": change,
+			})
+		}
+	}
+
+	// Update detector state
+	detector.UpdateState(discoveredDevices)
+
+	// Record network snapshot for historical tracking. The snapshot covers
+	// every target, so it is never read as a partial network total.
+	if err := history.RecordNetworkSnapshot(d.currentDevices()); err != nil {
+		log.Printf("Failed to record network snapshot: %v", err)
+	}
+
+	// Record individual device changes
+	for _, change := range changes {
+		changeType := "update"
+		switch change.Type {
+		case "new_device":
+			changeType = "new"
+		case "disconnected":
+			changeType = "disconnect"
+		}
+		if err := history.RecordDeviceState(change.Device, changeType); err != nil {
+			log.Printf("Failed to record device change: %v", err)
+		}
+	}
+
+	scanDuration.ObserveDuration(start, target.Name)
+}
+
+// enrichDevice identifies a discovered device, merges its ports and saves it
+func (d *daemon) enrichDevice(ctx context.Context, dev *database.Device, profile *scanner.PortProfile, timing *scanner.Timing) {
+	// Load existing device data to preserve previously discovered ports
+	existing := d.inventory.GetDevice(dev.MAC)
+	if existing == nil {
+		existing = &database.Device{}
+	}
+
+	// Attach what the device announced over mDNS, keeping earlier answers
+	// when it has been quiet since
+	dev.Names = existing.Names
+	dev.MDNSServices = existing.MDNSServices
+	if d.mdns != nil {
+		d.applyMDNS(dev)
+	}
+
+	dev.DHCP = existing.DHCP
+	if d.dhcp != nil {
+		if info, ok := d.dhcp.Lookup(dev.MAC); ok {
+			applyDHCP(dev, info)
+		}
+	}
+
+	// Resolve names of the primary address; sources that do not answer keep
+	// their earlier name
+	dev.Names = names.Merge(dev.Names, d.resolver.Resolve(ctx, dev.IP, !d.passive)...)
+	dev.Hostname = names.Primary(dev.Names)
+	if ctx.Err() != nil {
+		return
+	}
+	dev.UPnP = existing.UPnP
+	if d.ssdp != nil {
+		d.applySSDP(ctx, dev)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+
+	// Agents that stop answering keep their last reported details
+	dev.SNMP = existing.SNMP
+	dev.SwitchPort = existing.SwitchPort
+	if d.snmp != nil {
+		d.pollSNMP(ctx, dev)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+
+	// Passive mode never sends traffic to the device
+	if d.passive {
+		scanner.IdentifyDevicePassive(dev)
+	} else if err := scanner.IdentifyDeviceContext(ctx, dev, profile, timing, nil); err != nil {
+		// Interrupted: a partial scan must not overwrite what is known
+		return
+	}
+
+	if len(dev.MetricsURLs) > 0 {
+		log.Printf("Found metrics at: %v on %s", dev.MetricsURLs, dev.IP)
+	}
+
+	// Ports of the profile take the result of this scan, so TCP and UDP
+	// services that stopped answering are closed. Ports outside it, e.g.
+	// found by a full port scan, are kept.
+	var scanned []int
+	if !d.passive {
+		scanned = profile.PortList()
+		scanner.CloseMissingServices(existing.Services, "tcp", scanned, dev.OpenPorts)
+		scanner.CloseMissingServices(existing.Services, "udp", profile.UDPPortList(), dev.OpenUDPPorts())
+	}
+	dev.OpenPorts = mergeOpenPorts(existing.OpenPorts, dev.OpenPorts, scanned)
+
+	// Keep services identified earlier, e.g. by a full port scan
+	dev.Services = scanner.MergeServices(existing.Services, dev.Services)
+
+	// Classify again now that earlier services are known as well
+	scanner.ClassifyDevice(dev)
+
+	// Check for vulnerabilities
+	dev.Vulnerabilities = security.CheckDevice(dev)
+
+	// Save to database
+	if err := d.inventory.UpsertDevice(dev); err != nil {
+		log.Printf("Failed to save device %s: %v", dev.IP, err)
+	}
+
+	// Drop the records created while this host's MAC was unresolved
+	if !scanner.IsPlaceholderMAC(dev.MAC) {
+		for _, addr := range dev.Addresses {
+			if err := d.inventory.RemovePlaceholderDevice(addr); err != nil {
+				log.Printf("Failed to remove placeholder for %s: %v", addr, err)
+			}
+		}
+	}
+}
+
+// applyMDNS sets the mDNS name and advertised services heard from any of the
+// device's addresses
+func (d *daemon) applyMDNS(dev *database.Device) {
+	var services []database.MDNSService
+	seen := make(map[string]bool)
+	for _, addr := range dev.Addresses {
+		host, ok := d.mdns.Lookup(addr)
+		if !ok {
+			continue
+		}
+		if host.Hostname != "" {
+			dev.Names = names.Merge(dev.Names, database.HostName{Name: host.Hostname, Source: database.NameSourceMDNS})
+		}
+		// IPv4 and IPv6 addresses of a host report the same instances
+		for _, svc := range host.Services {
+			if key := svc.Name + "." + svc.Type; !seen[key] {
+				seen[key] = true
+				services = append(services, svc)
+			}
+		}
+	}
+	if len(services) > 0 {
+		dev.MDNSServices = services
+	}
+}
+
+// applySSDP sets the UPnP description announced by any of the device's
+// addresses. Outside passive mode the description is fetched from the device.
+func (d *daemon) applySSDP(ctx context.Context, dev *database.Device) {
+	for _, addr := range dev.Addresses {
+		var info *database.UPnPInfo
+		var ok bool
+		if d.passive {
+			info, ok = d.ssdp.Lookup(addr)
+		} else {
+			info, ok = d.ssdp.Describe(ctx, addr, 3*time.Second)
+		}
+		if !ok {
+			continue
+		}
+		// Keep the stored description when only the announcement is known
+		if info.DeviceType == "" && dev.UPnP != nil && dev.UPnP.DeviceType != "" {
+			dev.UPnP.Server = info.Server
+			return
+		}
+		dev.UPnP = info
+		return
+	}
+}
+
+// pollSNMP reads the device's SNMP agent and stores the forwarding table it
+// reports when it is a switch
+func (d *daemon) pollSNMP(ctx context.Context, dev *database.Device) {
+	info, entries, err := d.snmp.Poll(ctx, dev.IP)
+	if ctx.Err() != nil {
+		return // Interrupted: the agent did not fail
+	}
+	if err != nil {
+		log.Printf("SNMP poll of %s failed: %v", dev.IP, err)
+		return
+	}
+	if info == nil {
+		return // No credentials for this address
+	}
+	dev.SNMP = info
+
+	if !scanner.IsPlaceholderMAC(dev.MAC) {
+		if err := database.SaveFDBEntries(dev.MAC, entries); err != nil {
+			log.Printf("Failed to save forwarding table of %s: %v", dev.IP, err)
+		}
+	}
+}
+
+// locateDevices sets the switch port of every device found in the stored
+// forwarding tables. Devices that aged out of the tables keep their last
+// known port.
+func (d *daemon) locateDevices(discovered []*database.Device) {
+	entries, err := database.GetFDBEntries()
+	if err != nil {
+		log.Printf("Failed to load forwarding tables: %v", err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	locations := snmp.LocatePorts(entries, d.inventory.GetDevice)
+	for mac, location := range locations {
+		dev := d.inventory.GetDevice(mac)
+		if dev == nil || (dev.SwitchPort != nil && *dev.SwitchPort == *location) {
+			continue
+		}
+		if err := d.inventory.UpdateSwitchPort(mac, location); err != nil {
+			log.Printf("Failed to save switch port of %s: %v", mac, err)
+		}
+	}
+	for _, dev := range discovered {
+		if location, ok := locations[dev.MAC]; ok {
+			dev.SwitchPort = location
+		}
+	}
+}
+
+// handleDHCP records a DHCP message. Clients are saved even if they never
+// answer a probe, as soon as their address is known.
+func (d *daemon) handleDHCP(packet *dhcp.Packet) {
+	// Servers' offers and NAKs say nothing about the client yet
+	if !packet.FromClient() && packet.MessageType != dhcp.Ack {
+		return
+	}
+
+	dev := d.inventory.GetDevice(packet.ClientMAC)
+	addr := packet.Address()
+	if dev == nil {
+		if addr == "" {
+			// Wait for a REQUEST or ACK with the client's address
+			return
+		}
+		dev = &database.Device{
+			MAC:       packet.ClientMAC,
+			Addresses: []string{addr},
+			FirstSeen: time.Now(),
+		}
+		scanner.IdentifyDevicePassive(dev)
+	}
+
+	if addr != "" && addr != dev.IP {
+		dev.IP = addr
+		if !containsAddress(dev.Addresses, addr) {
+			dev.Addresses = append([]string{addr}, dev.Addresses...)
+		}
+	}
+
+	if packet.FromClient() {
+		info := database.DHCPInfo{}
+		if dev.DHCP != nil {
+			info = *dev.DHCP
+		}
+		dhcp.ApplyPacket(&info, packet)
+		applyDHCP(dev, info)
+	}
+	dev.Hostname = names.Primary(dev.Names)
+	dev.LastSeen = time.Now()
+
+	scanner.ClassifyDevice(dev)
+	dev.Vulnerabilities = security.CheckDevice(dev)
+	if err := d.inventory.UpsertDevice(dev); err != nil {
+		log.Printf("Failed to save DHCP client %s: %v", dev.MAC, err)
+		return
+	}
+
+	// Lease requests and releases become history entries
+	switch packet.MessageType {
+	case dhcp.Request, dhcp.Release, dhcp.Decline, dhcp.Inform:
+		if err := history.RecordDeviceState(dev, "dhcp_"+packet.TypeName()); err != nil {
+			log.Printf("Failed to record DHCP event: %v", err)
+		}
+	}
+}
+
+// applyDHCP stores the DHCP details of a device and its DHCP hostname
+func applyDHCP(dev *database.Device, info database.DHCPInfo) {
+	dev.DHCP = &info
+	if info.Hostname != "" {
+		dev.Names = names.Merge(dev.Names, database.HostName{Name: info.Hostname, Source: database.NameSourceDHCP})
+	}
+}
+
+// containsAddress reports whether addr is in addresses
+func containsAddress(addresses []string, addr string) bool {
+	for _, a := range addresses {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeOpenPorts returns the ports found open plus the previously open
+// ports that were not scanned, sorted
+func mergeOpenPorts(previous, found, scanned []int) []int {
+	inScan := make(map[int]bool, len(scanned))
+	for _, port := range scanned {
+		inScan[port] = true
+	}
+
+	portMap := make(map[int]bool, len(previous)+len(found))
+	for _, port := range previous {
+		if !inScan[port] {
+			portMap[port] = true
+		}
+	}
+	for _, port := range found {
+		portMap[port] = true
+	}
+
+	merged := make([]int, 0, len(portMap))
+	for port := range portMap {
+		merged = append(merged, port)
+	}
+	sort.Ints(merged)
+	return merged
+}
+
+// currentDevices returns the union of the latest results of all targets
+func (d *daemon) currentDevices() []*database.Device {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var devices []*database.Device
+	for _, targetDevices := range d.latest {
+		for _, dev := range targetDevices {
+			if !seen[dev.MAC] {
+				seen[dev.MAC] = true
+				devices = append(devices, dev)
+			}
+		}
+	}
+	return devices
+}
+
+// devicesInTarget returns the known devices whose IP belongs to a target
+func devicesInTarget(devices []*database.Device, target scanner.ScanTarget) []*database.Device {
+	scopes, err := target.Resolve()
+	if err != nil {
+		return nil
+	}
+
+	var matched []*database.Device
+	for _, dev := range devices {
+		if deviceInScopes(dev, scopes) {
+			matched = append(matched, dev)
+		}
+	}
+	return matched
+}
+
+// hasTarget reports whether a target with the given name is in targets
+func hasTarget(targets []scanner.ScanTarget, name string) bool {
+	for _, target := range targets {
+		if target.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceInScopes reports whether any address of a device belongs to the scopes
+func deviceInScopes(dev *database.Device, scopes []scanner.Scope) bool {
+	for _, addr := range dev.Addresses {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		for i := range scopes {
+			if scopes[i].Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
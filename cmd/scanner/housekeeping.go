@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"network-scanner-go/internal/database"
+	"network-scanner-go/internal/history"
+	"time"
+)
+
+// housekeeping computes daily statistics, prunes old data and records
+// network snapshots
+type housekeeping struct {
+	inventory                 *database.Inventory
+	historyRetentionDays      int
+	notificationRetentionDays int
+	metricsRetentionDays      int
+
+	lastStatsDay     string
+	lastSnapshotTime time.Time
+}
+
+// run does the work that is due at now. current are the devices found by
+// the latest scans.
+func (h *housekeeping) run(now time.Time, current []*database.Device) {
+	// Check for daily stats calculation
+	// Run if it's a new day since last calculation
+	currentDay := now.Format("2006-01-02")
+	if h.lastStatsDay != currentDay {
+		log.Printf("New day detected (%s). Calculating daily statistics...", currentDay)
+		if _, err := history.CalculateDailyStats(now, h.inventory); err != nil {
+			log.Printf("Failed to calculate daily stats: %v", err)
+		} else {
+			h.lastStatsDay = currentDay
+		}
+
+		// Clean old history data
+		if err := history.CleanOldHistory(h.historyRetentionDays); err != nil {
+			log.Printf("Failed to clean old history: %v", err)
+		}
+
+		// Clean old notifications
+		if err := database.DeleteOldNotifications(h.notificationRetentionDays); err != nil {
+			log.Printf("Failed to clean old notifications: %v", err)
+		}
+
+		// Clean old port scans
+		if err := database.DeleteOldScanJobs(h.historyRetentionDays); err != nil {
+			log.Printf("Failed to clean old port scans: %v", err)
+		}
+
+		// Clean old device metrics
+		if err := database.DeleteOldMetricSamples(h.metricsRetentionDays); err != nil {
+			log.Printf("Failed to clean old metrics: %v", err)
+		}
+	}
+
+	// Record network snapshot periodically (e.g., every hour)
+	if now.Sub(h.lastSnapshotTime) >= 1*time.Hour && len(current) > 0 {
+		log.Println("Recording hourly network snapshot...")
+		if err := history.RecordNetworkSnapshot(current); err != nil {
+			log.Printf("Failed to record network snapshot: %v", err)
+		}
+		h.lastSnapshotTime = now
+	}
+}
@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"network-scanner-go/internal/database"
-	"network-scanner-go/internal/history"
+	"network-scanner-go/internal/discovery/dhcp"
+	"network-scanner-go/internal/discovery/mdns"
+	"network-scanner-go/internal/discovery/names"
+	"network-scanner-go/internal/discovery/ssdp"
+	"network-scanner-go/internal/metrics"
 	"network-scanner-go/internal/notifications"
+	"network-scanner-go/internal/scanjobs"
 	"network-scanner-go/internal/scanner"
 	"network-scanner-go/internal/security"
+	"network-scanner-go/internal/snmp"
+	"network-scanner-go/internal/vendor"
 	"network-scanner-go/internal/web"
-	"sync"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
 	"time"
 )
 
@@ -17,13 +29,27 @@ func main() {
 	// Parse command line flags
 	ipRange := flag.String("range", "", "IP range to scan (e.g., 192.168.1.0/24)")
 	interval := flag.Int("interval", 60, "Scan interval in seconds")
+	targetsPath := flag.String("targets", scanner.GetDefaultTargetsPath(), "Scan targets file (ignored when -range is set)")
 	webPort := flag.String("web-port", "5050", "Web interface port")
 	dbPath := flag.String("db", "scanner.db", "Database file path")
+	arpTimeout := flag.Int("arp-timeout", 2000, "Time to wait for ARP replies in milliseconds")
+	passive := flag.Bool("passive", false, "Build inventory from the neighbour table without probing hosts")
+	dnsServer := flag.String("dns-server", "", "DNS server for reverse lookups (default: system resolver)")
+	snmpEnabled := flag.Bool("snmp", false, "Poll SNMP agents using the credentials configured for their subnet")
+	scanWorkers := flag.Int("scan-workers", 2, "Number of on-demand port scans that run at the same time")
+	maxPPS := flag.Int("max-pps", 500, "Maximum probe packets per second across all scans (0 = unlimited)")
+	maxSockets := flag.Int("max-sockets", 256, "Maximum sockets open for probing at the same time (0 = unlimited)")
+	metricsInterval := flag.Int("metrics-interval", 60, "Seconds between scrapes of Prometheus exporters found on devices (0 disables scraping)")
+	metricsRetentionDays := flag.Int("metrics-retention-days", 7, "Days to retain scraped device metrics")
+	secretKeyPath := flag.String("secret-key", "scanner.key", "File with the key that encrypts stored SNMP credentials (created if missing)")
 
 	// Notification flags
 	notifyNewDevices := flag.Bool("notify-new-devices", true, "Notify when new devices are detected")
 	notifyDisconnected := flag.Bool("notify-disconnected", true, "Notify when devices disconnect")
 	notifyPortChanges := flag.Bool("notify-port-changes", true, "Notify when port changes are detected")
+	notifyHostKeyChanges := flag.Bool("notify-host-key-changes", true, "Notify when the SSH host key of a device changes")
+	notifyCertExpiring := flag.Bool("notify-cert-expiring", true, "Notify when TLS certificates are about to expire")
+	certExpiryDays := flag.Int("cert-expiry-days", 30, "Days before expiry to notify about TLS certificates")
 	webhookURL := flag.String("webhook-url", "", "Webhook URL for notifications")
 	notificationRetentionDays := flag.Int("notification-retention", 7, "Days to retain notifications")
 
@@ -32,31 +58,48 @@ func main() {
 
 	flag.Parse()
 
+	// SIGINT and SIGTERM shut the daemon down, SIGHUP reloads its configuration
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
 	// Initialize database
+	database.SetSecretKeyPath(*secretKeyPath)
 	err := database.Init(*dbPath)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer database.Close()
 
-	// Detect network range if not specified
-	if *ipRange == "" {
-		detected, err := scanner.GetLocalNetwork()
-		if err != nil {
-			log.Printf("Failed to detect network: %v. Using default 192.168.1.0/24", err)
-			*ipRange = "192.168.1.0/24"
-		} else {
-			*ipRange = detected
-			log.Printf("Auto-detected network range: %s", *ipRange)
-		}
+	// Serve device reads from memory
+	inventory, err := database.LoadInventory()
+	if err != nil {
+		log.Fatalf("Failed to load devices: %v", err)
+	}
+	log.Printf("Loaded %d devices from database", inventory.CountDevices())
+
+	// Load custom port profiles
+	if err := scanner.LoadPortProfiles(scanner.GetDefaultPortProfilesPath()); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Failed to load port profiles: %v", err)
+	}
+
+	// Load device fingerprint rules
+	if err := scanner.LoadFingerprintRules(scanner.GetDefaultFingerprintRulesPath()); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Failed to load fingerprint rules: %v", err)
 	}
 
-	// Load existing devices
-	devices, err := database.GetAllDevices()
+	// Share one probing budget between all scans
+	scanner.SetProbeBudget(*maxPPS, *maxSockets)
+
+	// Load scan targets
+	defaultInterval := time.Duration(*interval) * time.Second
+	targets, err := loadTargets(*ipRange, *targetsPath)
 	if err != nil {
-		log.Printf("Failed to load devices: %v", err)
-	} else {
-		log.Printf("Loaded %d devices from database", len(devices))
+		log.Fatalf("Failed to load scan targets: %v", err)
+	}
+	if err := checkTargets(targets, defaultInterval); err != nil {
+		log.Fatal(err)
 	}
 
 	// Initialize notification system
@@ -73,195 +116,239 @@ func main() {
 		}
 	}
 
-	// Initialize change detector
-	detector := notifications.NewDetector()
-	if len(devices) > 0 {
-		detector.UpdateState(devices)
+	// Load security rules
+	if err := security.LoadRules(security.GetDefaultRulesPath()); err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Failed to load security rules: %v", err)
 	}
 
-	// Load security rules
-	security.LoadRules(security.GetDefaultRulesPath())
+	// Load refreshed vendor registry if one was generated with vendor-update
+	if err := vendor.LoadRegistry(vendor.GetDefaultRegistryPath()); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to load vendor registry: %v", err)
+	}
 
 	log.Println("Notification system initialized")
 
 	// Start web server in goroutine
-	server := web.NewServer(*webPort)
-	go func() {
-		if err := server.Start(); err != nil {
-			log.Fatalf("Web server failed: %v", err)
+	server := web.NewServer(*webPort, inventory)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Web server shutdown: %v", err)
 		}
 	}()
 
-	// Initialize history tracking
-	lastStatsDay := ""
-	lastSnapshotTime := time.Time{}
-
-	// Main scanning loop
-	for {
-		now := time.Now()
-		log.Printf("Starting network scan for %s", *ipRange)
-
-		// Discover devices
-		discoveredDevices, err := scanner.DiscoverDevices(*ipRange)
-		if err != nil {
-			log.Printf("Scan error: %v", err)
-			time.Sleep(time.Duration(*interval) * time.Second)
-			continue
-		}
+	// Run on-demand port scans queued through the API
+	scanJobs := scanjobs.NewManager(*scanWorkers, inventory, server.Broadcast)
+	server.SetScanJobs(scanJobs)
+	scanJobs.Start()
+	defer scanJobs.Stop()
 
-		log.Printf("Found %d active devices", len(discoveredDevices))
-
-		// Enrich devices in parallel
-		var wg sync.WaitGroup
-		for _, device := range discoveredDevices {
-			wg.Add(1)
-			go func(d *database.Device) {
-				defer wg.Done()
-
-				// Load existing device data to preserve previously discovered ports
-				existingDevices, _ := database.GetAllDevices()
-				var existingPorts []int
-				for _, existing := range existingDevices {
-					if existing.MAC == d.MAC {
-						existingPorts = existing.OpenPorts
-						break
-					}
-				}
-
-				scanner.IdentifyDevice(d)
-
-				if len(d.MetricsURLs) > 0 {
-					log.Printf("Found metrics at: %v on %s", d.MetricsURLs, d.IP)
-				}
-
-				// Merge ports: combine existing ports with newly discovered ports
-				if len(existingPorts) > 0 {
-					portMap := make(map[int]bool)
-					// Add existing ports
-					for _, port := range existingPorts {
-						portMap[port] = true
-					}
-					// Add newly discovered ports
-					for _, port := range d.OpenPorts {
-						portMap[port] = true
-					}
-					// Convert back to slice
-					mergedPorts := make([]int, 0, len(portMap))
-					for port := range portMap {
-						mergedPorts = append(mergedPorts, port)
-					}
-					// Sort ports
-					for i := 0; i < len(mergedPorts); i++ {
-						for j := i + 1; j < len(mergedPorts); j++ {
-							if mergedPorts[i] > mergedPorts[j] {
-								mergedPorts[i], mergedPorts[j] = mergedPorts[j], mergedPorts[i]
-							}
-						}
-					}
-					d.OpenPorts = mergedPorts
-				}
-
-				// Check for vulnerabilities
-				d.Vulnerabilities = security.CheckDevice(d.OpenPorts, d.Type)
-
-				// Save to database
-				if err := database.UpsertDevice(d); err != nil {
-					log.Printf("Failed to save device %s: %v", d.IP, err)
-				}
-			}(device)
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := server.Start(); err != nil {
+			serverErr <- err
 		}
+	}()
 
-		wg.Wait()
+	// Prefer a raw ARP sweep; falls back to ping when CAP_NET_RAW is missing
+	var discoverer scanner.Discoverer
+	if *passive {
+		discoverer = scanner.NewNeighborDiscoverer()
+	} else {
+		arpConfig := scanner.DefaultARPConfig()
+		arpConfig.Timeout = time.Duration(*arpTimeout) * time.Millisecond
+		discoverer = scanner.NewDiscoverer(arpConfig)
+	}
+	log.Printf("Using %s discovery", discoverer.Name())
 
-		server.Broadcast(map[string]interface{}{
-			"type": "discovery_complete",
-			"data": discoveredDevices,
-		})
+	// Listen for mDNS announcements to learn hostnames and advertised services
+	browser := mdns.NewBrowser()
+	if err := browser.Start(); err != nil {
+		log.Printf("Warning: mDNS listener disabled: %v", err)
+		browser = nil
+	} else {
+		defer browser.Stop()
+	}
 
-		// Detect and notify changes
-		previousDevices := make([]*database.Device, 0)
-		for _, dev := range detector.GetPreviousDevices() {
-			previousDevices = append(previousDevices, dev)
-		}
+	// Listen for SSDP announcements from UPnP devices
+	ssdpListener := ssdp.NewListener()
+	if err := ssdpListener.Start(); err != nil {
+		log.Printf("Warning: SSDP listener disabled: %v", err)
+		ssdpListener = nil
+	} else {
+		defer ssdpListener.Stop()
+	}
 
-		changes := detector.CompareDeviceStates(previousDevices, discoveredDevices)
-		for _, change := range changes {
-			// Check if notification is enabled for this type
-			shouldNotify := false
-			switch change.Type {
-			case "new_device":
-				shouldNotify = *notifyNewDevices
-			case "disconnected":
-				shouldNotify = *notifyDisconnected
-			case "port_change":
-				shouldNotify = *notifyPortChanges
-			}
+	d := &daemon{
+		inventory:           inventory,
+		server:              server,
+		notificationManager: notificationManager,
+		discoverer:          discoverer,
+		defaultInterval:     defaultInterval,
+		passive:             *passive,
+		mdns:                browser,
+		ssdp:                ssdpListener,
+		resolver:            names.NewResolver(*dnsServer, time.Second),
+		notifyNewDevices:    *notifyNewDevices,
+		notifyDisconnected:  *notifyDisconnected,
+		notifyPortChanges:   *notifyPortChanges,
+		notifyCertExpiring:  *notifyCertExpiring,
+		notifyHostKeys:      *notifyHostKeyChanges,
+		certExpiryWindow:    time.Duration(*certExpiryDays) * 24 * time.Hour,
+		latest:              make(map[string][]*database.Device),
+	}
+	if *snmpEnabled && !*passive {
+		d.snmp = snmp.NewPoller(2 * time.Second)
+		log.Println("SNMP polling enabled")
+	}
 
-			if shouldNotify {
-				if err := notificationManager.NotifyChange(change); err != nil {
-					log.Printf("Failed to send notification: %v", err)
-				}
-				server.Broadcast(map[string]interface{}{
-					"type": "notification",
-					"data": change,
-				})
-			}
-		}
+	// Scrape the Prometheus exporters found during scans
+	if *metricsInterval > 0 && !*passive {
+		scraper := metrics.NewScraper(time.Duration(*metricsInterval)*time.Second, inventory)
+		scraper.Start()
+		defer scraper.Stop()
+	}
 
-		// Update detector state
-		detector.UpdateState(discoveredDevices)
+	// Snoop DHCP broadcasts to learn about hosts even when they ignore probes
+	snooper := dhcp.NewSnooper(d.handleDHCP)
+	if err := snooper.Start(); err != nil {
+		log.Printf("Warning: DHCP snooping disabled: %v", err)
+	} else {
+		d.dhcp = snooper
+		defer snooper.Stop()
+	}
 
-		// Record network snapshot for historical tracking
-		if err := history.RecordNetworkSnapshot(discoveredDevices); err != nil {
-			log.Printf("Failed to record network snapshot: %v", err)
-		}
+	// Scan the targets until shutdown, or until a reload changes them
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var stopTargets context.CancelFunc
+	var targetsDone chan struct{}
+	startTargets := func(known []*database.Device) {
+		var targetsCtx context.Context
+		targetsCtx, stopTargets = context.WithCancel(ctx)
+		targetsDone = make(chan struct{})
+		go func() {
+			defer close(targetsDone)
+			d.runTargets(targetsCtx, targets, known)
+		}()
+	}
+	startTargets(inventory.GetAllDevices())
 
-		// Record individual device changes
-		for _, change := range changes {
-			changeType := "update"
-			switch change.Type {
-			case "new_device":
-				changeType = "new"
-			case "disconnected":
-				changeType = "disconnect"
+	housekeeper := &housekeeping{
+		inventory:                 inventory,
+		historyRetentionDays:      *historyRetentionDays,
+		notificationRetentionDays: *notificationRetentionDays,
+		metricsRetentionDays:      *metricsRetentionDays,
+	}
+	housekeeper.run(time.Now(), d.currentDevices())
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case now := <-ticker.C:
+			housekeeper.run(now, d.currentDevices())
+
+		case <-hangups:
+			log.Println("Received SIGHUP, reloading configuration")
+			reloadConfig()
+
+			reloaded, err := loadTargets(*ipRange, *targetsPath)
+			if err == nil {
+				err = checkTargets(reloaded, defaultInterval)
 			}
-			if err := history.RecordDeviceState(change.Device, changeType); err != nil {
-				log.Printf("Failed to record device change: %v", err)
+			if err != nil {
+				log.Printf("Keeping the current scan targets: %v", err)
+				break
 			}
+			if reflect.DeepEqual(reloaded, targets) {
+				break
+			}
+			log.Println("Scan targets changed, restarting scans")
+			stopTargets()
+			<-targetsDone
+			targets = reloaded
+			startTargets(inventory.GetAllDevices())
+
+		case sig := <-shutdown:
+			log.Printf("Received %v, shutting down", sig)
+			running = false
+
+		case err := <-serverErr:
+			log.Printf("Web server failed: %v", err)
+			running = false
 		}
+	}
 
-		// Check for daily stats calculation
-		// Run if it's a new day since last calculation
-		currentDay := now.Format("2006-01-02")
-		if lastStatsDay != currentDay {
-			log.Printf("New day detected (%s). Calculating daily statistics...", currentDay)
-			if _, err := history.CalculateDailyStats(now); err != nil {
-				log.Printf("Failed to calculate daily stats: %v", err)
-			} else {
-				lastStatsDay = currentDay
-			}
+	// A second signal kills the process right away
+	signal.Stop(shutdown)
 
-			// Clean old history data
-			if err := history.CleanOldHistory(*historyRetentionDays); err != nil {
-				log.Printf("Failed to clean old history: %v", err)
-			}
+	// Cancel the scans in flight; the deferred calls then stop the listeners,
+	// the scan jobs and the web server, drain the notification queue and
+	// checkpoint the database, in that order
+	cancel()
+	<-targetsDone
+}
 
-			// Clean old notifications
-			if err := database.DeleteOldNotifications(*notificationRetentionDays); err != nil {
-				log.Printf("Failed to clean old notifications: %v", err)
-			}
+// checkTargets validates the port profile and timing of every target
+func checkTargets(targets []scanner.ScanTarget, defaultInterval time.Duration) error {
+	for _, target := range targets {
+		if _, err := scanner.ResolvePortProfile(target.PortProfile); err != nil {
+			return fmt.Errorf("scan target %q: %w", target.Name, err)
 		}
-
-		// Record network snapshot periodically (e.g., every hour)
-		if now.Sub(lastSnapshotTime) >= 1*time.Hour {
-			log.Println("Recording hourly network snapshot...")
-			if err := history.RecordNetworkSnapshot(discoveredDevices); err != nil {
-				log.Printf("Failed to record network snapshot: %v", err)
-			}
-			lastSnapshotTime = now
+		if _, err := scanner.ResolveTiming(target.Timing); err != nil {
+			return fmt.Errorf("scan target %q: %w", target.Name, err)
+		}
+		if _, err := target.Resolve(); err != nil {
+			// Interfaces may come up later, so this is retried on every scan
+			log.Printf("Warning: %v", err)
 		}
+		log.Printf("Scan target %q: %v every %s", target.Name, target.Ranges, target.IntervalDuration(defaultInterval))
+	}
+	return nil
+}
+
+// reloadConfig reloads the port profiles, fingerprint rules, security rules
+// and vendor registry. A file that fails to load keeps its previous settings.
+func reloadConfig() {
+	if err := scanner.LoadPortProfiles(scanner.GetDefaultPortProfilesPath()); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to reload port profiles: %v", err)
+	}
+	if err := scanner.LoadFingerprintRules(scanner.GetDefaultFingerprintRulesPath()); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to reload fingerprint rules: %v", err)
+	}
+	if err := security.LoadRules(security.GetDefaultRulesPath()); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to reload security rules: %v", err)
+	}
+	if err := vendor.LoadRegistry(vendor.GetDefaultRegistryPath()); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to reload vendor registry: %v", err)
+	}
+}
 
-		log.Printf("Scan complete. Sleeping for %d seconds...", *interval)
-		time.Sleep(time.Duration(*interval) * time.Second)
+// loadTargets returns the scan targets for this run: -range wins,
+// then the targets file, then the auto-detected local network
+func loadTargets(ipRange, targetsPath string) ([]scanner.ScanTarget, error) {
+	if ipRange != "" {
+		return []scanner.ScanTarget{{Name: "default", Ranges: []string{ipRange}}}, nil
+	}
+
+	targets, err := scanner.LoadTargets(targetsPath)
+	if err == nil {
+		log.Printf("Loaded %d scan targets from %s", len(targets), targetsPath)
+		return targets, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Scan the whole default interface, IPv4 and IPv6
+	detected, err := scanner.GetLocalInterface()
+	if err != nil {
+		log.Printf("Failed to detect network: %v. Using default 192.168.1.0/24", err)
+		detected = "192.168.1.0/24"
+	} else {
+		log.Printf("Auto-detected network interface: %s", detected)
 	}
+	return []scanner.ScanTarget{{Name: "default", Ranges: []string{detected}}}, nil
 }
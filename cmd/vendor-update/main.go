@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"network-scanner-go/internal/database"
+	"network-scanner-go/internal/vendor"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ieeeExports are the MA-L, MA-M and MA-S registries published by the IEEE
+// Registration Authority
+var ieeeExports = []string{
+	"https://standards-oui.ieee.org/oui/oui.csv",
+	"https://standards-oui.ieee.org/oui28/mam.csv",
+	"https://standards-oui.ieee.org/oui36/oui36.csv",
+}
+
+// vendor-update rebuilds the vendor registry from IEEE CSV exports
+// (oui.csv, mam.csv, oui36.csv downloaded from standards-oui.ieee.org)
+func main() {
+	csvFiles := flag.String("csv", "", "Comma-separated list of IEEE registry CSV files")
+	download := flag.Bool("download", false, "Download the MA-L, MA-M and MA-S exports from standards-oui.ieee.org instead of reading -csv files")
+	outPath := flag.String("out", vendor.GetDefaultRegistryPath(), "Output registry file")
+	dbPath := flag.String("db", "", "Database whose vendor cache should be cleared (optional)")
+	flag.Parse()
+
+	if *csvFiles == "" && !*download {
+		log.Fatalf("No input files given. Usage: vendor-update -csv oui.csv,mam.csv,oui36.csv or vendor-update -download")
+	}
+
+	registry := vendor.NewRegistry()
+	if *download {
+		for _, url := range ieeeExports {
+			if err := downloadExport(registry, url); err != nil {
+				log.Fatalf("Failed to download %s: %v", url, err)
+			}
+		}
+	}
+	for _, path := range strings.Split(*csvFiles, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", path, err)
+		}
+		count, err := registry.ReadCSV(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", path, err)
+		}
+		log.Printf("Read %d assignments from %s", count, path)
+	}
+
+	if registry.Len() == 0 {
+		log.Fatalf("No assignments found, refusing to write an empty registry")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*outPath), 0755); err != nil {
+		log.Fatalf("Failed to create output directory: %v", err)
+	}
+
+	// Write to a temporary file first so a failed run never leaves a truncated registry
+	tmpPath := *outPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", tmpPath, err)
+	}
+	if err := registry.WriteCSV(out); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		log.Fatalf("Failed to write registry: %v", err)
+	}
+	out.Close()
+
+	if err := os.Rename(tmpPath, *outPath); err != nil {
+		log.Fatalf("Failed to replace %s: %v", *outPath, err)
+	}
+	log.Printf("Wrote %d assignments to %s", registry.Len(), *outPath)
+
+	// Cached lookups may refer to outdated organization names
+	if *dbPath != "" {
+		if err := database.Init(*dbPath); err != nil {
+			log.Fatalf("Failed to open database: %v", err)
+		}
+		defer database.Close()
+
+		if err := database.ClearVendorCache(); err != nil {
+			log.Fatalf("Failed to clear vendor cache: %v", err)
+		}
+		log.Println("Cleared vendor cache")
+	}
+}
+
+// downloadExport reads the assignments of one IEEE export into registry
+func downloadExport(registry *vendor.Registry, url string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	// standards-oui.ieee.org refuses requests without a browser-like User-Agent
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; network-scanner vendor-update)")
+
+	client := &http.Client{Timeout: 2 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	count, err := registry.ReadCSV(resp.Body)
+	if err != nil {
+		return err
+	}
+	log.Printf("Read %d assignments from %s", count, url)
+	return nil
+}
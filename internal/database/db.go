@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"network-scanner-go/internal/telemetry"
 	"sync"
 	"time"
 
@@ -14,6 +15,14 @@ import (
 var db *sql.DB
 var dbMu sync.Mutex
 
+var queryDuration = telemetry.NewHistogram("scanner_db_query_duration_seconds", "Time taken by database operations, including waiting for the write lock.", telemetry.DefaultBuckets, "query")
+
+// ObserveQuery records the latency of a database operation started at start.
+// Packages that query GetDB directly use it as well.
+func ObserveQuery(name string, start time.Time) {
+	queryDuration.ObserveDuration(start, name)
+}
+
 // GetDB returns the database connection
 func GetDB() *sql.DB {
 	return db
@@ -48,9 +57,21 @@ func Init(dbPath string) error {
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			mac TEXT UNIQUE NOT NULL,
 			ip TEXT NOT NULL,
+			addresses TEXT,
 			custom_name TEXT,
+			hostname TEXT,
+			names TEXT,
 			vendor TEXT,
 			type TEXT,
+			os TEXT,
+			model TEXT,
+			confidence REAL DEFAULT 0,
+			evidence TEXT,
+			mdns_services TEXT,
+			upnp TEXT,
+			dhcp TEXT,
+			snmp TEXT,
+			switch_port TEXT,
 			custom_type TEXT,
 			is_known INTEGER DEFAULT 0,
 			tags TEXT,
@@ -59,6 +80,8 @@ func Init(dbPath string) error {
 			open_ports TEXT,
 			vulnerabilities TEXT,
 			metrics_urls TEXT,
+			rtt_ms REAL DEFAULT 0,
+			ttl INTEGER DEFAULT 0,
 			last_seen INTEGER NOT NULL,
 			first_seen INTEGER DEFAULT 0
 		);
@@ -96,10 +119,83 @@ func Init(dbPath string) error {
 			hostname TEXT,
 			vendor TEXT,
 			open_ports TEXT,
+			rtt_ms REAL DEFAULT 0,
+			ttl INTEGER DEFAULT 0,
 			timestamp INTEGER NOT NULL,
 			change_type TEXT NOT NULL
 		);
 
+		CREATE TABLE IF NOT EXISTS device_services (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_mac TEXT NOT NULL,
+			protocol TEXT NOT NULL,
+			port INTEGER NOT NULL,
+			state TEXT NOT NULL,
+			name TEXT,
+			product TEXT,
+			version TEXT,
+			banner TEXT,
+			tls TEXT,
+			http TEXT,
+			ssh TEXT,
+			first_seen INTEGER NOT NULL,
+			last_seen INTEGER NOT NULL,
+			UNIQUE(device_mac, protocol, port)
+		);
+
+		CREATE TABLE IF NOT EXISTS snmp_credentials (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			subnet TEXT NOT NULL,
+			version TEXT NOT NULL,
+			community TEXT,
+			user_name TEXT
+		);
+
+		CREATE TABLE IF NOT EXISTS snmp_users (
+			name TEXT PRIMARY KEY,
+			auth_protocol TEXT,
+			auth_passphrase TEXT,
+			priv_protocol TEXT,
+			priv_passphrase TEXT
+		);
+
+		CREATE TABLE IF NOT EXISTS fdb_entries (
+			switch_mac TEXT NOT NULL,
+			mac TEXT NOT NULL,
+			vlan INTEGER NOT NULL DEFAULT 0,
+			if_index INTEGER NOT NULL,
+			port TEXT,
+			last_seen INTEGER NOT NULL,
+			PRIMARY KEY(switch_mac, mac, vlan)
+		);
+
+		CREATE TABLE IF NOT EXISTS metric_samples (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_mac TEXT NOT NULL,
+			instance TEXT NOT NULL,
+			name TEXT NOT NULL,
+			labels TEXT,
+			value REAL NOT NULL,
+			timestamp INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS scan_jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			target TEXT NOT NULL,
+			profile TEXT NOT NULL,
+			status TEXT NOT NULL,
+			progress INTEGER DEFAULT 0,
+			current_port INTEGER DEFAULT 0,
+			total_ports INTEGER DEFAULT 0,
+			open_ports TEXT,
+			services TEXT,
+			requested_by TEXT,
+			error TEXT,
+			created_at INTEGER NOT NULL,
+			started_at INTEGER,
+			ended_at INTEGER
+		);
+
 		CREATE TABLE IF NOT EXISTS network_stats (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			date INTEGER NOT NULL UNIQUE,
@@ -126,6 +222,12 @@ func Init(dbPath string) error {
 		CREATE INDEX IF NOT EXISTS idx_notifications_read ON notifications(read);
 		CREATE INDEX IF NOT EXISTS idx_notifications_device_mac ON notifications(device_mac);
 		CREATE INDEX IF NOT EXISTS idx_device_history_mac ON device_history(device_mac);
+		CREATE INDEX IF NOT EXISTS idx_device_services_mac ON device_services(device_mac);
+		CREATE INDEX IF NOT EXISTS idx_fdb_entries_mac ON fdb_entries(mac);
+		CREATE INDEX IF NOT EXISTS idx_metric_samples_device ON metric_samples(device_mac, timestamp);
+		CREATE INDEX IF NOT EXISTS idx_metric_samples_timestamp ON metric_samples(timestamp);
+		CREATE INDEX IF NOT EXISTS idx_scan_jobs_status ON scan_jobs(status);
+		CREATE INDEX IF NOT EXISTS idx_scan_jobs_target ON scan_jobs(target);
 		CREATE INDEX IF NOT EXISTS idx_device_history_timestamp ON device_history(timestamp);
 		CREATE INDEX IF NOT EXISTS idx_network_stats_date ON network_stats(date);
 	`)
@@ -144,6 +246,24 @@ func Init(dbPath string) error {
 		"ALTER TABLE devices ADD COLUMN first_seen INTEGER DEFAULT 0",
 		"ALTER TABLE devices ADD COLUMN vulnerabilities TEXT",
 		"ALTER TABLE devices ADD COLUMN group_name TEXT",
+		"ALTER TABLE devices ADD COLUMN rtt_ms REAL DEFAULT 0",
+		"ALTER TABLE devices ADD COLUMN ttl INTEGER DEFAULT 0",
+		"ALTER TABLE device_history ADD COLUMN rtt_ms REAL DEFAULT 0",
+		"ALTER TABLE device_history ADD COLUMN ttl INTEGER DEFAULT 0",
+		"ALTER TABLE devices ADD COLUMN addresses TEXT",
+		"ALTER TABLE devices ADD COLUMN os TEXT",
+		"ALTER TABLE devices ADD COLUMN model TEXT",
+		"ALTER TABLE devices ADD COLUMN confidence REAL DEFAULT 0",
+		"ALTER TABLE devices ADD COLUMN evidence TEXT",
+		"ALTER TABLE devices ADD COLUMN hostname TEXT",
+		"ALTER TABLE devices ADD COLUMN mdns_services TEXT",
+		"ALTER TABLE devices ADD COLUMN upnp TEXT",
+		"ALTER TABLE devices ADD COLUMN names TEXT",
+		"ALTER TABLE devices ADD COLUMN dhcp TEXT",
+		"ALTER TABLE device_services ADD COLUMN http TEXT",
+		"ALTER TABLE device_services ADD COLUMN ssh TEXT",
+		"ALTER TABLE devices ADD COLUMN snmp TEXT",
+		"ALTER TABLE devices ADD COLUMN switch_port TEXT",
 	}
 
 	for _, query := range migrations {
@@ -155,45 +275,319 @@ func Init(dbPath string) error {
 	// Backfill first_seen
 	db.Exec("UPDATE devices SET first_seen = last_seen WHERE first_seen = 0 OR first_seen IS NULL")
 
+	// Move the ports of devices scanned before device_services existed
+	if err := migrateOpenPorts(); err != nil {
+		log.Printf("Warning: failed to migrate open ports to device_services: %v", err)
+	}
+
 	log.Println("Database initialized successfully")
 	return nil
 }
 
-// UpsertDevice inserts or updates a device
-func UpsertDevice(device *Device) error {
+// UpsertDevice inserts or updates a device and its services in one
+// transaction
+func (inv *Inventory) UpsertDevice(device *Device) error {
+	defer ObserveQuery("UpsertDevice", time.Now())
+
 	dbMu.Lock()
 	defer dbMu.Unlock()
 
 	openPortsJSON, _ := json.Marshal(device.OpenPorts)
 	vulnerabilitiesJSON, _ := json.Marshal(device.Vulnerabilities)
 	metricsURLsJSON, _ := json.Marshal(device.MetricsURLs)
+	addressesJSON, _ := json.Marshal(device.Addresses)
+	evidenceJSON, _ := json.Marshal(device.Evidence)
+	mdnsServicesJSON, _ := json.Marshal(device.MDNSServices)
+	upnpJSON, _ := json.Marshal(device.UPnP)
+	namesJSON, _ := json.Marshal(device.Names)
+	dhcpJSON, _ := json.Marshal(device.DHCP)
+	snmpJSON, _ := json.Marshal(device.SNMP)
+	switchPortJSON, _ := json.Marshal(device.SwitchPort)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
 	// For new devices, first_seen should be set to last_seen/now
 	// For existing devices, we do NOT update custom fields
-	_, err := db.Exec(`
-		INSERT INTO devices (mac, ip, vendor, type, open_ports, vulnerabilities, metrics_urls, last_seen, first_seen, group_name)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	_, err = tx.Exec(`
+		INSERT INTO devices (mac, ip, addresses, hostname, names, vendor, type, os, model, confidence, evidence, mdns_services, upnp, dhcp, snmp, switch_port, open_ports, vulnerabilities, metrics_urls, rtt_ms, ttl, last_seen, first_seen, group_name)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(mac) DO UPDATE SET
 			ip = excluded.ip,
+			addresses = excluded.addresses,
+			hostname = excluded.hostname,
+			names = excluded.names,
 			vendor = excluded.vendor,
 			type = excluded.type,
+			os = excluded.os,
+			model = excluded.model,
+			confidence = excluded.confidence,
+			evidence = excluded.evidence,
+			mdns_services = excluded.mdns_services,
+			upnp = excluded.upnp,
+			dhcp = excluded.dhcp,
+			snmp = excluded.snmp,
+			switch_port = excluded.switch_port,
 			open_ports = excluded.open_ports,
 			vulnerabilities = excluded.vulnerabilities,
 			metrics_urls = excluded.metrics_urls,
+			rtt_ms = excluded.rtt_ms,
+			ttl = excluded.ttl,
 			last_seen = excluded.last_seen
-	`, device.MAC, device.IP, device.Vendor, device.Type,
-		string(openPortsJSON), string(vulnerabilitiesJSON), string(metricsURLsJSON), device.LastSeen.Unix(), device.LastSeen.Unix(), device.GroupName)
+	`, device.MAC, device.IP, string(addressesJSON), device.Hostname, string(namesJSON), device.Vendor, device.Type, device.OS, device.Model, device.Confidence, string(evidenceJSON), string(mdnsServicesJSON), string(upnpJSON), string(dhcpJSON),
+		string(snmpJSON), string(switchPortJSON), string(openPortsJSON), string(vulnerabilitiesJSON), string(metricsURLsJSON), device.RTT, device.TTL,
+		device.LastSeen.Unix(), device.LastSeen.Unix(), device.GroupName)
+	if err != nil {
+		return err
+	}
 
-	return err
+	if err := saveServices(tx, device.MAC, device.Services); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return inv.refresh(device.MAC)
 }
 
-// GetAllDevices retrieves all devices from the database
-func GetAllDevices() ([]*Device, error) {
+// saveServices upserts the services of a device, keeping first_seen of known
+// services. Stored services missing from the list are marked closed.
+func saveServices(tx *sql.Tx, mac string, services []Service) error {
+	stmt, err := tx.Prepare(`
+		INSERT INTO device_services (device_mac, protocol, port, state, name, product, version, banner, tls, http, ssh, first_seen, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(device_mac, protocol, port) DO UPDATE SET
+			state = excluded.state,
+			name = excluded.name,
+			product = excluded.product,
+			version = excluded.version,
+			banner = excluded.banner,
+			tls = excluded.tls,
+			http = excluded.http,
+			ssh = excluded.ssh,
+			last_seen = excluded.last_seen
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	listed := make(map[string]bool, len(services))
+	for _, service := range services {
+		listed[service.Key()] = true
+		var tlsJSON sql.NullString
+		if service.TLS != nil {
+			data, _ := json.Marshal(service.TLS)
+			tlsJSON = sql.NullString{String: string(data), Valid: true}
+		}
+		var httpJSON sql.NullString
+		if service.HTTP != nil {
+			data, _ := json.Marshal(service.HTTP)
+			httpJSON = sql.NullString{String: string(data), Valid: true}
+		}
+		var sshJSON sql.NullString
+		if service.SSH != nil {
+			data, _ := json.Marshal(service.SSH)
+			sshJSON = sql.NullString{String: string(data), Valid: true}
+		}
+		lastSeen := service.LastSeen
+		if lastSeen.IsZero() {
+			lastSeen = time.Now()
+		}
+		firstSeen := service.FirstSeen
+		if firstSeen.IsZero() {
+			firstSeen = lastSeen
+		}
+
+		if _, err := stmt.Exec(mac, service.Protocol, service.Port, service.State, service.Name, service.Product,
+			service.Version, service.Banner, tlsJSON, httpJSON, sshJSON, firstSeen.Unix(), lastSeen.Unix()); err != nil {
+			return err
+		}
+	}
+
+	return closeUnlistedServices(tx, mac, listed)
+}
+
+// closeUnlistedServices marks the stored services of a device that are not
+// in listed as closed
+func closeUnlistedServices(tx *sql.Tx, mac string, listed map[string]bool) error {
+	rows, err := tx.Query(`SELECT protocol, port FROM device_services WHERE device_mac = ? AND state != ?`, mac, StateClosed)
+	if err != nil {
+		return err
+	}
+	var missing []Service
+	for rows.Next() {
+		var service Service
+		if err := rows.Scan(&service.Protocol, &service.Port); err != nil {
+			rows.Close()
+			return err
+		}
+		if !listed[service.Key()] {
+			missing = append(missing, service)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, service := range missing {
+		if _, err := tx.Exec(`UPDATE device_services SET state = ?, last_seen = ? WHERE device_mac = ? AND protocol = ? AND port = ?`,
+			StateClosed, now, mac, service.Protocol, service.Port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryServices returns the services of the device with a MAC, or of every
+// device when mac is empty, keyed by MAC and ordered by protocol and port
+func queryServices(mac string) (map[string][]Service, error) {
+	where, args := "", []interface{}{}
+	if mac != "" {
+		where, args = "WHERE device_mac = ?", []interface{}{mac}
+	}
 	rows, err := db.Query(`
-		SELECT id, mac, ip, custom_name, vendor, type, custom_type, is_known, tags, notes, open_ports, vulnerabilities, metrics_urls, last_seen, first_seen, group_name
+		SELECT device_mac, protocol, port, state, name, product, version, banner, tls, http, ssh, first_seen, last_seen
+		FROM device_services
+		`+where+`
+		ORDER BY device_mac, protocol, port
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	services := make(map[string][]Service)
+	for rows.Next() {
+		var mac string
+		var service Service
+		var name, product, version, banner, tlsJSON, httpJSON, sshJSON sql.NullString
+		var firstSeen, lastSeen int64
+
+		if err := rows.Scan(&mac, &service.Protocol, &service.Port, &service.State, &name, &product,
+			&version, &banner, &tlsJSON, &httpJSON, &sshJSON, &firstSeen, &lastSeen); err != nil {
+			continue
+		}
+
+		service.Name = name.String
+		service.Product = product.String
+		service.Version = version.String
+		service.Banner = banner.String
+		if tlsJSON.Valid && tlsJSON.String != "" {
+			service.TLS = &TLSInfo{}
+			json.Unmarshal([]byte(tlsJSON.String), service.TLS)
+		}
+		if httpJSON.Valid && httpJSON.String != "" {
+			service.HTTP = &HTTPInfo{}
+			json.Unmarshal([]byte(httpJSON.String), service.HTTP)
+		}
+		if sshJSON.Valid && sshJSON.String != "" {
+			service.SSH = &SSHInfo{}
+			json.Unmarshal([]byte(sshJSON.String), service.SSH)
+		}
+		service.FirstSeen = time.Unix(firstSeen, 0)
+		service.LastSeen = time.Unix(lastSeen, 0)
+
+		services[mac] = append(services[mac], service)
+	}
+
+	return services, rows.Err()
+}
+
+// migrateOpenPorts creates open TCP services from the open_ports column of
+// devices that have no services yet
+func migrateOpenPorts() error {
+	rows, err := db.Query(`
+		SELECT mac, open_ports, first_seen, last_seen FROM devices
+		WHERE open_ports IS NOT NULL AND open_ports NOT IN ('', '[]', 'null')
+		AND mac NOT IN (SELECT DISTINCT device_mac FROM device_services)
+	`)
+	if err != nil {
+		return err
+	}
+
+	pending := make(map[string][]Service)
+	for rows.Next() {
+		var mac, openPortsJSON string
+		var firstSeen, lastSeen sql.NullInt64
+		if err := rows.Scan(&mac, &openPortsJSON, &firstSeen, &lastSeen); err != nil {
+			continue
+		}
+
+		var ports []int
+		json.Unmarshal([]byte(openPortsJSON), &ports)
+		for _, port := range ports {
+			pending[mac] = append(pending[mac], Service{
+				Protocol:  "tcp",
+				Port:      port,
+				State:     StateOpen,
+				FirstSeen: time.Unix(firstSeen.Int64, 0),
+				LastSeen:  time.Unix(lastSeen.Int64, 0),
+			})
+		}
+	}
+	rows.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for mac, services := range pending {
+		if err := saveServices(tx, mac, services); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		log.Printf("Migrated open ports of %d devices to device_services", len(pending))
+	}
+	return nil
+}
+
+// RemovePlaceholderDevice deletes the record created for an IP before its MAC was known
+func (inv *Inventory) RemovePlaceholderDevice(ip string) error {
+	defer ObserveQuery("RemovePlaceholderDevice", time.Now())
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	if _, err := db.Exec("DELETE FROM device_services WHERE device_mac = ?", "unknown_"+ip); err != nil {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM devices WHERE mac = ?", "unknown_"+ip); err != nil {
+		return err
+	}
+	inv.remove("unknown_" + ip)
+	return nil
+}
+
+// queryDevices reads the device with a MAC, or every device when mac is
+// empty, from the database
+func queryDevices(mac string) ([]*Device, error) {
+	services, err := queryServices(mac)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := "", []interface{}{}
+	if mac != "" {
+		where, args = "WHERE mac = ?", []interface{}{mac}
+	}
+
+	rows, err := db.Query(`
+		SELECT id, mac, ip, addresses, custom_name, hostname, names, vendor, type, os, model, confidence, evidence, mdns_services, upnp, dhcp, snmp, switch_port, custom_type, is_known, tags, notes, open_ports, vulnerabilities, metrics_urls, rtt_ms, ttl, last_seen, first_seen, group_name
 		FROM devices
+		`+where+`
 		ORDER BY last_seen DESC
-	`)
+	`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -203,13 +597,16 @@ func GetAllDevices() ([]*Device, error) {
 	for rows.Next() {
 		var device Device
 		var openPortsJSON, vulnerabilitiesJSON, metricsURLsJSON string
-		var tagsJSON sql.NullString
+		var tagsJSON, addressesJSON, hostname, namesJSON, osName, model, evidenceJSON, mdnsServicesJSON, upnpJSON, dhcpJSON, snmpJSON, switchPortJSON sql.NullString
+		var confidence sql.NullFloat64
 		var customName, customType, notes, groupName sql.NullString
+		var rtt sql.NullFloat64
+		var ttl sql.NullInt64
 		var lastSeenUnix int64
 		var firstSeenUnix sql.NullInt64
 
-		err := rows.Scan(&device.ID, &device.MAC, &device.IP, &customName, &device.Vendor,
-			&device.Type, &customType, &device.IsKnown, &tagsJSON, &notes, &openPortsJSON, &vulnerabilitiesJSON, &metricsURLsJSON, &lastSeenUnix, &firstSeenUnix, &groupName)
+		err := rows.Scan(&device.ID, &device.MAC, &device.IP, &addressesJSON, &customName, &hostname, &namesJSON, &device.Vendor,
+			&device.Type, &osName, &model, &confidence, &evidenceJSON, &mdnsServicesJSON, &upnpJSON, &dhcpJSON, &snmpJSON, &switchPortJSON, &customType, &device.IsKnown, &tagsJSON, &notes, &openPortsJSON, &vulnerabilitiesJSON, &metricsURLsJSON, &rtt, &ttl, &lastSeenUnix, &firstSeenUnix, &groupName)
 		if err != nil {
 			continue
 		}
@@ -229,8 +626,49 @@ func GetAllDevices() ([]*Device, error) {
 		if tagsJSON.Valid {
 			json.Unmarshal([]byte(tagsJSON.String), &device.Tags)
 		}
+		if addressesJSON.Valid {
+			json.Unmarshal([]byte(addressesJSON.String), &device.Addresses)
+		}
+		if len(device.Addresses) == 0 {
+			// Rows written before addresses were tracked
+			device.Addresses = []string{device.IP}
+		}
+		device.Hostname = hostname.String
+		if namesJSON.Valid {
+			json.Unmarshal([]byte(namesJSON.String), &device.Names)
+		}
+		if len(device.Names) == 0 && device.Hostname != "" {
+			// Rows written when only mDNS names were stored
+			device.Names = []HostName{{Name: device.Hostname, Source: NameSourceMDNS}}
+		}
+		device.OS = osName.String
+		device.Model = model.String
+		device.Confidence = confidence.Float64
+		if evidenceJSON.Valid {
+			json.Unmarshal([]byte(evidenceJSON.String), &device.Evidence)
+		}
+		if mdnsServicesJSON.Valid {
+			json.Unmarshal([]byte(mdnsServicesJSON.String), &device.MDNSServices)
+		}
+		if upnpJSON.Valid {
+			json.Unmarshal([]byte(upnpJSON.String), &device.UPnP)
+		}
+		if dhcpJSON.Valid {
+			json.Unmarshal([]byte(dhcpJSON.String), &device.DHCP)
+		}
+		if snmpJSON.Valid {
+			json.Unmarshal([]byte(snmpJSON.String), &device.SNMP)
+		}
+		if switchPortJSON.Valid {
+			json.Unmarshal([]byte(switchPortJSON.String), &device.SwitchPort)
+		}
+		device.RTT = rtt.Float64
+		device.TTL = int(ttl.Int64)
 
 		json.Unmarshal([]byte(openPortsJSON), &device.OpenPorts)
+		if device.Services = services[device.MAC]; len(device.Services) > 0 {
+			device.OpenPorts = device.OpenTCPPorts()
+		}
 		json.Unmarshal([]byte(vulnerabilitiesJSON), &device.Vulnerabilities)
 		json.Unmarshal([]byte(metricsURLsJSON), &device.MetricsURLs)
 		device.LastSeen = time.Unix(lastSeenUnix, 0)
@@ -247,7 +685,9 @@ func GetAllDevices() ([]*Device, error) {
 }
 
 // UpdateDeviceDetails updates the user-configurable details of a device
-func UpdateDeviceDetails(mac string, customName string, customType string, isKnown bool, tags []string, notes string, groupName string) error {
+func (inv *Inventory) UpdateDeviceDetails(mac string, customName string, customType string, isKnown bool, tags []string, notes string, groupName string) error {
+	defer ObserveQuery("UpdateDeviceDetails", time.Now())
+
 	dbMu.Lock()
 	defer dbMu.Unlock()
 
@@ -268,11 +708,13 @@ func UpdateDeviceDetails(mac string, customName string, customType string, isKno
 		return fmt.Errorf("device not found")
 	}
 
-	return nil
+	return inv.refresh(mac)
 }
 
 // GetCachedVendor retrieves a cached vendor lookup
 func GetCachedVendor(mac string) (string, bool) {
+	defer ObserveQuery("GetCachedVendor", time.Now())
+
 	var vendor string
 	err := db.QueryRow("SELECT vendor FROM vendor_cache WHERE mac = ?", mac).Scan(&vendor)
 	if err != nil {
@@ -283,26 +725,52 @@ func GetCachedVendor(mac string) (string, bool) {
 
 // SaveCachedVendor saves a vendor lookup to cache
 func SaveCachedVendor(mac, vendor string) error {
+	defer ObserveQuery("SaveCachedVendor", time.Now())
+
 	dbMu.Lock()
 	defer dbMu.Unlock()
 
+	// Rows that already hold the vendor are left alone
 	_, err := db.Exec(`
-		INSERT OR REPLACE INTO vendor_cache (mac, vendor, cached_at)
+		INSERT INTO vendor_cache (mac, vendor, cached_at)
 		VALUES (?, ?, ?)
+		ON CONFLICT(mac) DO UPDATE SET vendor = excluded.vendor, cached_at = excluded.cached_at
+		WHERE vendor != excluded.vendor
 	`, mac, vendor, time.Now().Unix())
 	return err
 }
 
-// Close closes the database connection
+// ClearVendorCache removes all cached vendor lookups
+func ClearVendorCache() error {
+	defer ObserveQuery("ClearVendorCache", time.Now())
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	_, err := db.Exec("DELETE FROM vendor_cache")
+	return err
+}
+
+// Close checkpoints the write-ahead log into the database file and closes
+// the connection, so the file is complete without its -wal companion
 func Close() error {
-	if db != nil {
-		return db.Close()
+	if db == nil {
+		return nil
 	}
-	return nil
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE);"); err != nil {
+		log.Printf("Warning: failed to checkpoint the WAL: %v", err)
+	}
+	return db.Close()
 }
 
 // SaveNotification saves a notification to the database
 func SaveNotification(notification *Notification) error {
+	defer ObserveQuery("SaveNotification", time.Now())
+
 	dbMu.Lock()
 	defer dbMu.Unlock()
 
@@ -323,6 +791,8 @@ func SaveNotification(notification *Notification) error {
 
 // GetAllNotifications retrieves all notifications
 func GetAllNotifications() ([]*Notification, error) {
+	defer ObserveQuery("GetAllNotifications", time.Now())
+
 	rows, err := db.Query(`
 		SELECT id, type, device_ip, device_mac, message, timestamp, read, severity
 		FROM notifications
@@ -356,6 +826,8 @@ func GetAllNotifications() ([]*Notification, error) {
 
 // GetUnreadNotifications retrieves unread notifications
 func GetUnreadNotifications() ([]*Notification, error) {
+	defer ObserveQuery("GetUnreadNotifications", time.Now())
+
 	rows, err := db.Query(`
 		SELECT id, type, device_ip, device_mac, message, timestamp, read, severity
 		FROM notifications
@@ -390,6 +862,8 @@ func GetUnreadNotifications() ([]*Notification, error) {
 
 // MarkNotificationAsRead marks a notification as read
 func MarkNotificationAsRead(id int) error {
+	defer ObserveQuery("MarkNotificationAsRead", time.Now())
+
 	dbMu.Lock()
 	defer dbMu.Unlock()
 
@@ -399,6 +873,8 @@ func MarkNotificationAsRead(id int) error {
 
 // DeleteNotification deletes a notification
 func DeleteNotification(id int) error {
+	defer ObserveQuery("DeleteNotification", time.Now())
+
 	dbMu.Lock()
 	defer dbMu.Unlock()
 
@@ -408,6 +884,8 @@ func DeleteNotification(id int) error {
 
 // MarkAllNotificationsAsRead marks all notifications as read
 func MarkAllNotificationsAsRead() error {
+	defer ObserveQuery("MarkAllNotificationsAsRead", time.Now())
+
 	dbMu.Lock()
 	defer dbMu.Unlock()
 	_, err := db.Exec("UPDATE notifications SET read = 1 WHERE read = 0")
@@ -416,6 +894,8 @@ func MarkAllNotificationsAsRead() error {
 
 // DeleteAllNotifications deletes all notifications
 func DeleteAllNotifications() error {
+	defer ObserveQuery("DeleteAllNotifications", time.Now())
+
 	dbMu.Lock()
 	defer dbMu.Unlock()
 	_, err := db.Exec("DELETE FROM notifications")
@@ -424,6 +904,8 @@ func DeleteAllNotifications() error {
 
 // DeleteOldNotifications deletes notifications older than N days
 func DeleteOldNotifications(days int) error {
+	defer ObserveQuery("DeleteOldNotifications", time.Now())
+
 	dbMu.Lock()
 	defer dbMu.Unlock()
 
@@ -434,6 +916,8 @@ func DeleteOldNotifications(days int) error {
 
 // GetNotificationConfig retrieves the notification configuration
 func GetNotificationConfig() (*NotificationConfig, error) {
+	defer ObserveQuery("GetNotificationConfig", time.Now())
+
 	var config NotificationConfig
 	var enabledChannelsJSON, emailConfigJSON, telegramConfigJSON string
 	var updatedAtUnix int64
@@ -471,6 +955,8 @@ func GetNotificationConfig() (*NotificationConfig, error) {
 
 // SaveNotificationConfig saves the notification configuration
 func SaveNotificationConfig(config *NotificationConfig) error {
+	defer ObserveQuery("SaveNotificationConfig", time.Now())
+
 	dbMu.Lock()
 	defer dbMu.Unlock()
 
@@ -489,8 +975,10 @@ func SaveNotificationConfig(config *NotificationConfig) error {
 
 // GetDeviceHistory retrieves the history of a specific device
 func GetDeviceHistory(mac string, from, to time.Time) ([]*DeviceHistory, error) {
+	defer ObserveQuery("GetDeviceHistory", time.Now())
+
 	query := `
-		SELECT id, device_mac, ip, hostname, vendor, open_ports, timestamp, change_type
+		SELECT id, device_mac, ip, hostname, vendor, open_ports, rtt_ms, ttl, timestamp, change_type
 		FROM device_history
 		WHERE device_mac = ? AND timestamp >= ? AND timestamp <= ?
 		ORDER BY timestamp DESC
@@ -508,13 +996,18 @@ func GetDeviceHistory(mac string, from, to time.Time) ([]*DeviceHistory, error)
 		var openPortsJSON string
 		var timestampUnix int64
 		var hostname sql.NullString
+		var rtt sql.NullFloat64
+		var ttl sql.NullInt64
 
 		err := rows.Scan(&h.ID, &h.DeviceMAC, &h.IP, &hostname, &h.Vendor,
-			&openPortsJSON, &timestampUnix, &h.ChangeType)
+			&openPortsJSON, &rtt, &ttl, &timestampUnix, &h.ChangeType)
 		if err != nil {
 			continue
 		}
 
+		h.RTT = rtt.Float64
+		h.TTL = int(ttl.Int64)
+
 		if hostname.Valid {
 			h.Hostname = hostname.String
 		}
@@ -530,6 +1023,8 @@ func GetDeviceHistory(mac string, from, to time.Time) ([]*DeviceHistory, error)
 
 // GetNetworkTrends retrieves network statistics for the last N days
 func GetNetworkTrends(days int) ([]*NetworkStats, error) {
+	defer ObserveQuery("GetNetworkTrends", time.Now())
+
 	startDate := time.Now().AddDate(0, 0, -days)
 
 	query := `
@@ -563,8 +1058,11 @@ func GetNetworkTrends(days int) ([]*NetworkStats, error) {
 	return trends, nil
 }
 
-// CalculateDailyStats calculates and stores daily statistics
-func CalculateDailyStats(date time.Time) (*NetworkStats, error) {
+// CalculateDailyStats calculates and stores daily statistics. Device and
+// port totals are taken from devices.
+func CalculateDailyStats(date time.Time, devices *Inventory) (*NetworkStats, error) {
+	defer ObserveQuery("CalculateDailyStats", time.Now())
+
 	// Normalize date to start of day
 	startOfDay := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
 	endOfDay := startOfDay.Add(24 * time.Hour)
@@ -609,15 +1107,11 @@ func CalculateDailyStats(date time.Time) (*NetworkStats, error) {
 	}
 
 	// Get currently active devices
-	devices, err := GetAllDevices()
-	if err == nil {
-		stats.ActiveDevices = len(devices)
-		totalPorts := 0
-		for _, device := range devices {
-			totalPorts += len(device.OpenPorts)
-		}
-		stats.TotalPorts = totalPorts
-	}
+	devices.RangeDevices(func(device *Device) bool {
+		stats.ActiveDevices++
+		stats.TotalPorts += len(device.OpenPorts)
+		return true
+	})
 
 	// Save stats to database
 	_, err = db.Exec(`
@@ -637,6 +1131,8 @@ func CalculateDailyStats(date time.Time) (*NetworkStats, error) {
 
 // GetDeviceUptime calculates the uptime percentage of a device over a period
 func GetDeviceUptime(mac string, period time.Duration) (float64, error) {
+	defer ObserveQuery("GetDeviceUptime", time.Now())
+
 	startTime := time.Now().Add(-period)
 
 	// Get all history records for this device in the period
@@ -665,6 +1161,8 @@ func GetDeviceUptime(mac string, period time.Duration) (float64, error) {
 
 // SaveCVECache saves a CVE to the cache
 func SaveCVECache(cveID, description, severity string, score float64, published, modified string) error {
+	defer ObserveQuery("SaveCVECache", time.Now())
+
 	dbMu.Lock()
 	defer dbMu.Unlock()
 
@@ -677,6 +1175,8 @@ func SaveCVECache(cveID, description, severity string, score float64, published,
 
 // GetCVECache retrieves a CVE from the cache
 func GetCVECache(cveID string) (map[string]interface{}, bool) {
+	defer ObserveQuery("GetCVECache", time.Now())
+
 	var description, severity, published, modified string
 	var score float64
 	var cachedAt int64
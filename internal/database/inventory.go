@@ -0,0 +1,192 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Inventory is the device repository. It keeps an in-memory copy of the
+// devices table, so reading a device does not scan the table. Writes go to
+// SQLite first and then reload the device's row, which keeps the copy
+// identical to what the database returns (custom fields, first_seen and
+// merged services included).
+//
+// Devices held by the inventory are never modified; a write replaces the
+// device, so readers may share them while holding mu.
+type Inventory struct {
+	mu     sync.RWMutex
+	byMAC  map[string]*Device
+	sorted []*Device            // Most recently seen first, then by MAC
+	byAddr map[string][]*Device // Address -> devices that have it
+}
+
+// LoadInventory reads every device from the database. Call it after Init.
+func LoadInventory() (*Inventory, error) {
+	defer ObserveQuery("LoadInventory", time.Now())
+
+	devices, err := queryDevices("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load devices: %w", err)
+	}
+
+	inv := &Inventory{
+		byMAC:  make(map[string]*Device, len(devices)),
+		sorted: devices,
+		byAddr: make(map[string][]*Device, len(devices)),
+	}
+	slices.SortFunc(inv.sorted, compareDevices)
+	for _, dev := range devices {
+		inv.byMAC[dev.MAC] = dev
+		inv.index(dev)
+	}
+	return inv, nil
+}
+
+// GetAllDevices returns copies of all devices, most recently seen first
+func (inv *Inventory) GetAllDevices() []*Device {
+	return inv.SelectDevices(nil)
+}
+
+// SelectDevices returns copies of the devices match accepts, most recently
+// seen first. A nil match accepts every device.
+func (inv *Inventory) SelectDevices(match func(dev *Device) bool) []*Device {
+	defer ObserveQuery("SelectDevices", time.Now())
+
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	devices := make([]*Device, 0)
+	for _, dev := range inv.sorted {
+		if match == nil || match(dev) {
+			devices = append(devices, dev.Clone())
+		}
+	}
+	return devices
+}
+
+// RangeDevices calls fn for every device, most recently seen first, until fn
+// returns false. The devices are not copied: fn must not modify them or
+// write to the inventory.
+func (inv *Inventory) RangeDevices(fn func(dev *Device) bool) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	for _, dev := range inv.sorted {
+		if !fn(dev) {
+			return
+		}
+	}
+}
+
+// CountDevices returns the number of devices
+func (inv *Inventory) CountDevices() int {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	return len(inv.sorted)
+}
+
+// GetDevice returns a copy of a device, or nil if there is none with the MAC
+func (inv *Inventory) GetDevice(mac string) *Device {
+	defer ObserveQuery("GetDevice", time.Now())
+
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	if dev, ok := inv.byMAC[mac]; ok {
+		return dev.Clone()
+	}
+	return nil
+}
+
+// GetDeviceByIP returns a copy of the most recently seen device with an
+// address, or nil if none has it
+func (inv *Inventory) GetDeviceByIP(ip string) *Device {
+	defer ObserveQuery("GetDeviceByIP", time.Now())
+
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	var latest *Device
+	for _, dev := range inv.byAddr[ip] {
+		if latest == nil || compareDevices(dev, latest) < 0 {
+			latest = dev
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	return latest.Clone()
+}
+
+// refresh reloads one device after it was written. Callers hold dbMu, so
+// writes to the same device are applied in order.
+func (inv *Inventory) refresh(mac string) error {
+	devices, err := queryDevices(mac)
+	if err != nil {
+		return err
+	}
+	if len(devices) == 0 {
+		inv.remove(mac)
+		return nil
+	}
+
+	dev := devices[0]
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if old, ok := inv.byMAC[mac]; ok {
+		inv.unlink(old)
+	}
+	inv.byMAC[mac] = dev
+	i, _ := slices.BinarySearchFunc(inv.sorted, dev, compareDevices)
+	inv.sorted = slices.Insert(inv.sorted, i, dev)
+	inv.index(dev)
+	return nil
+}
+
+// remove drops a deleted device
+func (inv *Inventory) remove(mac string) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if dev, ok := inv.byMAC[mac]; ok {
+		inv.unlink(dev)
+		delete(inv.byMAC, mac)
+	}
+}
+
+// unlink takes a device out of the sorted view and the address index.
+// Callers hold mu.
+func (inv *Inventory) unlink(dev *Device) {
+	if i, ok := slices.BinarySearchFunc(inv.sorted, dev, compareDevices); ok {
+		inv.sorted = slices.Delete(inv.sorted, i, i+1)
+	}
+	for _, addr := range deviceAddresses(dev) {
+		others := slices.DeleteFunc(inv.byAddr[addr], func(d *Device) bool { return d == dev })
+		if len(others) == 0 {
+			delete(inv.byAddr, addr)
+		} else {
+			inv.byAddr[addr] = others
+		}
+	}
+}
+
+// index adds the addresses of a device to the address index. Callers hold mu.
+func (inv *Inventory) index(dev *Device) {
+	for _, addr := range deviceAddresses(dev) {
+		inv.byAddr[addr] = append(inv.byAddr[addr], dev)
+	}
+}
+
+// compareDevices orders devices most recently seen first, then by MAC
+func compareDevices(a, b *Device) int {
+	if c := b.LastSeen.Compare(a.LastSeen); c != 0 {
+		return c
+	}
+	return strings.Compare(a.MAC, b.MAC)
+}
+
+// deviceAddresses returns the primary address and all other addresses of a device
+func deviceAddresses(dev *Device) []string {
+	if dev.IP == "" || slices.Contains(dev.Addresses, dev.IP) {
+		return dev.Addresses
+	}
+	return append([]string{dev.IP}, dev.Addresses...)
+}
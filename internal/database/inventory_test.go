@@ -0,0 +1,142 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestInventory creates a database in a temporary directory holding
+// count devices and loads its inventory
+func openTestInventory(tb testing.TB, count int) *Inventory {
+	tb.Helper()
+	if err := Init(filepath.Join(tb.TempDir(), "scanner.db")); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { Close() })
+
+	inventory, err := LoadInventory()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	seen := time.Unix(1700000000, 0)
+	for i := 0; i < count; i++ {
+		if err := inventory.UpsertDevice(testDevice(i, seen)); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return inventory
+}
+
+// testDevice returns device i of a test network
+func testDevice(i int, seen time.Time) *Device {
+	return &Device{
+		MAC:      fmt.Sprintf("02:00:00:%02x:%02x:%02x", i>>16&0xff, i>>8&0xff, i&0xff),
+		IP:       fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff),
+		Vendor:   "Example Corp",
+		LastSeen: seen,
+		Services: []Service{
+			{Protocol: "tcp", Port: 22, State: StateOpen, Name: "ssh", LastSeen: seen},
+			{Protocol: "tcp", Port: 80, State: StateOpen, Name: "http", LastSeen: seen},
+		},
+	}
+}
+
+func TestInventoryMatchesDatabase(t *testing.T) {
+	inventory := openTestInventory(t, 20)
+
+	// Move an address from one device to another and see a device again
+	moved := inventory.GetDevice(testDevice(3, time.Time{}).MAC)
+	moved.IP = "10.0.0.200"
+	moved.LastSeen = moved.LastSeen.Add(time.Minute)
+	if err := inventory.UpsertDevice(moved); err != nil {
+		t.Fatal(err)
+	}
+	taker := inventory.GetDevice(testDevice(4, time.Time{}).MAC)
+	taker.Addresses = []string{"10.0.0.3"}
+	taker.LastSeen = taker.LastSeen.Add(2 * time.Minute)
+	if err := inventory.UpsertDevice(taker); err != nil {
+		t.Fatal(err)
+	}
+
+	if dev := inventory.GetDeviceByIP("10.0.0.3"); dev == nil || dev.MAC != taker.MAC {
+		t.Errorf("GetDeviceByIP(10.0.0.3) = %v, want %s", dev, taker.MAC)
+	}
+	if dev := inventory.GetDeviceByIP("10.0.0.200"); dev == nil || dev.MAC != moved.MAC {
+		t.Errorf("GetDeviceByIP(10.0.0.200) = %v, want %s", dev, moved.MAC)
+	}
+
+	// The sorted view must match a fresh read of the table
+	reloaded, err := LoadInventory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, want := inventory.GetAllDevices(), reloaded.GetAllDevices()
+	if len(got) != len(want) {
+		t.Fatalf("inventory has %d devices, database %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].MAC != want[i].MAC || !got[i].LastSeen.Equal(want[i].LastSeen) {
+			t.Errorf("device %d is %s, database has %s", i, got[i].MAC, want[i].MAC)
+		}
+	}
+	if got[0].MAC != taker.MAC || got[1].MAC != moved.MAC {
+		t.Errorf("most recently seen devices are %s, %s; want %s, %s", got[0].MAC, got[1].MAC, taker.MAC, moved.MAC)
+	}
+
+	if err := inventory.RemovePlaceholderDevice("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if inventory.CountDevices() != 20 {
+		t.Errorf("removing a missing placeholder changed the device count to %d", inventory.CountDevices())
+	}
+}
+
+// BenchmarkScanCycle enriches every device of networks of growing size the
+// way a scan cycle does: look the device up, update it and save it. The
+// cost per device should stay flat as the network grows.
+func BenchmarkScanCycle(b *testing.B) {
+	for _, count := range []int{50, 500, 5000} {
+		b.Run(fmt.Sprintf("devices=%d", count), func(b *testing.B) {
+			inventory := openTestInventory(b, count)
+			seen := time.Unix(1700000000, 0)
+
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				seen = seen.Add(time.Minute)
+				for i := 0; i < count; i++ {
+					found := testDevice(i, seen)
+					if inventory.GetDevice(found.MAC) == nil {
+						b.Fatalf("device %s missing", found.MAC)
+					}
+					if err := inventory.UpsertDevice(found); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*count), "ns/device")
+		})
+	}
+}
+
+func TestUpsertDeviceClosesUnlistedServices(t *testing.T) {
+	inventory := openTestInventory(t, 1)
+
+	dev := inventory.GetDevice(testDevice(0, time.Time{}).MAC)
+	dev.Services = dev.Services[:1]
+	if err := inventory.UpsertDevice(dev); err != nil {
+		t.Fatal(err)
+	}
+	if service := inventory.GetDevice(dev.MAC).FindService("tcp", 80); service == nil || service.State != StateClosed {
+		t.Errorf("service missing from the saved list is %v, want closed", service)
+	}
+
+	dev.Services = nil
+	if err := inventory.UpsertDevice(dev); err != nil {
+		t.Fatal(err)
+	}
+	if service := inventory.GetDevice(dev.MAC).FindService("tcp", 22); service == nil || service.State != StateClosed {
+		t.Errorf("service of a device saved without services is %v, want closed", service)
+	}
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SaveMetricSamples stores samples scraped from a device
+func SaveMetricSamples(mac string, samples []MetricSample) error {
+	defer ObserveQuery("SaveMetricSamples", time.Now())
+
+	if len(samples) == 0 {
+		return nil
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO metric_samples (device_mac, instance, name, labels, value, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, sample := range samples {
+		labelsJSON := ""
+		if len(sample.Labels) > 0 {
+			data, _ := json.Marshal(sample.Labels)
+			labelsJSON = string(data)
+		}
+		if _, err := stmt.Exec(mac, sample.Instance, sample.Name, labelsJSON, sample.Value, sample.Timestamp.Unix()); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetMetricSeries returns the samples of a device between from and to,
+// grouped into series ordered by name, instance and labels. An empty name
+// returns every metric.
+func GetMetricSeries(mac, name string, from, to time.Time) ([]*MetricSeries, error) {
+	defer ObserveQuery("GetMetricSeries", time.Now())
+
+	query := `
+		SELECT instance, name, labels, value, timestamp
+		FROM metric_samples
+		WHERE device_mac = ? AND timestamp >= ? AND timestamp <= ?
+	`
+	args := []interface{}{mac, from.Unix(), to.Unix()}
+	if name != "" {
+		query += " AND name = ?"
+		args = append(args, name)
+	}
+	query += " ORDER BY timestamp ASC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seriesByKey := make(map[string]*MetricSeries)
+	var keys []string
+	for rows.Next() {
+		var instance, metric, labelsJSON string
+		var value float64
+		var timestamp int64
+		if err := rows.Scan(&instance, &metric, &labelsJSON, &value, &timestamp); err != nil {
+			continue
+		}
+
+		key := strings.Join([]string{metric, instance, labelsJSON}, "\x00")
+		series, ok := seriesByKey[key]
+		if !ok {
+			series = &MetricSeries{Name: metric, Instance: instance}
+			if labelsJSON != "" {
+				json.Unmarshal([]byte(labelsJSON), &series.Labels)
+			}
+			seriesByKey[key] = series
+			keys = append(keys, key)
+		}
+		series.Points = append(series.Points, MetricPoint{Timestamp: time.Unix(timestamp, 0), Value: value})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(keys)
+	result := make([]*MetricSeries, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, seriesByKey[key])
+	}
+	return result, nil
+}
+
+// DeleteOldMetricSamples deletes samples older than N days
+func DeleteOldMetricSamples(days int) error {
+	defer ObserveQuery("DeleteOldMetricSamples", time.Now())
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	cutoff := time.Now().AddDate(0, 0, -days).Unix()
+	_, err := db.Exec("DELETE FROM metric_samples WHERE timestamp < ?", cutoff)
+	return err
+}
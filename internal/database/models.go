@@ -1,6 +1,11 @@
 package database
 
-import "time"
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
 
 // Vulnerability represents a security finding
 type Vulnerability struct {
@@ -11,47 +16,412 @@ type Vulnerability struct {
 	Solution    string `json:"solution"`
 	MoreInfo    string `json:"more_info"`
 	Port        int    `json:"port,omitempty"`
+	Protocol    string `json:"protocol,omitempty"`
+}
+
+// Name sources, in order of preference
+const (
+	NameSourceMDNS    = "mdns"
+	NameSourceDHCP    = "dhcp"
+	NameSourceDNS     = "dns"
+	NameSourceNetBIOS = "netbios"
+	NameSourceLLMNR   = "llmnr"
+)
+
+// HostName is a name of a device and how it was learned
+type HostName struct {
+	Name   string `json:"name"`
+	Source string `json:"source"` // mdns, dhcp, dns, netbios or llmnr
+}
+
+// MDNSService is a DNS-SD service instance advertised by a device
+type MDNSService struct {
+	Name string   `json:"name"` // Instance name, e.g. "Living Room"
+	Type string   `json:"type"` // Service type, e.g. "_googlecast._tcp"
+	Port int      `json:"port,omitempty"`
+	TXT  []string `json:"txt,omitempty"` // TXT record entries, e.g. "md=Chromecast"
+}
+
+// UPnPInfo is what a UPnP device announced over SSDP and published in its
+// device description
+type UPnPInfo struct {
+	FriendlyName string   `json:"friendly_name,omitempty"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	ModelName    string   `json:"model_name,omitempty"`
+	ModelNumber  string   `json:"model_number,omitempty"`
+	SerialNumber string   `json:"serial_number,omitempty"`
+	DeviceType   string   `json:"device_type,omitempty"` // e.g. urn:schemas-upnp-org:device:MediaRenderer:1
+	Services     []string `json:"services,omitempty"`    // Service types of the device and its embedded devices
+	Server       string   `json:"server,omitempty"`      // SSDP SERVER header
+	Location     string   `json:"location,omitempty"`    // URL of the device description
+	IGD          bool     `json:"igd"`                   // Internet Gateway Device that accepts port mappings
+}
+
+// DHCPInfo is what a device sent in its DHCP messages
+type DHCPInfo struct {
+	Hostname    string    `json:"hostname,omitempty"`     // Option 12
+	VendorClass string    `json:"vendor_class,omitempty"` // Option 60, e.g. "MSFT 5.0"
+	Fingerprint string    `json:"fingerprint,omitempty"`  // Option 55 parameter request list, e.g. "1,3,6,15"
+	LastMessage string    `json:"last_message"`           // Type of the latest message, e.g. "request"
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// SNMPInfo is what a device's SNMP agent reported
+type SNMPInfo struct {
+	SysDescr      string          `json:"sys_descr,omitempty"`
+	SysObjectID   string          `json:"sys_object_id,omitempty"` // Vendor's identifier of the product, e.g. 1.3.6.1.4.1.9.1.1208
+	SysName       string          `json:"sys_name,omitempty"`
+	SysLocation   string          `json:"sys_location,omitempty"`
+	UptimeSeconds int64           `json:"uptime_seconds,omitempty"`
+	Model         string          `json:"model,omitempty"` // ENTITY-MIB or HOST-RESOURCES-MIB model name
+	Interfaces    []SNMPInterface `json:"interfaces,omitempty"`
+	PolledAt      time.Time       `json:"polled_at"`
+}
+
+// SNMPInterface is a row of a device's interface table
+type SNMPInterface struct {
+	Index       int    `json:"index"`
+	Name        string `json:"name"`                  // ifName, or ifDescr when the agent has no ifXTable
+	Description string `json:"description,omitempty"` // ifDescr
+	Type        int    `json:"type"`                  // IANAifType, e.g. 6 for Ethernet
+	MAC         string `json:"mac,omitempty"`
+	SpeedMbps   int64  `json:"speed_mbps,omitempty"`
+	Up          bool   `json:"up"` // ifOperStatus is up
+}
+
+// FDBEntry is a MAC address a switch learned on one of its ports
+type FDBEntry struct {
+	SwitchMAC string    `json:"switch_mac"`
+	MAC       string    `json:"mac"`
+	IfIndex   int       `json:"if_index"`
+	Port      string    `json:"port"`           // Name of the interface
+	VLAN      int       `json:"vlan,omitempty"` // Q-BRIDGE filtering database, 0 for the plain bridge table
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// SwitchPort is where a device is connected to the network
+type SwitchPort struct {
+	SwitchMAC  string `json:"switch_mac"`
+	SwitchName string `json:"switch_name,omitempty"`
+	SwitchIP   string `json:"switch_ip,omitempty"`
+	Port       string `json:"port"`
+	IfIndex    int    `json:"if_index"`
+	VLAN       int    `json:"vlan,omitempty"`
+}
+
+// SNMP versions of credentials
+const (
+	SNMPVersion2c = "2c"
+	SNMPVersion3  = "3"
+)
+
+// SNMPCredential selects how devices in a subnet are polled
+type SNMPCredential struct {
+	ID        int    `json:"id"`
+	Subnet    string `json:"subnet"`              // CIDR, e.g. 192.168.1.0/24
+	Version   string `json:"version"`             // 2c or 3
+	Community string `json:"community,omitempty"` // v2c community, stored encrypted
+	User      string `json:"user,omitempty"`      // v3 user name from the user store
+}
+
+// SNMPUser is an SNMPv3 user. Passphrases are stored encrypted.
+type SNMPUser struct {
+	Name           string `json:"name"`
+	AuthProtocol   string `json:"auth_protocol"` // MD5, SHA, SHA256 or empty
+	AuthPassphrase string `json:"auth_passphrase,omitempty"`
+	PrivProtocol   string `json:"priv_protocol"` // DES, AES or empty
+	PrivPassphrase string `json:"priv_passphrase,omitempty"`
+}
+
+// FingerprintEvidence records a fingerprint rule that matched a device
+type FingerprintEvidence struct {
+	RuleID  string   `json:"rule_id"`
+	Type    string   `json:"type,omitempty"`
+	OS      string   `json:"os,omitempty"`
+	Model   string   `json:"model,omitempty"`
+	Weight  float64  `json:"weight"`
+	Matched []string `json:"matched"` // The conditions that matched, e.g. "vendor=Hikvision"
 }
 
 // Device represents a network device
 type Device struct {
-	ID              int             `json:"id"`
-	MAC             string          `json:"mac"`
-	IP              string          `json:"ip"`
-	CustomName      string          `json:"custom_name"` // User-assigned name
-	Vendor          string          `json:"vendor"`
-	Type            string          `json:"type"`        // Auto-detected type
-	CustomType      string          `json:"custom_type"` // User-assigned type
-	IsKnown         bool            `json:"is_known"`    // Trusted/Known device
-	Tags            []string        `json:"tags"`        // User tags
-	GroupName       string          `json:"group_name"`  // Device group (e.g., IoT, Servers)
-	Notes           string          `json:"notes"`
-	OpenPorts       []int           `json:"open_ports"`
-	Vulnerabilities []Vulnerability `json:"vulnerabilities"`
-	MetricsURLs     []string        `json:"metrics_urls"`
-	LastSeen        time.Time       `json:"last_seen"`
-	FirstSeen       time.Time       `json:"first_seen"`
-}
-
-// ScanProgress tracks the progress of a port scan
-type ScanProgress struct {
-	Status      string     `json:"status"`   // running, complete, error
+	ID              int                   `json:"id"`
+	MAC             string                `json:"mac"`
+	IP              string                `json:"ip"`          // Primary address (IPv4 when the device has one)
+	Addresses       []string              `json:"addresses"`   // All IPv4 and IPv6 addresses of the device
+	CustomName      string                `json:"custom_name"` // User-assigned name
+	Hostname        string                `json:"hostname"`    // Preferred name from Names
+	Names           []HostName            `json:"names"`       // Names resolved for the device, one per source
+	Vendor          string                `json:"vendor"`
+	Type            string                `json:"type"`        // Auto-detected type
+	OS              string                `json:"os"`          // Auto-detected operating system
+	Model           string                `json:"model"`       // Auto-detected model
+	Confidence      float64               `json:"confidence"`  // Confidence in the detected type, 0-1
+	Evidence        []FingerprintEvidence `json:"evidence"`    // Fingerprint rules behind the classification
+	CustomType      string                `json:"custom_type"` // User-assigned type
+	IsKnown         bool                  `json:"is_known"`    // Trusted/Known device
+	Tags            []string              `json:"tags"`        // User tags
+	GroupName       string                `json:"group_name"`  // Device group (e.g., IoT, Servers)
+	Notes           string                `json:"notes"`
+	OpenPorts       []int                 `json:"open_ports"`    // Open TCP ports, derived from Services
+	Services        []Service             `json:"services"`      // TCP and UDP services, stored in device_services
+	MDNSServices    []MDNSService         `json:"mdns_services"` // DNS-SD services advertised over mDNS
+	UPnP            *UPnPInfo             `json:"upnp"`          // UPnP device description, nil if none was announced
+	DHCP            *DHCPInfo             `json:"dhcp"`          // Options from the device's DHCP messages, nil if none was seen
+	SNMP            *SNMPInfo             `json:"snmp"`          // What the device's SNMP agent reported, nil if it was not polled
+	SwitchPort      *SwitchPort           `json:"switch_port"`   // Switch port the device is connected to, nil if unknown
+	Vulnerabilities []Vulnerability       `json:"vulnerabilities"`
+	MetricsURLs     []string              `json:"metrics_urls"`
+	RTT             float64               `json:"rtt_ms"` // Average ICMP round-trip time in milliseconds
+	TTL             int                   `json:"ttl"`    // TTL of the last ICMP echo reply
+	LastSeen        time.Time             `json:"last_seen"`
+	FirstSeen       time.Time             `json:"first_seen"`
+}
+
+// Service port states
+const (
+	StateOpen         = "open"
+	StateOpenFiltered = "open|filtered" // UDP port that did not answer
+	StateClosed       = "closed"
+)
+
+// Service describes what was found on a TCP or UDP port of a device
+type Service struct {
+	Protocol  string    `json:"protocol"` // tcp or udp
+	Port      int       `json:"port"`
+	State     string    `json:"state"`             // open, open|filtered or closed
+	Name      string    `json:"name,omitempty"`    // Service name, e.g. ssh, http, ntp
+	Product   string    `json:"product,omitempty"` // e.g. OpenSSH, nginx, Postfix
+	Version   string    `json:"version,omitempty"`
+	Banner    string    `json:"banner,omitempty"` // First line of the greeting or response
+	TLS       *TLSInfo  `json:"tls,omitempty"`
+	HTTP      *HTTPInfo `json:"http,omitempty"`
+	SSH       *SSHInfo  `json:"ssh,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// TLSInfo describes the TLS session and certificate of a service
+type TLSInfo struct {
+	Version        string    `json:"version,omitempty"`         // Negotiated version, e.g. TLS 1.3
+	LegacyVersions []string  `json:"legacy_versions,omitempty"` // TLS 1.0/1.1 versions the server still accepts
+	Cipher         string    `json:"cipher,omitempty"`
+	Subject        string    `json:"subject,omitempty"`
+	SANs           []string  `json:"sans,omitempty"` // DNS names and IP addresses
+	Issuer         string    `json:"issuer,omitempty"`
+	NotBefore      time.Time `json:"not_before,omitempty"`
+	NotAfter       time.Time `json:"not_after,omitempty"`
+	KeyType        string    `json:"key_type,omitempty"` // RSA, ECDSA or Ed25519
+	KeyBits        int       `json:"key_bits,omitempty"`
+	SelfSigned     bool      `json:"self_signed"`
+}
+
+// HTTPInfo describes the web interface of a service, as answered for "/"
+type HTTPInfo struct {
+	Status      int      `json:"status,omitempty"` // Status of the final response
+	Server      string   `json:"server,omitempty"`
+	PoweredBy   string   `json:"powered_by,omitempty"` // X-Powered-By header
+	Title       string   `json:"title,omitempty"`
+	Redirects   []string `json:"redirects,omitempty"`    // Locations the request was redirected to, in order
+	AuthRealm   string   `json:"auth_realm,omitempty"`   // WWW-Authenticate realm
+	FaviconHash int32    `json:"favicon_hash,omitempty"` // mmh3 hash of the base64 favicon, as used by Shodan
+}
+
+// SSHInfo describes an SSH server and its host keys
+type SSHInfo struct {
+	Version           string       `json:"version"` // Identification string, e.g. SSH-2.0-OpenSSH_9.6p1
+	HostKeyAlgorithms []string     `json:"host_key_algorithms,omitempty"`
+	HostKeys          []SSHHostKey `json:"host_keys,omitempty"`
+}
+
+// SSHHostKey is one host key of an SSH server
+type SSHHostKey struct {
+	Type        string `json:"type"` // ssh-ed25519, ecdsa-sha2-nistp256, ssh-rsa, ...
+	Bits        int    `json:"bits,omitempty"`
+	Fingerprint string `json:"fingerprint"` // SHA256:..., as printed by ssh-keygen -l
+}
+
+// HostKey returns the server's key of a type, or nil
+func (s *SSHInfo) HostKey(keyType string) *SSHHostKey {
+	for i := range s.HostKeys {
+		if s.HostKeys[i].Type == keyType {
+			return &s.HostKeys[i]
+		}
+	}
+	return nil
+}
+
+// Expired reports whether the certificate has expired at the given time
+func (t *TLSInfo) Expired(at time.Time) bool {
+	return !t.NotAfter.IsZero() && at.After(t.NotAfter)
+}
+
+// ExpiresWithin reports whether the certificate is still valid but expires within d
+func (t *TLSInfo) ExpiresWithin(at time.Time, d time.Duration) bool {
+	return !t.NotAfter.IsZero() && !t.Expired(at) && t.NotAfter.Sub(at) <= d
+}
+
+// WeakKey reports whether the certificate key is too short: RSA below 2048
+// bits, ECDSA below 256 bits, or DSA
+func (t *TLSInfo) WeakKey() bool {
+	switch t.KeyType {
+	case "RSA":
+		return t.KeyBits > 0 && t.KeyBits < 2048
+	case "ECDSA":
+		return t.KeyBits > 0 && t.KeyBits < 256
+	case "DSA":
+		return true
+	}
+	return false
+}
+
+// Key returns the protocol and port of the service, e.g. "22/tcp"
+func (s *Service) Key() string {
+	return fmt.Sprintf("%d/%s", s.Port, s.Protocol)
+}
+
+// FindService returns the device's service on a protocol and port, or nil
+func (d *Device) FindService(protocol string, port int) *Service {
+	for i := range d.Services {
+		if d.Services[i].Protocol == protocol && d.Services[i].Port == port {
+			return &d.Services[i]
+		}
+	}
+	return nil
+}
+
+// OpenTCPPorts returns the open TCP ports of the device's services in ascending order
+func (d *Device) OpenTCPPorts() []int {
+	return d.openPorts("tcp")
+}
+
+// OpenUDPPorts returns the open UDP ports of the device's services in ascending order
+func (d *Device) OpenUDPPorts() []int {
+	return d.openPorts("udp")
+}
+
+// openPorts returns the open ports of a protocol in ascending order
+func (d *Device) openPorts(protocol string) []int {
+	ports := make([]int, 0, len(d.Services))
+	for _, service := range d.Services {
+		if service.Protocol == protocol && service.State == StateOpen {
+			ports = append(ports, service.Port)
+		}
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// Clone returns a deep copy of the device that can be modified without
+// affecting the original
+func (d *Device) Clone() *Device {
+	c := *d
+	c.Addresses = slices.Clone(d.Addresses)
+	c.Names = slices.Clone(d.Names)
+	c.Tags = slices.Clone(d.Tags)
+	c.OpenPorts = slices.Clone(d.OpenPorts)
+	c.Vulnerabilities = slices.Clone(d.Vulnerabilities)
+	c.MetricsURLs = slices.Clone(d.MetricsURLs)
+
+	c.Evidence = slices.Clone(d.Evidence)
+	for i := range c.Evidence {
+		c.Evidence[i].Matched = slices.Clone(c.Evidence[i].Matched)
+	}
+	c.MDNSServices = slices.Clone(d.MDNSServices)
+	for i := range c.MDNSServices {
+		c.MDNSServices[i].TXT = slices.Clone(c.MDNSServices[i].TXT)
+	}
+	c.Services = slices.Clone(d.Services)
+	for i := range c.Services {
+		c.Services[i] = c.Services[i].clone()
+	}
+
+	if d.UPnP != nil {
+		upnp := *d.UPnP
+		upnp.Services = slices.Clone(upnp.Services)
+		c.UPnP = &upnp
+	}
+	if d.DHCP != nil {
+		dhcp := *d.DHCP
+		c.DHCP = &dhcp
+	}
+	if d.SNMP != nil {
+		snmp := *d.SNMP
+		snmp.Interfaces = slices.Clone(snmp.Interfaces)
+		c.SNMP = &snmp
+	}
+	if d.SwitchPort != nil {
+		port := *d.SwitchPort
+		c.SwitchPort = &port
+	}
+	return &c
+}
+
+// clone returns a copy of the service that shares no memory with it
+func (s *Service) clone() Service {
+	c := *s
+	if c.TLS != nil {
+		tls := *c.TLS
+		tls.LegacyVersions = slices.Clone(tls.LegacyVersions)
+		tls.SANs = slices.Clone(tls.SANs)
+		c.TLS = &tls
+	}
+	if c.HTTP != nil {
+		http := *c.HTTP
+		http.Redirects = slices.Clone(http.Redirects)
+		c.HTTP = &http
+	}
+	if c.SSH != nil {
+		ssh := *c.SSH
+		ssh.HostKeyAlgorithms = slices.Clone(ssh.HostKeyAlgorithms)
+		ssh.HostKeys = slices.Clone(ssh.HostKeys)
+		c.SSH = &ssh
+	}
+	return c
+}
+
+// Scan job states
+const (
+	ScanJobQueued    = "queued"
+	ScanJobRunning   = "running"
+	ScanJobComplete  = "complete"
+	ScanJobCancelled = "cancelled"
+	ScanJobError     = "error"
+)
+
+// ScanJob is an on-demand port scan of one host and its results
+type ScanJob struct {
+	ID          int64      `json:"id"`
+	Target      string     `json:"target"` // IP address
+	Profile     string     `json:"profile"`
+	Status      string     `json:"status"`   // queued, running, complete, cancelled, error
 	Progress    int        `json:"progress"` // 0-100
 	CurrentPort int        `json:"current_port"`
 	TotalPorts  int        `json:"total_ports"`
 	OpenPorts   []int      `json:"open_ports"`
 	PortsFound  int        `json:"ports_found"`
-	StartTime   time.Time  `json:"start_time"`
-	EndTime     *time.Time `json:"end_time,omitempty"`
+	Services    []Service  `json:"services,omitempty"` // Services identified behind the open ports
+	RequestedBy string     `json:"requested_by"`
 	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartTime   *time.Time `json:"start_time,omitempty"`
+	EndTime     *time.Time `json:"end_time,omitempty"`
+}
+
+// Finished reports whether the job has stopped for good
+func (j *ScanJob) Finished() bool {
+	return j.Status == ScanJobComplete || j.Status == ScanJobCancelled || j.Status == ScanJobError
 }
 
-// ElapsedTime returns the elapsed time in seconds
-func (sp *ScanProgress) ElapsedTime() float64 {
-	if sp.EndTime != nil {
-		return sp.EndTime.Sub(sp.StartTime).Seconds()
+// ElapsedTime returns the seconds the job has been running
+func (j *ScanJob) ElapsedTime() float64 {
+	if j.StartTime == nil {
+		return 0
 	}
-	return time.Since(sp.StartTime).Seconds()
+	if j.EndTime != nil {
+		return j.EndTime.Sub(*j.StartTime).Seconds()
+	}
+	return time.Since(*j.StartTime).Seconds()
 }
 
 // Notification represents a system notification
@@ -84,8 +454,33 @@ type DeviceHistory struct {
 	Hostname   string    `json:"hostname"`
 	Vendor     string    `json:"vendor"`
 	OpenPorts  []int     `json:"open_ports"`
+	RTT        float64   `json:"rtt_ms"`
+	TTL        int       `json:"ttl"`
 	Timestamp  time.Time `json:"timestamp"`
-	ChangeType string    `json:"change_type"` // new, update, disconnect
+	ChangeType string    `json:"change_type"` // new, update, disconnect, snapshot or dhcp_<message>
+}
+
+// MetricSample is one value scraped from a device's Prometheus exporter
+type MetricSample struct {
+	Name      string            `json:"name"`     // e.g. up, cpu_usage_percent, filesystem_avail_bytes
+	Instance  string            `json:"instance"` // host:port of the exporter
+	Labels    map[string]string `json:"labels,omitempty"`
+	Value     float64           `json:"value"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// MetricSeries is the samples of one metric, instance and label set over time
+type MetricSeries struct {
+	Name     string            `json:"name"`
+	Instance string            `json:"instance"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Points   []MetricPoint     `json:"points"`
+}
+
+// MetricPoint is a value at a time
+type MetricPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
 }
 
 // NetworkStats stores aggregated network statistics
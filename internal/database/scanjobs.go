@@ -0,0 +1,240 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const scanJobColumns = `id, target, profile, status, progress, current_port, total_ports,
+	open_ports, services, requested_by, error, created_at, started_at, ended_at`
+
+// CreateScanJob stores a new job and sets its ID
+func CreateScanJob(job *ScanJob) error {
+	defer ObserveQuery("CreateScanJob", time.Now())
+
+	openPortsJSON, _ := json.Marshal(job.OpenPorts)
+	servicesJSON, _ := json.Marshal(job.Services)
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	result, err := db.Exec(`
+		INSERT INTO scan_jobs (target, profile, status, progress, current_port, total_ports,
+			open_ports, services, requested_by, error, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, job.Target, job.Profile, job.Status, job.Progress, job.CurrentPort, job.TotalPorts,
+		string(openPortsJSON), string(servicesJSON), job.RequestedBy, job.Error, job.CreatedAt.Unix())
+	if err != nil {
+		return err
+	}
+
+	job.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// UpdateScanJob saves the state, progress and results of a job
+func UpdateScanJob(job *ScanJob) error {
+	defer ObserveQuery("UpdateScanJob", time.Now())
+
+	openPortsJSON, _ := json.Marshal(job.OpenPorts)
+	servicesJSON, _ := json.Marshal(job.Services)
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	_, err := db.Exec(`
+		UPDATE scan_jobs SET status = ?, progress = ?, current_port = ?, total_ports = ?,
+			open_ports = ?, services = ?, error = ?, started_at = ?, ended_at = ?
+		WHERE id = ?
+	`, job.Status, job.Progress, job.CurrentPort, job.TotalPorts, string(openPortsJSON),
+		string(servicesJSON), job.Error, unixOrNull(job.StartTime), unixOrNull(job.EndTime), job.ID)
+	return err
+}
+
+// GetScanJob returns a job, or nil if there is none with the ID
+func GetScanJob(id int64) (*ScanJob, error) {
+	defer ObserveQuery("GetScanJob", time.Now())
+
+	job, err := scanScanJob(db.QueryRow("SELECT "+scanJobColumns+" FROM scan_jobs WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+// GetScanJobs returns the most recent jobs, newest first. A non-empty target
+// only returns the jobs of that address.
+func GetScanJobs(target string, limit int) ([]*ScanJob, error) {
+	defer ObserveQuery("GetScanJobs", time.Now())
+
+	query := "SELECT " + scanJobColumns + " FROM scan_jobs"
+	var args []interface{}
+	if target != "" {
+		query += " WHERE target = ?"
+		args = append(args, target)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]*ScanJob, 0)
+	for rows.Next() {
+		job, err := scanScanJob(rows)
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// CountQueuedScanJobs returns the number of jobs waiting for a worker
+func CountQueuedScanJobs() (int, error) {
+	defer ObserveQuery("CountQueuedScanJobs", time.Now())
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM scan_jobs WHERE status = ?", ScanJobQueued).Scan(&count)
+	return count, err
+}
+
+// ClaimScanJob marks the oldest queued job as running and returns it, or
+// nil when the queue is empty
+func ClaimScanJob() (*ScanJob, error) {
+	defer ObserveQuery("ClaimScanJob", time.Now())
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	job, err := scanScanJob(db.QueryRow("SELECT "+scanJobColumns+" FROM scan_jobs WHERE status = ? ORDER BY id LIMIT 1", ScanJobQueued))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job.Status = ScanJobRunning
+	job.StartTime = &now
+	if _, err := db.Exec("UPDATE scan_jobs SET status = ?, started_at = ? WHERE id = ?", job.Status, now.Unix(), job.ID); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// CancelQueuedScanJob cancels a job that has not started yet. It reports
+// false when the job is not queued.
+func CancelQueuedScanJob(id int64) (bool, error) {
+	defer ObserveQuery("CancelQueuedScanJob", time.Now())
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	result, err := db.Exec("UPDATE scan_jobs SET status = ?, ended_at = ? WHERE id = ? AND status = ?",
+		ScanJobCancelled, time.Now().Unix(), id, ScanJobQueued)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// RequeueRunningScanJobs puts jobs that were interrupted while running back
+// in the queue, so they start over
+func RequeueRunningScanJobs() (int, error) {
+	defer ObserveQuery("RequeueRunningScanJobs", time.Now())
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	result, err := db.Exec(`
+		UPDATE scan_jobs SET status = ?, progress = 0, current_port = 0, open_ports = '[]',
+			services = 'null', started_at = NULL
+		WHERE status = ?
+	`, ScanJobQueued, ScanJobRunning)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// DeleteScanJob deletes a finished job. It reports false when there is no
+// finished job with the ID.
+func DeleteScanJob(id int64) (bool, error) {
+	defer ObserveQuery("DeleteScanJob", time.Now())
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	result, err := db.Exec("DELETE FROM scan_jobs WHERE id = ? AND status IN (?, ?, ?)",
+		id, ScanJobComplete, ScanJobCancelled, ScanJobError)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// DeleteOldScanJobs deletes finished jobs created more than N days ago
+func DeleteOldScanJobs(days int) error {
+	defer ObserveQuery("DeleteOldScanJobs", time.Now())
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	cutoff := time.Now().AddDate(0, 0, -days).Unix()
+	_, err := db.Exec("DELETE FROM scan_jobs WHERE created_at < ? AND status IN (?, ?, ?)",
+		cutoff, ScanJobComplete, ScanJobCancelled, ScanJobError)
+	return err
+}
+
+// scanScanJob reads a job selected with scanJobColumns
+func scanScanJob(row interface{ Scan(...interface{}) error }) (*ScanJob, error) {
+	var job ScanJob
+	var openPortsJSON, servicesJSON, requestedBy, jobError sql.NullString
+	var createdAt int64
+	var startedAt, endedAt sql.NullInt64
+	err := row.Scan(&job.ID, &job.Target, &job.Profile, &job.Status, &job.Progress, &job.CurrentPort,
+		&job.TotalPorts, &openPortsJSON, &servicesJSON, &requestedBy, &jobError, &createdAt, &startedAt, &endedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if openPortsJSON.String != "" {
+		json.Unmarshal([]byte(openPortsJSON.String), &job.OpenPorts)
+	}
+	if job.OpenPorts == nil {
+		job.OpenPorts = []int{}
+	}
+	if servicesJSON.String != "" {
+		json.Unmarshal([]byte(servicesJSON.String), &job.Services)
+	}
+	job.PortsFound = len(job.OpenPorts)
+	job.RequestedBy = requestedBy.String
+	job.Error = jobError.String
+	job.CreatedAt = time.Unix(createdAt, 0)
+	if startedAt.Valid {
+		t := time.Unix(startedAt.Int64, 0)
+		job.StartTime = &t
+	}
+	if endedAt.Valid {
+		t := time.Unix(endedAt.Int64, 0)
+		job.EndTime = &t
+	}
+	return &job, nil
+}
+
+// unixOrNull stores an optional time as Unix seconds or NULL
+func unixOrNull(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Unix()
+}
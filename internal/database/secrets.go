@@ -0,0 +1,111 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	secretKeyPath = "scanner.key"
+	secretKey     []byte
+	secretMu      sync.Mutex
+)
+
+// SetSecretKeyPath sets the file holding the key that encrypts stored
+// credentials. The file is created with a random key on first use.
+func SetSecretKeyPath(path string) {
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	secretKeyPath = path
+	secretKey = nil
+}
+
+// loadSecretKey reads the AES-256 key, creating it if it does not exist
+func loadSecretKey() ([]byte, error) {
+	secretMu.Lock()
+	defer secretMu.Unlock()
+
+	if secretKey != nil {
+		return secretKey, nil
+	}
+
+	data, err := os.ReadFile(secretKeyPath)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("invalid secret key in %s", secretKeyPath)
+		}
+		secretKey = key
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(secretKeyPath, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to create secret key: %w", err)
+	}
+	secretKey = key
+	return key, nil
+}
+
+// newSecretCipher returns AES-GCM with the secret key
+func newSecretCipher() (cipher.AEAD, error) {
+	key, err := loadSecretKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSecret encrypts a credential for storage. Empty values stay empty.
+func encryptSecret(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	gcm, err := newSecretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plaintext), nil)), nil
+}
+
+// decryptSecret decrypts a stored credential
+func decryptSecret(ciphertext string) (string, error) {
+	if ciphertext == "" {
+		return "", nil
+	}
+	gcm, err := newSecretCipher()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return "", errors.New("malformed encrypted secret")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt secret: wrong key?")
+	}
+	return string(plaintext), nil
+}
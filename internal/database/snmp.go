@@ -0,0 +1,236 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// SaveSNMPCredential stores a credential, encrypting its community, and sets its ID
+func SaveSNMPCredential(credential *SNMPCredential) error {
+	defer ObserveQuery("SaveSNMPCredential", time.Now())
+
+	community, err := encryptSecret(credential.Community)
+	if err != nil {
+		return err
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	result, err := db.Exec(`
+		INSERT INTO snmp_credentials (subnet, version, community, user_name)
+		VALUES (?, ?, ?, ?)
+	`, credential.Subnet, credential.Version, community, credential.User)
+	if err != nil {
+		return err
+	}
+
+	id, _ := result.LastInsertId()
+	credential.ID = int(id)
+	return nil
+}
+
+// GetSNMPCredentials returns all credentials with their communities decrypted.
+// Credentials that cannot be decrypted are skipped.
+func GetSNMPCredentials() ([]*SNMPCredential, error) {
+	defer ObserveQuery("GetSNMPCredentials", time.Now())
+
+	rows, err := db.Query("SELECT id, subnet, version, community, user_name FROM snmp_credentials ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []*SNMPCredential
+	for rows.Next() {
+		var credential SNMPCredential
+		var community, user sql.NullString
+		if err := rows.Scan(&credential.ID, &credential.Subnet, &credential.Version, &community, &user); err != nil {
+			continue
+		}
+		if credential.Community, err = decryptSecret(community.String); err != nil {
+			log.Printf("SNMP credential %d for %s: %v", credential.ID, credential.Subnet, err)
+			continue
+		}
+		credential.User = user.String
+		credentials = append(credentials, &credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+// DeleteSNMPCredential deletes a credential
+func DeleteSNMPCredential(id int) error {
+	defer ObserveQuery("DeleteSNMPCredential", time.Now())
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	result, err := db.Exec("DELETE FROM snmp_credentials WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("credential not found")
+	}
+	return nil
+}
+
+// SaveSNMPUser inserts or replaces an SNMPv3 user, encrypting its passphrases
+func SaveSNMPUser(user *SNMPUser) error {
+	defer ObserveQuery("SaveSNMPUser", time.Now())
+
+	authPassphrase, err := encryptSecret(user.AuthPassphrase)
+	if err != nil {
+		return err
+	}
+	privPassphrase, err := encryptSecret(user.PrivPassphrase)
+	if err != nil {
+		return err
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	_, err = db.Exec(`
+		INSERT OR REPLACE INTO snmp_users (name, auth_protocol, auth_passphrase, priv_protocol, priv_passphrase)
+		VALUES (?, ?, ?, ?, ?)
+	`, user.Name, user.AuthProtocol, authPassphrase, user.PrivProtocol, privPassphrase)
+	return err
+}
+
+// GetSNMPUsers returns all SNMPv3 users with their passphrases decrypted.
+// Users that cannot be decrypted are skipped.
+func GetSNMPUsers() ([]*SNMPUser, error) {
+	defer ObserveQuery("GetSNMPUsers", time.Now())
+
+	rows, err := db.Query("SELECT name, auth_protocol, auth_passphrase, priv_protocol, priv_passphrase FROM snmp_users ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*SNMPUser
+	for rows.Next() {
+		var user SNMPUser
+		var authProtocol, authPassphrase, privProtocol, privPassphrase sql.NullString
+		if err := rows.Scan(&user.Name, &authProtocol, &authPassphrase, &privProtocol, &privPassphrase); err != nil {
+			continue
+		}
+		user.AuthProtocol = authProtocol.String
+		user.PrivProtocol = privProtocol.String
+		if user.AuthPassphrase, err = decryptSecret(authPassphrase.String); err != nil {
+			log.Printf("SNMP user %s: %v", user.Name, err)
+			continue
+		}
+		if user.PrivPassphrase, err = decryptSecret(privPassphrase.String); err != nil {
+			log.Printf("SNMP user %s: %v", user.Name, err)
+			continue
+		}
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
+
+// DeleteSNMPUser deletes an SNMPv3 user
+func DeleteSNMPUser(name string) error {
+	defer ObserveQuery("DeleteSNMPUser", time.Now())
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	result, err := db.Exec("DELETE FROM snmp_users WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+// SaveFDBEntries replaces the forwarding table of a switch
+func SaveFDBEntries(switchMAC string, entries []FDBEntry) error {
+	defer ObserveQuery("SaveFDBEntries", time.Now())
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM fdb_entries WHERE switch_mac = ?", switchMAC); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`
+		INSERT OR REPLACE INTO fdb_entries (switch_mac, mac, vlan, if_index, port, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, entry := range entries {
+		lastSeen := entry.LastSeen
+		if lastSeen.IsZero() {
+			lastSeen = time.Now()
+		}
+		if _, err := stmt.Exec(switchMAC, entry.MAC, entry.VLAN, entry.IfIndex, entry.Port, lastSeen.Unix()); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetFDBEntries returns the forwarding tables of all switches
+func GetFDBEntries() ([]FDBEntry, error) {
+	defer ObserveQuery("GetFDBEntries", time.Now())
+
+	rows, err := db.Query("SELECT switch_mac, mac, vlan, if_index, port, last_seen FROM fdb_entries ORDER BY switch_mac, if_index")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []FDBEntry
+	for rows.Next() {
+		var entry FDBEntry
+		var port sql.NullString
+		var lastSeen int64
+		if err := rows.Scan(&entry.SwitchMAC, &entry.MAC, &entry.VLAN, &entry.IfIndex, &port, &lastSeen); err != nil {
+			continue
+		}
+		entry.Port = port.String
+		entry.LastSeen = time.Unix(lastSeen, 0)
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// UpdateSwitchPort sets the switch port of a device; nil clears it
+func (inv *Inventory) UpdateSwitchPort(mac string, port *SwitchPort) error {
+	defer ObserveQuery("UpdateSwitchPort", time.Now())
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	var portJSON sql.NullString
+	if port != nil {
+		data, _ := json.Marshal(port)
+		portJSON = sql.NullString{String: string(data), Valid: true}
+	}
+	if _, err := db.Exec("UPDATE devices SET switch_port = ? WHERE mac = ?", portJSON, mac); err != nil {
+		return err
+	}
+	return inv.refresh(mac)
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package dhcp
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// listenBroadcast binds a UDP port on all addresses. SO_REUSEADDR lets a DHCP
+// server or client on this host keep using the port; broadcasts are
+// delivered to every socket bound to it.
+func listenBroadcast(port int) (net.PacketConn, error) {
+	config := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	return config.ListenPacket(context.Background(), "udp4", ":"+strconv.Itoa(port))
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package dhcp
+
+import (
+	"net"
+	"strconv"
+)
+
+// listenBroadcast binds a UDP port on all addresses. This fails if a DHCP
+// server or client on this host already holds the port.
+func listenBroadcast(port int) (net.PacketConn, error) {
+	return net.ListenPacket("udp4", ":"+strconv.Itoa(port))
+}
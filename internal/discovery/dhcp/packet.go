@@ -0,0 +1,178 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DHCP message types (option 53)
+const (
+	Discover = 1
+	Offer    = 2
+	Request  = 3
+	Decline  = 4
+	Ack      = 5
+	Nak      = 6
+	Release  = 7
+	Inform   = 8
+)
+
+var messageTypeNames = map[int]string{
+	Discover: "discover", Offer: "offer", Request: "request", Decline: "decline",
+	Ack: "ack", Nak: "nak", Release: "release", Inform: "inform",
+}
+
+// Options read from client messages
+const (
+	optionHostname     = 12
+	optionRequestedIP  = 50
+	optionMessageType  = 53
+	optionParamList    = 55
+	optionVendorClass  = 60
+	optionEnd          = 255
+	optionPad          = 0
+	bootpHeaderLength  = 236
+	magicCookie        = 0x63825363
+	hardwareEthernet   = 1
+	hardwareAddrLength = 6
+)
+
+var errNotDHCP = errors.New("not a DHCP message")
+
+// Packet is a decoded DHCP message
+type Packet struct {
+	MessageType int
+	ClientMAC   string
+	ClientIP    string // ciaddr, set when the client already has a lease
+	YourIP      string // yiaddr, the address a server assigns
+	RequestedIP string // Option 50
+	Hostname    string // Option 12
+	VendorClass string // Option 60
+	ParamList   []byte // Option 55, in the client's order
+}
+
+// ParsePacket decodes a DHCP message on Ethernet
+func ParsePacket(data []byte) (*Packet, error) {
+	if len(data) < bootpHeaderLength+4 {
+		return nil, errNotDHCP
+	}
+	if data[1] != hardwareEthernet || data[2] != hardwareAddrLength {
+		return nil, errNotDHCP
+	}
+	if binary.BigEndian.Uint32(data[bootpHeaderLength:bootpHeaderLength+4]) != magicCookie {
+		return nil, errNotDHCP
+	}
+
+	packet := &Packet{
+		ClientMAC: net.HardwareAddr(data[28:34]).String(),
+		ClientIP:  addressString(data[12:16]),
+		YourIP:    addressString(data[16:20]),
+	}
+
+	options := data[bootpHeaderLength+4:]
+	for i := 0; i < len(options); {
+		code := options[i]
+		if code == optionEnd {
+			break
+		}
+		if code == optionPad {
+			i++
+			continue
+		}
+		if i+1 >= len(options) {
+			break
+		}
+		length := int(options[i+1])
+		if i+2+length > len(options) {
+			break
+		}
+		value := options[i+2 : i+2+length]
+		i += 2 + length
+
+		switch code {
+		case optionMessageType:
+			if length == 1 {
+				packet.MessageType = int(value[0])
+			}
+		case optionHostname:
+			packet.Hostname = cleanString(value)
+		case optionVendorClass:
+			packet.VendorClass = cleanString(value)
+		case optionRequestedIP:
+			if length == 4 {
+				packet.RequestedIP = addressString(value)
+			}
+		case optionParamList:
+			packet.ParamList = append([]byte(nil), value...)
+		}
+	}
+
+	if packet.MessageType == 0 {
+		// Plain BOOTP
+		return nil, errNotDHCP
+	}
+	return packet, nil
+}
+
+// TypeName returns the lower-case message type, e.g. "request"
+func (p *Packet) TypeName() string {
+	if name, ok := messageTypeNames[p.MessageType]; ok {
+		return name
+	}
+	return "type" + strconv.Itoa(p.MessageType)
+}
+
+// FromClient reports whether the message was sent by a client
+func (p *Packet) FromClient() bool {
+	switch p.MessageType {
+	case Discover, Request, Decline, Release, Inform:
+		return true
+	}
+	return false
+}
+
+// Address returns the best known address of the client: the one a server
+// acknowledged, the one in use, or the one requested
+func (p *Packet) Address() string {
+	switch {
+	case p.MessageType == Ack && p.YourIP != "":
+		return p.YourIP
+	case p.ClientIP != "":
+		return p.ClientIP
+	case p.MessageType == Request:
+		return p.RequestedIP
+	}
+	return ""
+}
+
+// Fingerprint returns the parameter request list as "1,3,6,15", the usual
+// notation of DHCP fingerprint databases
+func (p *Packet) Fingerprint() string {
+	codes := make([]string, len(p.ParamList))
+	for i, code := range p.ParamList {
+		codes[i] = strconv.Itoa(int(code))
+	}
+	return strings.Join(codes, ",")
+}
+
+// addressString returns the dotted address, or "" for 0.0.0.0
+func addressString(b []byte) string {
+	ip := net.IP(b)
+	if ip.IsUnspecified() {
+		return ""
+	}
+	return ip.String()
+}
+
+// cleanString returns the printable part of an option value
+func cleanString(value []byte) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, string(value)))
+}
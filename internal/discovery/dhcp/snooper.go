@@ -0,0 +1,152 @@
+// Package dhcp passively watches DHCP traffic. Clients broadcast DISCOVER and
+// REQUEST messages to UDP 67, and servers often broadcast their answers to
+// UDP 68, so every host on the segment can learn hostnames, vendor classes
+// and parameter request lists without sending anything.
+package dhcp
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"network-scanner-go/internal/database"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retention is how long client details are kept after the last message
+const retention = 24 * time.Hour
+
+// Snooper listens for DHCP messages and remembers what each client sent
+type Snooper struct {
+	handler func(*Packet)
+
+	mu      sync.Mutex
+	clients map[string]*database.DHCPInfo // MAC -> latest client details
+
+	conns []net.PacketConn
+	wg    sync.WaitGroup
+}
+
+// NewSnooper creates a snooper that calls handler for every DHCP message.
+// The handler runs on the listener goroutine. Call Start to begin listening.
+func NewSnooper(handler func(*Packet)) *Snooper {
+	return &Snooper{
+		handler: handler,
+		clients: make(map[string]*database.DHCPInfo),
+	}
+}
+
+// Start binds UDP 67 and 68 and processes messages in the background. It
+// succeeds if either port could be bound.
+func (s *Snooper) Start() error {
+	var errs []string
+	for _, port := range []int{67, 68} {
+		conn, err := listenBroadcast(port)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		s.conns = append(s.conns, conn)
+	}
+
+	if len(s.conns) == 0 {
+		return fmt.Errorf("could not bind the DHCP ports: %s", strings.Join(errs, "; "))
+	}
+
+	for _, conn := range s.conns {
+		s.wg.Add(1)
+		go s.listen(conn)
+	}
+	return nil
+}
+
+// Stop closes the sockets and waits for the listeners to exit
+func (s *Snooper) Stop() {
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.wg.Wait()
+}
+
+// listen processes messages until the socket is closed
+func (s *Snooper) listen(conn net.PacketConn) {
+	defer s.wg.Done()
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !strings.Contains(err.Error(), "use of closed") {
+				log.Printf("DHCP listener stopped: %v", err)
+			}
+			return
+		}
+
+		packet, err := ParsePacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.record(packet)
+		if s.handler != nil {
+			s.handler(packet)
+		}
+	}
+}
+
+// record updates the details of the client that sent a message
+func (s *Snooper) record(packet *Packet) {
+	if !packet.FromClient() {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+	info, ok := s.clients[packet.ClientMAC]
+	if !ok {
+		info = &database.DHCPInfo{}
+		s.clients[packet.ClientMAC] = info
+	}
+	ApplyPacket(info, packet)
+}
+
+// ApplyPacket copies the client details of a message into info. Options a
+// message does not carry keep their earlier values.
+func ApplyPacket(info *database.DHCPInfo, packet *Packet) {
+	if packet.Hostname != "" {
+		info.Hostname = packet.Hostname
+	}
+	if packet.VendorClass != "" {
+		info.VendorClass = packet.VendorClass
+	}
+	if len(packet.ParamList) > 0 {
+		info.Fingerprint = packet.Fingerprint()
+	}
+	info.LastMessage = packet.TypeName()
+	info.LastSeen = time.Now()
+}
+
+// Lookup returns the latest details sent by a client
+func (s *Snooper) Lookup(mac string) (database.DHCPInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+	info, ok := s.clients[strings.ToLower(mac)]
+	if !ok {
+		return database.DHCPInfo{}, false
+	}
+	return *info, true
+}
+
+// expire drops clients that were not heard within the retention period
+func (s *Snooper) expire() {
+	cutoff := time.Now().Add(-retention)
+	for mac, info := range s.clients {
+		if info.LastSeen.Before(cutoff) {
+			delete(s.clients, mac)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Package dnsmsg encodes DNS queries and decodes the record types used by
+// mDNS, LLMNR and reverse lookups.
+package dnsmsg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DNS record types used by mDNS, DNS-SD and reverse lookups
+const (
+	TypeA    = 1
+	TypePTR  = 12
+	TypeTXT  = 16
+	TypeAAAA = 28
+	TypeSRV  = 33
+	TypeANY  = 255
+)
+
+const classIN = 1
+
+// errMalformed is returned for truncated or invalid messages
+var errMalformed = errors.New("malformed DNS message")
+
+// Record is a resource record from a DNS message
+type Record struct {
+	Name string
+	Type uint16
+	TTL  uint32
+
+	IP     net.IP   // A, AAAA
+	Target string   // PTR, SRV
+	Port   int      // SRV
+	TXT    []string // TXT
+}
+
+// Message is a parsed DNS message. Only the record types above are decoded.
+type Message struct {
+	ID        uint16
+	Response  bool
+	Questions []string
+	Records   []Record // Answer, authority and additional records
+}
+
+// BuildQuery returns a query with one question per name. The ID is zero and
+// recursion is not requested, as mDNS requires.
+func BuildQuery(qtype uint16, names ...string) []byte {
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[4:6], uint16(len(names)))
+	for _, name := range names {
+		msg = appendName(msg, name)
+		msg = binary.BigEndian.AppendUint16(msg, qtype)
+		msg = binary.BigEndian.AppendUint16(msg, classIN)
+	}
+	return msg
+}
+
+// appendName appends a name in uncompressed label form
+func appendName(msg []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+// ParseMessage decodes a DNS message
+func ParseMessage(data []byte) (*Message, error) {
+	if len(data) < 12 {
+		return nil, errMalformed
+	}
+
+	msg := &Message{
+		ID:       binary.BigEndian.Uint16(data[0:2]),
+		Response: data[2]&0x80 != 0,
+	}
+	qdcount := int(binary.BigEndian.Uint16(data[4:6]))
+	rrcount := int(binary.BigEndian.Uint16(data[6:8])) +
+		int(binary.BigEndian.Uint16(data[8:10])) +
+		int(binary.BigEndian.Uint16(data[10:12]))
+
+	offset := 12
+	for i := 0; i < qdcount; i++ {
+		name, next, err := readName(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(data) {
+			return nil, errMalformed
+		}
+		msg.Questions = append(msg.Questions, name)
+		offset = next + 4
+	}
+
+	for i := 0; i < rrcount; i++ {
+		record, next, err := readRecord(data, offset)
+		if err != nil {
+			// Keep the records decoded so far
+			return msg, nil
+		}
+		if record != nil {
+			msg.Records = append(msg.Records, *record)
+		}
+		offset = next
+	}
+
+	return msg, nil
+}
+
+// readRecord decodes the resource record at offset. Unsupported types are
+// skipped and returned as nil.
+func readRecord(data []byte, offset int) (*Record, int, error) {
+	name, offset, err := readName(data, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset+10 > len(data) {
+		return nil, 0, errMalformed
+	}
+
+	rtype := binary.BigEndian.Uint16(data[offset : offset+2])
+	ttl := binary.BigEndian.Uint32(data[offset+4 : offset+8])
+	rdlen := int(binary.BigEndian.Uint16(data[offset+8 : offset+10]))
+	start := offset + 10
+	end := start + rdlen
+	if end > len(data) {
+		return nil, 0, errMalformed
+	}
+	rdata := data[start:end]
+
+	record := &Record{Name: name, Type: rtype, TTL: ttl}
+	switch rtype {
+	case TypeA:
+		if rdlen != 4 {
+			return nil, end, nil
+		}
+		record.IP = net.IP(append([]byte(nil), rdata...))
+	case TypeAAAA:
+		if rdlen != 16 {
+			return nil, end, nil
+		}
+		record.IP = net.IP(append([]byte(nil), rdata...))
+	case TypePTR:
+		target, _, err := readName(data, start)
+		if err != nil {
+			return nil, end, nil
+		}
+		record.Target = target
+	case TypeSRV:
+		if rdlen < 7 {
+			return nil, end, nil
+		}
+		record.Port = int(binary.BigEndian.Uint16(rdata[4:6]))
+		target, _, err := readName(data, start+6)
+		if err != nil {
+			return nil, end, nil
+		}
+		record.Target = target
+	case TypeTXT:
+		for i := 0; i < len(rdata); {
+			n := int(rdata[i])
+			if i+1+n > len(rdata) {
+				break
+			}
+			if n > 0 {
+				record.TXT = append(record.TXT, string(rdata[i+1:i+1+n]))
+			}
+			i += 1 + n
+		}
+	default:
+		return nil, end, nil
+	}
+
+	return record, end, nil
+}
+
+// readName decodes a possibly compressed name and returns the offset after it
+func readName(data []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(data) {
+			return "", 0, errMalformed
+		}
+		length := int(data[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xc0 == 0xc0:
+			// Compression pointer
+			if offset+1 >= len(data) || jumps > 32 {
+				return "", 0, errMalformed
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(data[offset:offset+2]) & 0x3fff)
+			jumps++
+		default:
+			if offset+1+length > len(data) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(data[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// ReverseName returns the in-addr.arpa or ip6.arpa name of an address
+func ReverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+
+	const hexDigits = "0123456789abcdef"
+	var b strings.Builder
+	ip16 := ip.To16()
+	for i := len(ip16) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigits[ip16[i]&0x0f])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[ip16[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa")
+	return b.String()
+}
@@ -0,0 +1,336 @@
+// Package mdns learns hostnames and DNS-SD services from multicast DNS. It
+// listens passively on 224.0.0.251:5353 and ff02::fb:5353 and can browse
+// actively by querying _services._dns-sd._udp.local.
+package mdns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"network-scanner-go/internal/database"
+	"network-scanner-go/internal/discovery/dnsmsg"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServicesQuery enumerates the service types advertised on the link (RFC 6763 section 9)
+const ServicesQuery = "_services._dns-sd._udp.local"
+
+// retention is how long records are kept after they were last heard
+const retention = time.Hour
+
+var (
+	groupIPv4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	groupIPv6 = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: 5353}
+)
+
+// Host is what mDNS revealed about an address
+type Host struct {
+	IP       string
+	Hostname string // e.g. "living-room.local"
+	Services []database.MDNSService
+}
+
+// instance is a DNS-SD service instance and where it was heard
+type instance struct {
+	service  database.MDNSService
+	target   string // Hostname from the SRV record
+	sender   string // IP the announcement came from
+	lastSeen time.Time
+}
+
+// address is a hostname -> IP mapping from an A or AAAA record
+type address struct {
+	hostname string
+	lastSeen time.Time
+}
+
+// Browser collects mDNS announcements and answers
+type Browser struct {
+	mu        sync.Mutex
+	addresses map[string]address   // IP -> hostname
+	instances map[string]*instance // Instance name -> service
+	types     map[string]time.Time // Service types seen
+	senders   map[string]time.Time // IPs that sent responses
+
+	conns []*net.UDPConn
+	wg    sync.WaitGroup
+}
+
+// NewBrowser creates an mDNS browser. Call Start to begin listening.
+func NewBrowser() *Browser {
+	return &Browser{
+		addresses: make(map[string]address),
+		instances: make(map[string]*instance),
+		types:     make(map[string]time.Time),
+		senders:   make(map[string]time.Time),
+	}
+}
+
+// Start joins the mDNS groups on every multicast-capable interface and
+// processes what is heard in the background
+func (b *Browser) Start() error {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		for _, group := range []*net.UDPAddr{groupIPv4, groupIPv6} {
+			network := "udp4"
+			if group.IP.To4() == nil {
+				network = "udp6"
+			}
+			conn, err := net.ListenMulticastUDP(network, iface, group)
+			if err != nil {
+				continue
+			}
+			b.conns = append(b.conns, conn)
+		}
+	}
+
+	if len(b.conns) == 0 {
+		return fmt.Errorf("could not join the mDNS group on any interface")
+	}
+
+	for _, conn := range b.conns {
+		b.wg.Add(1)
+		go b.listen(conn)
+	}
+	return nil
+}
+
+// Stop closes the sockets and waits for the listeners to exit
+func (b *Browser) Stop() {
+	for _, conn := range b.conns {
+		conn.Close()
+	}
+	b.wg.Wait()
+}
+
+// listen processes responses until the socket is closed
+func (b *Browser) listen(conn *net.UDPConn) {
+	defer b.wg.Done()
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if !strings.Contains(err.Error(), "use of closed") {
+				log.Printf("mDNS listener stopped: %v", err)
+			}
+			return
+		}
+
+		msg, err := dnsmsg.ParseMessage(buf[:n])
+		if err != nil || !msg.Response {
+			continue
+		}
+		b.handleResponse(from.IP, msg)
+	}
+}
+
+// handleResponse records the hostnames and services in a response
+func (b *Browser) handleResponse(sender net.IP, msg *dnsmsg.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	senderIP := sender.String()
+	b.senders[senderIP] = now
+
+	// PTR records first, so SRV and TXT records in the same packet find their instance
+	for _, record := range msg.Records {
+		if record.Type != dnsmsg.TypePTR {
+			continue
+		}
+		name := strings.ToLower(record.Name)
+		switch {
+		case name == ServicesQuery:
+			b.types[strings.ToLower(record.Target)] = now
+		case strings.Contains(name, "._tcp.") || strings.Contains(name, "._udp."):
+			if record.TTL == 0 {
+				// Goodbye packet
+				delete(b.instances, strings.ToLower(record.Target))
+				continue
+			}
+			b.types[name] = now
+			inst := b.instance(record.Target, name, senderIP)
+			inst.lastSeen = now
+		}
+	}
+
+	for _, record := range msg.Records {
+		switch record.Type {
+		case dnsmsg.TypeA, dnsmsg.TypeAAAA:
+			if record.TTL == 0 {
+				delete(b.addresses, record.IP.String())
+				continue
+			}
+			b.addresses[record.IP.String()] = address{hostname: strings.ToLower(record.Name), lastSeen: now}
+		case dnsmsg.TypeSRV:
+			if serviceType(record.Name) == "" {
+				continue
+			}
+			inst := b.instance(record.Name, serviceType(record.Name), senderIP)
+			inst.service.Port = record.Port
+			inst.target = strings.ToLower(record.Target)
+			inst.lastSeen = now
+		case dnsmsg.TypeTXT:
+			if serviceType(record.Name) == "" {
+				continue
+			}
+			inst := b.instance(record.Name, serviceType(record.Name), senderIP)
+			inst.service.TXT = record.TXT
+			inst.lastSeen = now
+		}
+	}
+}
+
+// instance returns the entry for a service instance, creating it if needed
+func (b *Browser) instance(name, serviceType, sender string) *instance {
+	key := strings.ToLower(name)
+	inst, ok := b.instances[key]
+	if !ok {
+		inst = &instance{service: database.MDNSService{
+			Name: instanceLabel(name, serviceType),
+			Type: strings.TrimSuffix(serviceType, ".local"),
+		}}
+		b.instances[key] = inst
+	}
+	inst.sender = sender
+	return inst
+}
+
+// serviceType returns "_type._proto.local" from an instance name, or "" if
+// the name is not a service instance
+func serviceType(name string) string {
+	labels := strings.Split(strings.ToLower(name), ".")
+	for i := 0; i+2 < len(labels); i++ {
+		if strings.HasPrefix(labels[i], "_") && (labels[i+1] == "_tcp" || labels[i+1] == "_udp") {
+			return strings.Join(labels[i:], ".")
+		}
+	}
+	return ""
+}
+
+// instanceLabel strips the service type from an instance name
+func instanceLabel(name, serviceType string) string {
+	if serviceType == "" || len(name) <= len(serviceType) {
+		return name
+	}
+	return strings.TrimSuffix(name[:len(name)-len(serviceType)], ".")
+}
+
+// Browse asks all responders for their service types and then for the
+// instances of every known type, waiting timeout for the answers
+func (b *Browser) Browse(timeout time.Duration) {
+	b.query(dnsmsg.BuildQuery(dnsmsg.TypePTR, ServicesQuery))
+	time.Sleep(timeout / 2)
+
+	b.mu.Lock()
+	types := make([]string, 0, len(b.types))
+	for t := range b.types {
+		types = append(types, t)
+	}
+	b.mu.Unlock()
+	sort.Strings(types)
+
+	// Keep each query well below the 1500 byte MTU
+	for len(types) > 0 {
+		n := len(types)
+		if n > 20 {
+			n = 20
+		}
+		b.query(dnsmsg.BuildQuery(dnsmsg.TypePTR, types[:n]...))
+		types = types[n:]
+	}
+	time.Sleep(timeout / 2)
+}
+
+// query multicasts a query on every interface
+func (b *Browser) query(msg []byte) {
+	for _, conn := range b.conns {
+		group := groupIPv4
+		if conn.LocalAddr().(*net.UDPAddr).IP.To4() == nil {
+			group = groupIPv6
+		}
+		conn.WriteToUDP(msg, group)
+	}
+}
+
+// Lookup returns what is known about an address
+func (b *Browser) Lookup(ip string) (Host, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.expire()
+	host := Host{IP: ip}
+	if addr, ok := b.addresses[ip]; ok {
+		host.Hostname = addr.hostname
+	}
+
+	for _, inst := range b.instances {
+		owned := inst.sender == ip
+		if inst.target != "" && host.Hostname != "" {
+			owned = inst.target == host.Hostname
+		}
+		if owned {
+			host.Services = append(host.Services, inst.service)
+		}
+	}
+	sort.Slice(host.Services, func(i, j int) bool {
+		if host.Services[i].Type != host.Services[j].Type {
+			return host.Services[i].Type < host.Services[j].Type
+		}
+		return host.Services[i].Name < host.Services[j].Name
+	})
+
+	_, heard := b.senders[ip]
+	return host, heard || host.Hostname != "" || len(host.Services) > 0
+}
+
+// Hosts returns every address that sent an mDNS response
+func (b *Browser) Hosts() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.expire()
+	ips := make([]string, 0, len(b.senders))
+	for ip := range b.senders {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+// expire drops records that were not heard within the retention period
+func (b *Browser) expire() {
+	cutoff := time.Now().Add(-retention)
+	for ip, addr := range b.addresses {
+		if addr.lastSeen.Before(cutoff) {
+			delete(b.addresses, ip)
+		}
+	}
+	for name, inst := range b.instances {
+		if inst.lastSeen.Before(cutoff) {
+			delete(b.instances, name)
+		}
+	}
+	for t, seen := range b.types {
+		if seen.Before(cutoff) {
+			delete(b.types, t)
+		}
+	}
+	for ip, seen := range b.senders {
+		if seen.Before(cutoff) {
+			delete(b.senders, ip)
+		}
+	}
+}
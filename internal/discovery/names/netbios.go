@@ -0,0 +1,91 @@
+package names
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"strings"
+)
+
+// NetBIOS name suffixes that identify the machine itself
+const (
+	netbiosWorkstation = 0x00
+	netbiosServer      = 0x20
+)
+
+// LookupNetBIOS sends a NetBIOS node status request (RFC 1002 section
+// 4.2.17) to UDP 137 and returns the machine name
+func (r *Resolver) LookupNetBIOS(ctx context.Context, ip string) (string, error) {
+	id := uint16(rand.Intn(1 << 16))
+	reply, err := exchange(ctx, net.JoinHostPort(ip, "137"), nodeStatusRequest(id), r.Timeout)
+	if err != nil {
+		return "", err
+	}
+	return parseNodeStatus(reply, id)
+}
+
+// nodeStatusRequest builds an NBSTAT query for the wildcard name "*"
+func nodeStatusRequest(id uint16) []byte {
+	msg := make([]byte, 12, 50)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	binary.BigEndian.PutUint16(msg[4:6], 1) // QDCOUNT
+
+	// "*" padded with NULs to 16 bytes, in first-level encoding
+	name := make([]byte, 16)
+	name[0] = '*'
+	msg = append(msg, 32)
+	for _, b := range name {
+		msg = append(msg, 'A'+b>>4, 'A'+b&0x0f)
+	}
+	msg = append(msg, 0)
+
+	msg = binary.BigEndian.AppendUint16(msg, 0x21) // NBSTAT
+	msg = binary.BigEndian.AppendUint16(msg, 0x01) // IN
+	return msg
+}
+
+// parseNodeStatus returns the first unique workstation or server name of a
+// node status response
+func parseNodeStatus(reply []byte, id uint16) (string, error) {
+	if len(reply) < 12 || binary.BigEndian.Uint16(reply[0:2]) != id || reply[2]&0x80 == 0 {
+		return "", errNoName
+	}
+	if binary.BigEndian.Uint16(reply[6:8]) == 0 {
+		return "", errNoName
+	}
+
+	// Skip the answer name; responders repeat the encoded query name
+	offset := 12
+	for offset < len(reply) && reply[offset] != 0 {
+		if reply[offset]&0xc0 == 0xc0 {
+			offset++
+			break
+		}
+		offset += 1 + int(reply[offset])
+	}
+	offset++
+
+	// TYPE, CLASS, TTL and RDLENGTH
+	offset += 10
+	if offset >= len(reply) {
+		return "", errNoName
+	}
+
+	count := int(reply[offset])
+	offset++
+	for i := 0; i < count && offset+18 <= len(reply); i++ {
+		entry := reply[offset : offset+18]
+		offset += 18
+
+		suffix := entry[15]
+		group := entry[16]&0x80 != 0
+		if group || (suffix != netbiosWorkstation && suffix != netbiosServer) {
+			continue
+		}
+		if name := strings.TrimRight(string(entry[:15]), " \x00"); name != "" {
+			return name, nil
+		}
+	}
+	return "", errNoName
+}
@@ -0,0 +1,192 @@
+// Package names resolves device names over reverse DNS, NetBIOS and LLMNR.
+package names
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"network-scanner-go/internal/database"
+	"network-scanner-go/internal/discovery/dnsmsg"
+	"network-scanner-go/internal/scanner"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errNoName is returned when a query was answered without a usable name
+var errNoName = errors.New("no name in response")
+
+// sourcePreference orders name sources from most to least preferred
+var sourcePreference = []string{
+	database.NameSourceMDNS,
+	database.NameSourceDHCP,
+	database.NameSourceDNS,
+	database.NameSourceNetBIOS,
+	database.NameSourceLLMNR,
+}
+
+// Resolver looks up the names of devices
+type Resolver struct {
+	DNSServer string        // "host:port" of the resolver for PTR lookups; empty uses the system resolver
+	Timeout   time.Duration // Per query
+}
+
+// NewResolver creates a resolver. dnsServer may omit the port.
+func NewResolver(dnsServer string, timeout time.Duration) *Resolver {
+	if dnsServer != "" {
+		if _, _, err := net.SplitHostPort(dnsServer); err != nil {
+			dnsServer = net.JoinHostPort(dnsServer, "53")
+		}
+	}
+	return &Resolver{DNSServer: dnsServer, Timeout: timeout}
+}
+
+// Resolve looks up the names of an address. Reverse DNS only contacts the
+// resolver; NetBIOS and LLMNR query the device and only run when probe is set.
+// Lookups still queued or in flight when ctx is done are abandoned.
+func (r *Resolver) Resolve(ctx context.Context, ip string, probe bool) []database.HostName {
+	lookups := map[string]func(context.Context, string) (string, error){
+		database.NameSourceDNS: r.LookupPTR,
+	}
+	if probe {
+		lookups[database.NameSourceNetBIOS] = r.LookupNetBIOS
+		lookups[database.NameSourceLLMNR] = r.LookupLLMNR
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var names []database.HostName
+	for source, lookup := range lookups {
+		wg.Add(1)
+		go func(source string, lookup func(context.Context, string) (string, error)) {
+			defer wg.Done()
+			name, err := lookup(ctx, ip)
+			if err != nil || name == "" {
+				return
+			}
+			mu.Lock()
+			names = append(names, database.HostName{Name: name, Source: source})
+			mu.Unlock()
+		}(source, lookup)
+	}
+	wg.Wait()
+
+	return Merge(nil, names...)
+}
+
+// LookupPTR returns the reverse DNS name of an address
+func (r *Resolver) LookupPTR(ctx context.Context, ip string) (string, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", errNoName
+	}
+
+	if r.DNSServer == "" {
+		ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+		names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+		if err != nil {
+			return "", err
+		}
+		if len(names) == 0 {
+			return "", errNoName
+		}
+		return strings.TrimSuffix(names[0], "."), nil
+	}
+
+	return queryPTR(ctx, r.DNSServer, addr, true, r.Timeout)
+}
+
+// LookupLLMNR asks the device itself for its name with a unicast LLMNR
+// reverse query (RFC 4795 section 2.4)
+func (r *Resolver) LookupLLMNR(ctx context.Context, ip string) (string, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", errNoName
+	}
+	return queryPTR(ctx, net.JoinHostPort(ip, "5355"), addr, false, r.Timeout)
+}
+
+// queryPTR sends a PTR query for addr to server and returns the first answer
+func queryPTR(ctx context.Context, server string, addr net.IP, recursive bool, timeout time.Duration) (string, error) {
+	name := dnsmsg.ReverseName(addr)
+	query := dnsmsg.BuildQuery(dnsmsg.TypePTR, name)
+	id := uint16(rand.Intn(1 << 16))
+	binary.BigEndian.PutUint16(query[0:2], id)
+	if recursive {
+		query[2] |= 0x01 // Recursion desired
+	}
+
+	reply, err := exchange(ctx, server, query, timeout)
+	if err != nil {
+		return "", err
+	}
+
+	msg, err := dnsmsg.ParseMessage(reply)
+	if err != nil {
+		return "", err
+	}
+	if !msg.Response || msg.ID != id {
+		return "", errNoName
+	}
+	for _, record := range msg.Records {
+		if record.Type == dnsmsg.TypePTR && strings.EqualFold(record.Name, name) && record.Target != "" {
+			return strings.TrimSuffix(record.Target, "."), nil
+		}
+	}
+	return "", errNoName
+}
+
+// exchange sends a UDP request and waits for one reply. The request counts
+// against the scanner's probe budget; it is abandoned when ctx is done.
+func exchange(ctx context.Context, server string, request []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := scanner.DialProbe(ctx, "udp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// Merge adds names to a list, replacing the earlier name of the same
+// source, and orders the result by source preference
+func Merge(names []database.HostName, updates ...database.HostName) []database.HostName {
+	bySource := make(map[string]database.HostName)
+	for _, name := range names {
+		bySource[name.Source] = name
+	}
+	for _, name := range updates {
+		bySource[name.Source] = name
+	}
+
+	merged := make([]database.HostName, 0, len(bySource))
+	for _, source := range sourcePreference {
+		if name, ok := bySource[source]; ok && name.Name != "" {
+			merged = append(merged, name)
+		}
+	}
+	return merged
+}
+
+// Primary returns the most preferred name, or "" if there is none
+func Primary(names []database.HostName) string {
+	merged := Merge(names)
+	if len(merged) == 0 {
+		return ""
+	}
+	return merged[0].Name
+}
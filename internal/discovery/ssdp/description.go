@@ -0,0 +1,138 @@
+package ssdp
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"network-scanner-go/internal/database"
+	"network-scanner-go/internal/scanner"
+	"strings"
+	"time"
+)
+
+// maxDescriptionSize caps the device description read from a device
+const maxDescriptionSize = 1 << 20
+
+// xmlRoot is the root of a UPnP device description
+type xmlRoot struct {
+	Device xmlDevice `xml:"device"`
+}
+
+// xmlDevice is a UPnP device; embedded devices are nested in DeviceList
+type xmlDevice struct {
+	DeviceType   string       `xml:"deviceType"`
+	FriendlyName string       `xml:"friendlyName"`
+	Manufacturer string       `xml:"manufacturer"`
+	ModelName    string       `xml:"modelName"`
+	ModelNumber  string       `xml:"modelNumber"`
+	SerialNumber string       `xml:"serialNumber"`
+	Services     []xmlService `xml:"serviceList>service"`
+	Devices      []xmlDevice  `xml:"deviceList>device"`
+}
+
+type xmlService struct {
+	ServiceType string `xml:"serviceType"`
+}
+
+// FetchDescription downloads and parses the device description at location.
+// The request is abandoned when ctx is done.
+func FetchDescription(ctx context.Context, location string, timeout time.Duration) (*database.UPnPInfo, error) {
+	client := &http.Client{
+		Timeout: timeout,
+		// The description must come from the announcing device
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		// Share the scanner's probe budget
+		Transport: &http.Transport{
+			DialContext:       scanner.ProbeDialContext,
+			DisableKeepAlives: true,
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDescriptionSize))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := ParseDescription(data)
+	if err != nil {
+		return nil, err
+	}
+	info.Location = location
+	return info, nil
+}
+
+// ParseDescription extracts the root device and the service types of all
+// embedded devices from a device description
+func ParseDescription(data []byte) (*database.UPnPInfo, error) {
+	var root xmlRoot
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid device description: %w", err)
+	}
+
+	dev := root.Device
+	info := &database.UPnPInfo{
+		DeviceType:   strings.TrimSpace(dev.DeviceType),
+		FriendlyName: strings.TrimSpace(dev.FriendlyName),
+		Manufacturer: strings.TrimSpace(dev.Manufacturer),
+		ModelName:    strings.TrimSpace(dev.ModelName),
+		ModelNumber:  strings.TrimSpace(dev.ModelNumber),
+		SerialNumber: strings.TrimSpace(dev.SerialNumber),
+	}
+	if info.DeviceType == "" {
+		return nil, fmt.Errorf("invalid device description: no deviceType")
+	}
+
+	seen := make(map[string]bool)
+	var walk func(d *xmlDevice)
+	walk = func(d *xmlDevice) {
+		if isIGD(d.DeviceType) {
+			info.IGD = true
+		}
+		for _, svc := range d.Services {
+			serviceType := strings.TrimSpace(svc.ServiceType)
+			if serviceType == "" || seen[serviceType] {
+				continue
+			}
+			seen[serviceType] = true
+			info.Services = append(info.Services, serviceType)
+			if isPortMapping(serviceType) {
+				info.IGD = true
+			}
+		}
+		for i := range d.Devices {
+			walk(&d.Devices[i])
+		}
+	}
+	walk(&dev)
+
+	return info, nil
+}
+
+// isIGD reports whether a device type is an Internet Gateway Device
+func isIGD(deviceType string) bool {
+	return strings.Contains(deviceType, ":device:InternetGatewayDevice:")
+}
+
+// isPortMapping reports whether a service lets clients add port mappings
+func isPortMapping(serviceType string) bool {
+	return strings.Contains(serviceType, ":service:WANIPConnection:") ||
+		strings.Contains(serviceType, ":service:WANPPPConnection:")
+}
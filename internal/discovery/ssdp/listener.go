@@ -0,0 +1,299 @@
+// Package ssdp finds UPnP devices. It listens for NOTIFY announcements on
+// 239.255.255.250:1900, sends M-SEARCH requests and fetches the device
+// description each announcement points to.
+package ssdp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/textproto"
+	"net/url"
+	"network-scanner-go/internal/database"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retention is how long announcements and descriptions are kept
+const retention = time.Hour
+
+var groupIPv4 = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// announcement is the latest NOTIFY or M-SEARCH response from an address
+type announcement struct {
+	location string
+	server   string
+	lastSeen time.Time
+}
+
+// description is a fetched device description
+type description struct {
+	info      *database.UPnPInfo
+	fetchedAt time.Time
+}
+
+// Listener collects SSDP announcements and device descriptions
+type Listener struct {
+	mu            sync.Mutex
+	announcements map[string]announcement  // IP -> latest announcement
+	descriptions  map[string]description   // LOCATION -> description
+	fetching      map[string]chan struct{} // LOCATION -> closed when the fetch ends
+
+	conns []*net.UDPConn
+	wg    sync.WaitGroup
+}
+
+// NewListener creates an SSDP listener. Call Start to begin listening.
+func NewListener() *Listener {
+	return &Listener{
+		announcements: make(map[string]announcement),
+		descriptions:  make(map[string]description),
+		fetching:      make(map[string]chan struct{}),
+	}
+}
+
+// Start joins the SSDP group on every multicast-capable interface and
+// processes NOTIFY announcements in the background
+func (l *Listener) Start() error {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		conn, err := net.ListenMulticastUDP("udp4", iface, groupIPv4)
+		if err != nil {
+			continue
+		}
+		l.conns = append(l.conns, conn)
+	}
+
+	if len(l.conns) == 0 {
+		return fmt.Errorf("could not join the SSDP group on any interface")
+	}
+
+	for _, conn := range l.conns {
+		l.wg.Add(1)
+		go l.listen(conn)
+	}
+	return nil
+}
+
+// Stop closes the sockets and waits for the listeners to exit
+func (l *Listener) Stop() {
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+	l.wg.Wait()
+}
+
+// listen processes announcements until the socket is closed
+func (l *Listener) listen(conn *net.UDPConn) {
+	defer l.wg.Done()
+
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if !strings.Contains(err.Error(), "use of closed") {
+				log.Printf("SSDP listener stopped: %v", err)
+			}
+			return
+		}
+		l.handleMessage(from.IP, buf[:n])
+	}
+}
+
+// Search multicasts an M-SEARCH for all devices and records the responses
+// that arrive within timeout
+func (l *Listener) Search(timeout time.Duration) error {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	mx := int(timeout / time.Second)
+	if mx < 1 {
+		mx = 1
+	}
+	msg := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\n"+
+		"HOST: 239.255.255.250:1900\r\n"+
+		"MAN: \"ssdp:discover\"\r\n"+
+		"MX: %d\r\n"+
+		"ST: ssdp:all\r\n\r\n", mx)
+	// UDP is lossy, so send the request twice
+	for i := 0; i < 2; i++ {
+		if _, err := conn.WriteToUDP([]byte(msg), groupIPv4); err != nil {
+			return err
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// Deadline reached
+			return nil
+		}
+		l.handleMessage(from.IP, buf[:n])
+	}
+}
+
+// handleMessage records a NOTIFY or an M-SEARCH response
+func (l *Listener) handleMessage(sender net.IP, data []byte) {
+	startLine, headers, err := parseMessage(data)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(startLine, "NOTIFY "), strings.HasPrefix(startLine, "HTTP/1.1 200"):
+	default:
+		// M-SEARCH requests from other control points
+		return
+	}
+
+	ip := sender.String()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if strings.EqualFold(headers.Get("NTS"), "ssdp:byebye") {
+		delete(l.announcements, ip)
+		return
+	}
+
+	location := headers.Get("LOCATION")
+	if !sameHost(location, ip) {
+		// Only fetch descriptions served by the announcing device itself
+		location = ""
+	}
+	ann := l.announcements[ip]
+	if location != "" {
+		ann.location = location
+	}
+	if server := headers.Get("SERVER"); server != "" {
+		ann.server = server
+	}
+	ann.lastSeen = time.Now()
+	l.announcements[ip] = ann
+}
+
+// parseMessage splits an SSDP message into its start line and headers
+func parseMessage(data []byte) (string, textproto.MIMEHeader, error) {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	startLine, err := reader.ReadLine()
+	if err != nil {
+		return "", nil, err
+	}
+	headers, err := reader.ReadMIMEHeader()
+	if err != nil && len(headers) == 0 {
+		return "", nil, err
+	}
+	return startLine, headers, nil
+}
+
+// sameHost reports whether a LOCATION URL points at ip
+func sameHost(location, ip string) bool {
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	host := net.ParseIP(u.Hostname())
+	return host != nil && host.Equal(net.ParseIP(ip))
+}
+
+// Lookup returns what the device at ip announced, with its description if it
+// was fetched before. It never contacts the device.
+func (l *Listener) Lookup(ip string) (*database.UPnPInfo, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expire()
+	ann, ok := l.announcements[ip]
+	if !ok {
+		return nil, false
+	}
+
+	info := &database.UPnPInfo{Location: ann.location, Server: ann.server}
+	if desc, ok := l.descriptions[ann.location]; ok && desc.info != nil {
+		*info = *desc.info
+		info.Server = ann.server
+	}
+	return info, true
+}
+
+// Describe is Lookup, but fetches the device description when it is not
+// cached yet. Concurrent calls for the same LOCATION share one request.
+// When ctx is done before the description arrives, only the announcement is
+// returned.
+func (l *Listener) Describe(ctx context.Context, ip string, timeout time.Duration) (*database.UPnPInfo, bool) {
+	l.mu.Lock()
+	l.expire()
+	ann, ok := l.announcements[ip]
+	if !ok {
+		l.mu.Unlock()
+		return nil, false
+	}
+	_, cached := l.descriptions[ann.location]
+	if ann.location == "" || cached {
+		l.mu.Unlock()
+		return l.Lookup(ip)
+	}
+
+	done, inFlight := l.fetching[ann.location]
+	if !inFlight {
+		done = make(chan struct{})
+		l.fetching[ann.location] = done
+	}
+	l.mu.Unlock()
+
+	if inFlight {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		return l.Lookup(ip)
+	}
+
+	info, err := FetchDescription(ctx, ann.location, timeout)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Failed to fetch UPnP description from %s: %v", ann.location, err)
+	}
+
+	l.mu.Lock()
+	// A failed fetch is cached as well, so a broken device is not asked every
+	// scan. An interrupted one is not: the device did nothing wrong.
+	if ctx.Err() == nil {
+		l.descriptions[ann.location] = description{info: info, fetchedAt: time.Now()}
+	}
+	delete(l.fetching, ann.location)
+	close(done)
+	l.mu.Unlock()
+
+	return l.Lookup(ip)
+}
+
+// expire drops announcements and descriptions older than the retention period
+func (l *Listener) expire() {
+	cutoff := time.Now().Add(-retention)
+	for ip, ann := range l.announcements {
+		if ann.lastSeen.Before(cutoff) {
+			delete(l.announcements, ip)
+		}
+	}
+	for location, desc := range l.descriptions {
+		if desc.fetchedAt.Before(cutoff) {
+			delete(l.descriptions, location)
+		}
+	}
+}
@@ -9,6 +9,8 @@ import (
 
 // GetDeviceUptime calculates the uptime percentage of a device over a period
 func GetDeviceUptime(mac string, period time.Duration) (float64, error) {
+	defer database.ObserveQuery("history.GetDeviceUptime", time.Now())
+
 	startTime := time.Now().Add(-period)
 
 	// Get all history records for this device in the period
@@ -37,6 +39,8 @@ func GetDeviceUptime(mac string, period time.Duration) (float64, error) {
 
 // GetMostActiveDevices returns the devices with the most history entries
 func GetMostActiveDevices(limit int) ([]*database.Device, error) {
+	defer database.ObserveQuery("history.GetMostActiveDevices", time.Now())
+
 	query := `
 		SELECT d.id, d.mac, d.ip, d.vendor, d.type, d.open_ports, d.metrics_urls, d.last_seen, COUNT(h.id) as activity_count
 		FROM devices d
@@ -76,7 +80,9 @@ func GetMostActiveDevices(limit int) ([]*database.Device, error) {
 }
 
 // GetDeviceFirstSeen returns the first time a device was seen
-func GetDeviceFirstSeen(mac string) (time.Time, error) {
+func GetDeviceFirstSeen(mac string, devices *database.Inventory) (time.Time, error) {
+	defer database.ObserveQuery("history.GetDeviceFirstSeen", time.Now())
+
 	var timestampUnix int64
 
 	query := `
@@ -89,18 +95,8 @@ func GetDeviceFirstSeen(mac string) (time.Time, error) {
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// If no history, check current device
-			var device *database.Device
-			devices, err := database.GetAllDevices()
-			if err == nil {
-				for _, d := range devices {
-					if d.MAC == mac {
-						device = d
-						break
-					}
-				}
-				if device != nil {
-					return device.LastSeen, nil
-				}
+			if device := devices.GetDevice(mac); device != nil {
+				return device.LastSeen, nil
 			}
 		}
 		return time.Time{}, err
@@ -110,7 +106,9 @@ func GetDeviceFirstSeen(mac string) (time.Time, error) {
 }
 
 // GetDeviceLastSeen returns the last time a device was seen
-func GetDeviceLastSeen(mac string) (time.Time, error) {
+func GetDeviceLastSeen(mac string, devices *database.Inventory) (time.Time, error) {
+	defer database.ObserveQuery("history.GetDeviceLastSeen", time.Now())
+
 	var timestampUnix int64
 
 	query := `
@@ -123,13 +121,8 @@ func GetDeviceLastSeen(mac string) (time.Time, error) {
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// If no history, check current device
-			devices, err := database.GetAllDevices()
-			if err == nil {
-				for _, d := range devices {
-					if d.MAC == mac {
-						return d.LastSeen, nil
-					}
-				}
+			if device := devices.GetDevice(mac); device != nil {
+				return device.LastSeen, nil
 			}
 		}
 		return time.Time{}, err
@@ -140,6 +133,8 @@ func GetDeviceLastSeen(mac string) (time.Time, error) {
 
 // GetPortChangeHistory returns a list of port changes for a device
 func GetPortChangeHistory(mac string, days int) ([]PortChange, error) {
+	defer database.ObserveQuery("history.GetPortChangeHistory", time.Now())
+
 	startTime := time.Now().AddDate(0, 0, -days)
 
 	query := `
@@ -224,6 +219,8 @@ func comparePorts(old, new []int) (added, removed []int) {
 
 // GetNetworkGrowth calculates the network growth over time
 func GetNetworkGrowth(days int) ([]NetworkGrowthPoint, error) {
+	defer database.ObserveQuery("history.GetNetworkGrowth", time.Now())
+
 	startDate := time.Now().AddDate(0, 0, -days)
 
 	query := `
@@ -20,19 +20,23 @@ func NewRecorder(db *sql.DB) *Recorder {
 
 // RecordDeviceState records the current state of a device
 func RecordDeviceState(device *database.Device, changeType string) error {
+	defer database.ObserveQuery("history.RecordDeviceState", time.Now())
+
 	openPortsJSON, _ := json.Marshal(device.OpenPorts)
 
 	query := `
-		INSERT INTO device_history (device_mac, ip, hostname, vendor, open_ports, timestamp, change_type)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO device_history (device_mac, ip, hostname, vendor, open_ports, rtt_ms, ttl, timestamp, change_type)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	_, err := database.GetDB().Exec(query,
 		device.MAC,
 		device.IP,
-		"", // hostname - can be added later
+		device.Hostname,
 		device.Vendor,
 		string(openPortsJSON),
+		device.RTT,
+		device.TTL,
 		time.Now().Unix(),
 		changeType,
 	)
@@ -56,8 +60,11 @@ func RecordNetworkSnapshot(devices []*database.Device) error {
 	return nil
 }
 
-// CalculateDailyStats calculates and stores daily statistics
-func CalculateDailyStats(date time.Time) (*database.NetworkStats, error) {
+// CalculateDailyStats calculates and stores daily statistics. Device and
+// port totals are taken from devices.
+func CalculateDailyStats(date time.Time, devices *database.Inventory) (*database.NetworkStats, error) {
+	defer database.ObserveQuery("history.CalculateDailyStats", time.Now())
+
 	// Normalize date to start of day
 	startOfDay := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
 	endOfDay := startOfDay.Add(24 * time.Hour)
@@ -102,15 +109,11 @@ func CalculateDailyStats(date time.Time) (*database.NetworkStats, error) {
 	}
 
 	// Get currently active devices
-	devices, err := database.GetAllDevices()
-	if err == nil {
-		stats.ActiveDevices = len(devices)
-		totalPorts := 0
-		for _, device := range devices {
-			totalPorts += len(device.OpenPorts)
-		}
-		stats.TotalPorts = totalPorts
-	}
+	devices.RangeDevices(func(device *database.Device) bool {
+		stats.ActiveDevices++
+		stats.TotalPorts += len(device.OpenPorts)
+		return true
+	})
 
 	// Save stats to database
 	_, err = database.GetDB().Exec(`
@@ -130,8 +133,10 @@ func CalculateDailyStats(date time.Time) (*database.NetworkStats, error) {
 
 // GetDeviceHistory retrieves the history of a specific device
 func GetDeviceHistory(mac string, from, to time.Time) ([]*database.DeviceHistory, error) {
+	defer database.ObserveQuery("history.GetDeviceHistory", time.Now())
+
 	query := `
-		SELECT id, device_mac, ip, hostname, vendor, open_ports, timestamp, change_type
+		SELECT id, device_mac, ip, hostname, vendor, open_ports, rtt_ms, ttl, timestamp, change_type
 		FROM device_history
 		WHERE device_mac = ? AND timestamp >= ? AND timestamp <= ?
 		ORDER BY timestamp DESC
@@ -149,13 +154,18 @@ func GetDeviceHistory(mac string, from, to time.Time) ([]*database.DeviceHistory
 		var openPortsJSON string
 		var timestampUnix int64
 		var hostname sql.NullString
+		var rtt sql.NullFloat64
+		var ttl sql.NullInt64
 
 		err := rows.Scan(&h.ID, &h.DeviceMAC, &h.IP, &hostname, &h.Vendor,
-			&openPortsJSON, &timestampUnix, &h.ChangeType)
+			&openPortsJSON, &rtt, &ttl, &timestampUnix, &h.ChangeType)
 		if err != nil {
 			continue
 		}
 
+		h.RTT = rtt.Float64
+		h.TTL = int(ttl.Int64)
+
 		if hostname.Valid {
 			h.Hostname = hostname.String
 		}
@@ -171,6 +181,8 @@ func GetDeviceHistory(mac string, from, to time.Time) ([]*database.DeviceHistory
 
 // GetNetworkTrends retrieves network statistics for the last N days
 func GetNetworkTrends(days int) ([]*database.NetworkStats, error) {
+	defer database.ObserveQuery("history.GetNetworkTrends", time.Now())
+
 	startDate := time.Now().AddDate(0, 0, -days)
 
 	query := `
@@ -206,6 +218,8 @@ func GetNetworkTrends(days int) ([]*database.NetworkStats, error) {
 
 // CleanOldHistory removes history entries older than the specified number of days
 func CleanOldHistory(retentionDays int) error {
+	defer database.ObserveQuery("history.CleanOldHistory", time.Now())
+
 	cutoffDate := time.Now().AddDate(0, 0, -retentionDays)
 
 	_, err := database.GetDB().Exec(`
@@ -7,13 +7,8 @@ import (
 )
 
 // ExportDevices returns a JSON representation of all devices
-func ExportDevices() ([]byte, error) {
-	devices, err := database.GetAllDevices()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get devices: %w", err)
-	}
-
-	data, err := json.MarshalIndent(devices, "", "  ")
+func ExportDevices(inventory *database.Inventory) ([]byte, error) {
+	data, err := json.MarshalIndent(inventory.GetAllDevices(), "", "  ")
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal devices: %w", err)
 	}
@@ -22,7 +17,7 @@ func ExportDevices() ([]byte, error) {
 }
 
 // ImportDevices imports devices from a JSON representation
-func ImportDevices(data []byte) (int, error) {
+func ImportDevices(inventory *database.Inventory, data []byte) (int, error) {
 	var devices []*database.Device
 	if err := json.Unmarshal(data, &devices); err != nil {
 		return 0, fmt.Errorf("failed to unmarshal JSON: %w", err)
@@ -39,12 +34,12 @@ func ImportDevices(data []byte) (int, error) {
 		// For now, let's just use UpdateDeviceDetails if it exists,
 		// or UpsertDevice for the base record.
 
-		if err := database.UpsertDevice(dev); err != nil {
+		if err := inventory.UpsertDevice(dev); err != nil {
 			continue
 		}
 
 		// If it's an import, we usually want to preserve the custom names, notes, etc.
-		database.UpdateDeviceDetails(dev.MAC, dev.CustomName, dev.CustomType, dev.IsKnown, dev.Tags, dev.Notes, dev.GroupName)
+		inventory.UpdateDeviceDetails(dev.MAC, dev.CustomName, dev.CustomType, dev.IsKnown, dev.Tags, dev.Notes, dev.GroupName)
 		count++
 	}
 
@@ -0,0 +1,146 @@
+// Package metrics scrapes the Prometheus exporters found on devices and keeps
+// a curated set of their series.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Sample is one line of the Prometheus text exposition format
+type Sample struct {
+	Name      string
+	Labels    map[string]string
+	Value     float64
+	Timestamp int64 // Milliseconds since the epoch, 0 if the exporter sent none
+}
+
+// ParseText parses the Prometheus text exposition format (version 0.0.4).
+// Comments, HELP and TYPE lines are skipped. Like Prometheus, it fails on the
+// first malformed line.
+func ParseText(r io.Reader) ([]Sample, error) {
+	var samples []Sample
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		sample, err := parseSampleLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
+
+// parseSampleLine parses `name{label="value",...} value [timestamp]`
+func parseSampleLine(line string) (Sample, error) {
+	var sample Sample
+
+	end := 0
+	for end < len(line) && isNameChar(line[end], end == 0) {
+		end++
+	}
+	if end == 0 {
+		return sample, fmt.Errorf("invalid metric name")
+	}
+	sample.Name = line[:end]
+	rest := line[end:]
+
+	if strings.HasPrefix(rest, "{") {
+		labels, remaining, err := parseLabels(rest[1:])
+		if err != nil {
+			return sample, err
+		}
+		sample.Labels = labels
+		rest = remaining
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) < 1 || len(fields) > 2 {
+		return sample, fmt.Errorf("expected a value and an optional timestamp")
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return sample, fmt.Errorf("invalid value %q", fields[0])
+	}
+	sample.Value = value
+	if len(fields) == 2 {
+		if sample.Timestamp, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return sample, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+	}
+	return sample, nil
+}
+
+// parseLabels parses the label pairs after "{" and returns the text after "}"
+func parseLabels(s string) (map[string]string, string, error) {
+	labels := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if strings.HasPrefix(s, "}") {
+			return labels, s[1:], nil
+		}
+
+		end := 0
+		for end < len(s) && isNameChar(s[end], end == 0) && s[end] != ':' {
+			end++
+		}
+		if end == 0 {
+			return nil, "", fmt.Errorf("invalid label name")
+		}
+		name := s[:end]
+		s = strings.TrimLeft(s[end:], " \t")
+		if !strings.HasPrefix(s, "=") {
+			return nil, "", fmt.Errorf("expected = after label %s", name)
+		}
+		s = strings.TrimLeft(s[1:], " \t")
+		if !strings.HasPrefix(s, `"`) {
+			return nil, "", fmt.Errorf("expected quoted value for label %s", name)
+		}
+
+		var value strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default: // \\ and \"
+					value.WriteByte(s[i])
+				}
+				continue
+			}
+			value.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return nil, "", fmt.Errorf("unterminated value for label %s", name)
+		}
+		labels[name] = value.String()
+
+		s = strings.TrimLeft(s[i+1:], " \t")
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		} else if !strings.HasPrefix(s, "}") {
+			return nil, "", fmt.Errorf("expected , or } after label %s", name)
+		}
+	}
+}
+
+// isNameChar reports whether c may appear in a metric name: [a-zA-Z_:][a-zA-Z0-9_:]*
+func isNameChar(c byte, first bool) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
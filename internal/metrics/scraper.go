@@ -0,0 +1,236 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"network-scanner-go/internal/database"
+	"network-scanner-go/internal/scanner"
+	"sync"
+	"time"
+)
+
+// Curated series kept from each exporter. Scrapes of any exporter record up;
+// the others come from node_exporter.
+const (
+	MetricUp                  = "up"                     // 1 if the scrape succeeded, else 0
+	MetricCPUUsage            = "cpu_usage_percent"      // Busy share of all CPUs since the previous scrape
+	MetricLoad1               = "load1"                  // node_load1
+	MetricMemoryTotal         = "memory_total_bytes"     // node_memory_MemTotal_bytes
+	MetricMemoryAvailable     = "memory_available_bytes" // node_memory_MemAvailable_bytes
+	MetricFilesystemSize      = "filesystem_size_bytes"  // node_filesystem_size_bytes per mountpoint
+	MetricFilesystemAvailable = "filesystem_avail_bytes" // node_filesystem_avail_bytes per mountpoint
+)
+
+const (
+	maxScrapeSize        = 8 * 1024 * 1024
+	scrapeConcurrency    = 8
+	defaultScrapeTimeout = 5 * time.Second
+
+	nodeCPUSecondsTotal         = "node_cpu_seconds_total"
+	nodeLoad1                   = "node_load1"
+	nodeMemoryMemTotalBytes     = "node_memory_MemTotal_bytes"
+	nodeMemoryMemAvailableBytes = "node_memory_MemAvailable_bytes"
+	nodeFilesystemSizeBytes     = "node_filesystem_size_bytes"
+	nodeFilesystemAvailBytes    = "node_filesystem_avail_bytes"
+)
+
+// pseudoFilesystems are not worth tracking for free space
+var pseudoFilesystems = map[string]bool{
+	"tmpfs": true, "devtmpfs": true, "overlay": true, "squashfs": true, "ramfs": true, "nsfs": true, "autofs": true,
+}
+
+// cpuTimes are the summed CPU seconds of an exporter at one scrape
+type cpuTimes struct {
+	idle, total float64
+}
+
+// Scraper periodically scrapes the metrics URLs of known devices
+type Scraper struct {
+	Interval time.Duration
+
+	inventory *database.Inventory
+	client    *http.Client
+
+	mu  sync.Mutex
+	cpu map[string]cpuTimes // Metrics URL -> CPU times of the previous scrape
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewScraper creates a scraper for the devices of inventory. Call Start to
+// begin scraping.
+func NewScraper(interval time.Duration, inventory *database.Inventory) *Scraper {
+	return &Scraper{
+		Interval:  interval,
+		inventory: inventory,
+		client: &http.Client{
+			Timeout: defaultScrapeTimeout,
+			// Scrapes share the scanner's probe budget
+			Transport: &http.Transport{
+				DialContext:       scanner.ProbeDialContext,
+				DisableKeepAlives: true,
+			},
+		},
+		cpu:  make(map[string]cpuTimes),
+		stop: make(chan struct{}),
+	}
+}
+
+// Start scrapes every interval in the background
+func (s *Scraper) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.ScrapeAll()
+			}
+		}
+	}()
+}
+
+// Stop stops scraping and waits for a running round to finish
+func (s *Scraper) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// ScrapeAll scrapes the metrics URLs of all devices and stores the curated
+// samples
+func (s *Scraper) ScrapeAll() {
+	devices := s.inventory.SelectDevices(func(dev *database.Device) bool {
+		return len(dev.MetricsURLs) > 0
+	})
+
+	sem := make(chan struct{}, scrapeConcurrency)
+	var wg sync.WaitGroup
+	for _, dev := range devices {
+		wg.Add(1)
+		go func(dev *database.Device) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var samples []database.MetricSample
+			for _, metricsURL := range dev.MetricsURLs {
+				scraped, err := s.Scrape(metricsURL)
+				if err != nil {
+					log.Printf("Metrics scrape of %s failed: %v", metricsURL, err)
+				}
+				samples = append(samples, scraped...)
+			}
+			if err := database.SaveMetricSamples(dev.MAC, samples); err != nil {
+				log.Printf("Failed to save metrics of %s: %v", dev.IP, err)
+			}
+		}(dev)
+	}
+	wg.Wait()
+}
+
+// Scrape fetches a metrics URL and returns the curated samples. The up
+// sample is returned even when the scrape fails.
+func (s *Scraper) Scrape(metricsURL string) ([]database.MetricSample, error) {
+	now := time.Now()
+	instance := metricsURL
+	if u, err := url.Parse(metricsURL); err == nil {
+		instance = u.Host
+	}
+	up := database.MetricSample{Name: MetricUp, Instance: instance, Timestamp: now}
+
+	samples, err := s.fetch(metricsURL)
+	if err != nil {
+		return []database.MetricSample{up}, err
+	}
+	up.Value = 1
+	return append([]database.MetricSample{up}, s.curate(metricsURL, instance, samples, now)...), nil
+}
+
+// fetch downloads and parses a metrics page
+func (s *Scraper) fetch(metricsURL string) ([]Sample, error) {
+	req, err := http.NewRequest(http.MethodGet, metricsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	req.Header.Set("User-Agent", "network-scanner")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return ParseText(io.LimitReader(resp.Body, maxScrapeSize))
+}
+
+// curate keeps the series of interest from an exporter's samples
+func (s *Scraper) curate(metricsURL, instance string, samples []Sample, now time.Time) []database.MetricSample {
+	var curated []database.MetricSample
+	add := func(name string, labels map[string]string, value float64) {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return
+		}
+		curated = append(curated, database.MetricSample{
+			Name:      name,
+			Instance:  instance,
+			Labels:    labels,
+			Value:     value,
+			Timestamp: now,
+		})
+	}
+
+	var cpu cpuTimes
+	hasCPU := false
+	for _, sample := range samples {
+		switch sample.Name {
+		case nodeCPUSecondsTotal:
+			hasCPU = true
+			cpu.total += sample.Value
+			if sample.Labels["mode"] == "idle" || sample.Labels["mode"] == "iowait" {
+				cpu.idle += sample.Value
+			}
+		case nodeLoad1:
+			add(MetricLoad1, nil, sample.Value)
+		case nodeMemoryMemTotalBytes:
+			add(MetricMemoryTotal, nil, sample.Value)
+		case nodeMemoryMemAvailableBytes:
+			add(MetricMemoryAvailable, nil, sample.Value)
+		case nodeFilesystemSizeBytes, nodeFilesystemAvailBytes:
+			if pseudoFilesystems[sample.Labels["fstype"]] || sample.Labels["mountpoint"] == "" {
+				continue
+			}
+			name := MetricFilesystemSize
+			if sample.Name == nodeFilesystemAvailBytes {
+				name = MetricFilesystemAvailable
+			}
+			add(name, map[string]string{"mountpoint": sample.Labels["mountpoint"]}, sample.Value)
+		}
+	}
+
+	// CPU usage needs two scrapes: the counters only grow
+	if hasCPU {
+		s.mu.Lock()
+		previous, ok := s.cpu[metricsURL]
+		s.cpu[metricsURL] = cpu
+		s.mu.Unlock()
+
+		if deltaTotal := cpu.total - previous.total; ok && deltaTotal > 0 && cpu.idle >= previous.idle {
+			usage := 100 * (1 - (cpu.idle-previous.idle)/deltaTotal)
+			add(MetricCPUUsage, nil, math.Round(math.Max(0, math.Min(100, usage))*100)/100)
+		}
+	}
+	return curated
+}
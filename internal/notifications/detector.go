@@ -3,12 +3,14 @@ package notifications
 import (
 	"fmt"
 	"network-scanner-go/internal/database"
+	"sort"
+	"strings"
 	"time"
 )
 
 // Change represents a detected change in the network
 type Change struct {
-	Type      string // new_device, disconnected, port_change
+	Type      string // new_device, disconnected, port_change, cert_expiring, host_key_changed
 	Device    *database.Device
 	OldDevice *database.Device
 	Message   string
@@ -16,15 +18,20 @@ type Change struct {
 	Timestamp time.Time
 }
 
+// DefaultCertExpiryWindow is how long before expiry certificates are reported
+const DefaultCertExpiryWindow = 30 * 24 * time.Hour
+
 // Detector handles change detection in the network
 type Detector struct {
-	previousDevices map[string]*database.Device // MAC -> Device
+	previousDevices  map[string]*database.Device // MAC -> Device
+	CertExpiryWindow time.Duration
 }
 
 // NewDetector creates a new change detector
 func NewDetector() *Detector {
 	return &Detector{
-		previousDevices: make(map[string]*database.Device),
+		previousDevices:  make(map[string]*database.Device),
+		CertExpiryWindow: DefaultCertExpiryWindow,
 	}
 }
 
@@ -42,39 +49,178 @@ func (d *Detector) DetectDisconnectedDevice(device *database.Device) bool {
 	return wasPresent
 }
 
-// DetectPortChanges detects changes in open ports between old and new device states
-func (d *Detector) DetectPortChanges(old, new *database.Device) []int {
+// ServiceChange describes a service that opened, closed or changed on a device
+type ServiceChange struct {
+	Kind    string // opened, closed, changed
+	Old     *database.Service
+	New     *database.Service
+	Service database.Service // The current state, or the last known one for closed services
+}
+
+// String formats the change, e.g. "22/tcp changed (OpenSSH 8.9 -> OpenSSH 9.6)"
+func (c ServiceChange) String() string {
+	if c.Kind == "changed" {
+		return fmt.Sprintf("%s changed (%s -> %s)", c.Service.Key(), describeService(c.Old), describeService(c.New))
+	}
+	return fmt.Sprintf("%s %s", c.Service.Key(), c.Kind)
+}
+
+// describeService returns the product and version of a service, or its name
+func describeService(service *database.Service) string {
+	if service.Product == "" {
+		return service.Name
+	}
+	return strings.TrimSpace(service.Product + " " + service.Version)
+}
+
+// DetectPortChanges detects services that opened, closed or changed product
+// or version between old and new device states
+func (d *Detector) DetectPortChanges(old, new *database.Device) []ServiceChange {
 	if old == nil || new == nil {
 		return nil
 	}
 
-	// Create maps for easy lookup
-	oldPorts := make(map[int]bool)
-	for _, port := range old.OpenPorts {
-		oldPorts[port] = true
+	oldServices := openServices(old)
+	newServices := openServices(new)
+
+	var changes []ServiceChange
+	for key, service := range newServices {
+		previous, ok := oldServices[key]
+		switch {
+		case !ok:
+			changes = append(changes, ServiceChange{Kind: "opened", New: service, Service: *service})
+		case identityChanged(previous, service):
+			changes = append(changes, ServiceChange{Kind: "changed", Old: previous, New: service, Service: *service})
+		}
+	}
+	for key, service := range oldServices {
+		if _, ok := newServices[key]; !ok {
+			changes = append(changes, ServiceChange{Kind: "closed", Old: service, Service: *service})
+		}
 	}
 
-	newPorts := make(map[int]bool)
-	for _, port := range new.OpenPorts {
-		newPorts[port] = true
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i].Service, changes[j].Service
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.Port < b.Port
+	})
+	return changes
+}
+
+// openServices returns the open services of a device keyed by port and
+// protocol. Devices without services fall back to their open TCP ports.
+func openServices(device *database.Device) map[string]*database.Service {
+	services := make(map[string]*database.Service)
+	if len(device.Services) == 0 {
+		for _, port := range device.OpenPorts {
+			service := &database.Service{Protocol: "tcp", Port: port, State: database.StateOpen}
+			services[service.Key()] = service
+		}
+		return services
 	}
 
-	// Find ports that are in new but not in old (newly opened)
-	var changedPorts []int
-	for port := range newPorts {
-		if !oldPorts[port] {
-			changedPorts = append(changedPorts, port)
+	for i := range device.Services {
+		if service := &device.Services[i]; service.State == database.StateOpen {
+			services[service.Key()] = service
 		}
 	}
+	return services
+}
+
+// identityChanged reports whether a service now runs a different product or
+// version. Services that were not identified in one of the scans do not count.
+func identityChanged(old, new *database.Service) bool {
+	if old.Product == "" || new.Product == "" {
+		return false
+	}
+	return !strings.EqualFold(old.Product, new.Product) || old.Version != new.Version
+}
+
+// HostKeyChange describes an SSH host key that differs from the last scan
+type HostKeyChange struct {
+	Service database.Service
+	Old     database.SSHHostKey
+	New     database.SSHHostKey
+}
+
+// String formats the change, e.g. "22/tcp ssh-ed25519 SHA256:abc -> SHA256:def"
+func (c HostKeyChange) String() string {
+	return fmt.Sprintf("%s %s %s -> %s", c.Service.Key(), c.New.Type, c.Old.Fingerprint, c.New.Fingerprint)
+}
+
+// DetectHostKeyChanges compares the SSH host keys of old and new device
+// states. Only key types seen in both scans are compared, so a server that
+// adds or drops a key type is not reported.
+func (d *Detector) DetectHostKeyChanges(old, new *database.Device) []HostKeyChange {
+	if old == nil || new == nil {
+		return nil
+	}
 
-	// Find ports that are in old but not in new (closed)
-	for port := range oldPorts {
-		if !newPorts[port] {
-			changedPorts = append(changedPorts, port)
+	var changes []HostKeyChange
+	for _, service := range new.Services {
+		if service.SSH == nil {
+			continue
+		}
+		previous := old.FindService(service.Protocol, service.Port)
+		if previous == nil || previous.SSH == nil {
+			continue
+		}
+		for _, key := range service.SSH.HostKeys {
+			if oldKey := previous.SSH.HostKey(key.Type); oldKey != nil && oldKey.Fingerprint != key.Fingerprint {
+				changes = append(changes, HostKeyChange{Service: service, Old: *oldKey, New: key})
+			}
 		}
 	}
+	return changes
+}
 
-	return changedPorts
+// DetectExpiringCertificates returns the services of new whose certificate
+// has expired or expires within the expiry window. Certificates that were
+// already reported for old are skipped, so each one is reported once.
+func (d *Detector) DetectExpiringCertificates(old, new *database.Device) []database.Service {
+	if new == nil {
+		return nil
+	}
+
+	now := time.Now()
+	var expiring []database.Service
+	for _, service := range new.Services {
+		if service.State != database.StateOpen || !d.certExpiring(service.TLS, now) {
+			continue
+		}
+		if old != nil {
+			if previous := old.FindService(service.Protocol, service.Port); previous != nil &&
+				previous.TLS != nil && previous.TLS.NotAfter.Equal(service.TLS.NotAfter) &&
+				d.certExpiring(previous.TLS, now) {
+				continue
+			}
+		}
+		expiring = append(expiring, service)
+	}
+	return expiring
+}
+
+// certExpiring reports whether a certificate has expired or expires soon
+func (d *Detector) certExpiring(info *database.TLSInfo, now time.Time) bool {
+	if info == nil {
+		return false
+	}
+	return info.Expired(now) || info.ExpiresWithin(now, d.CertExpiryWindow)
+}
+
+// formatCertificate describes the certificate of a service and when it expires
+func formatCertificate(service database.Service, now time.Time) string {
+	subject := service.TLS.Subject
+	if subject == "" {
+		subject = "certificate"
+	}
+	verb := "expires"
+	if service.TLS.Expired(now) {
+		verb = "expired"
+	}
+	return fmt.Sprintf("%s %s (%s %s)", service.Key(), subject, verb, service.TLS.NotAfter.Format("2006-01-02"))
 }
 
 // CompareDeviceStates compares old and new device states and returns detected changes
@@ -121,13 +267,13 @@ func (d *Detector) CompareDeviceStates(old, new []*database.Device) []Change {
 	// Detect port changes
 	for mac, newDevice := range newDevices {
 		if oldDevice, exists := oldDevices[mac]; exists {
-			changedPorts := d.DetectPortChanges(oldDevice, newDevice)
-			if len(changedPorts) > 0 {
+			serviceChanges := d.DetectPortChanges(oldDevice, newDevice)
+			if len(serviceChanges) > 0 {
 				changes = append(changes, Change{
 					Type:      "port_change",
 					Device:    newDevice,
 					OldDevice: oldDevice,
-					Message:   fmt.Sprintf("Port changes detected on %s: %v", newDevice.IP, changedPorts),
+					Message:   fmt.Sprintf("Service changes detected on %s: %s", newDevice.IP, formatServiceChanges(serviceChanges)),
 					Severity:  "warning",
 					Timestamp: time.Now(),
 				})
@@ -135,9 +281,66 @@ func (d *Detector) CompareDeviceStates(old, new []*database.Device) []Change {
 		}
 	}
 
+	// Detect SSH host key changes
+	for mac, newDevice := range newDevices {
+		if oldDevice, exists := oldDevices[mac]; exists {
+			keyChanges := d.DetectHostKeyChanges(oldDevice, newDevice)
+			if len(keyChanges) == 0 {
+				continue
+			}
+			parts := make([]string, len(keyChanges))
+			for i, change := range keyChanges {
+				parts[i] = change.String()
+			}
+			changes = append(changes, Change{
+				Type:      "host_key_changed",
+				Device:    newDevice,
+				OldDevice: oldDevice,
+				Message:   fmt.Sprintf("SSH host key changed on %s: %s", newDevice.IP, strings.Join(parts, ", ")),
+				Severity:  "critical",
+				Timestamp: time.Now(),
+			})
+		}
+	}
+
+	// Detect expiring certificates
+	now := time.Now()
+	for mac, newDevice := range newDevices {
+		expiring := d.DetectExpiringCertificates(oldDevices[mac], newDevice)
+		if len(expiring) == 0 {
+			continue
+		}
+
+		severity := "warning"
+		parts := make([]string, len(expiring))
+		for i, service := range expiring {
+			if service.TLS.Expired(now) {
+				severity = "critical"
+			}
+			parts[i] = formatCertificate(service, now)
+		}
+		changes = append(changes, Change{
+			Type:      "cert_expiring",
+			Device:    newDevice,
+			OldDevice: oldDevices[mac],
+			Message:   fmt.Sprintf("TLS certificates expiring on %s: %s", newDevice.IP, strings.Join(parts, ", ")),
+			Severity:  severity,
+			Timestamp: now,
+		})
+	}
+
 	return changes
 }
 
+// formatServiceChanges joins service changes into a readable list
+func formatServiceChanges(changes []ServiceChange) string {
+	parts := make([]string, len(changes))
+	for i, change := range changes {
+		parts[i] = change.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
 // UpdateState updates the detector's internal state with current devices
 func (d *Detector) UpdateState(devices []*database.Device) {
 	d.previousDevices = make(map[string]*database.Device)
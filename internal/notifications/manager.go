@@ -4,10 +4,16 @@ import (
 	"fmt"
 	"log"
 	"network-scanner-go/internal/database"
+	"network-scanner-go/internal/telemetry"
 	"sync"
 	"time"
 )
 
+// stopTimeout bounds how long Stop waits for queued notifications to be sent
+const stopTimeout = 10 * time.Second
+
+var sendFailures = telemetry.NewCounter("scanner_notification_send_failures_total", "Notifications a notifier failed to deliver.", "notifier")
+
 // Manager manages notifications and notifiers
 type Manager struct {
 	notifiers       []Notifier
@@ -16,6 +22,8 @@ type Manager struct {
 	queue           chan *database.Notification
 	mu              sync.RWMutex
 	stopChan        chan struct{}
+	stopped         chan struct{} // Closed once the queue has been drained
+	sending         sync.WaitGroup
 	enabledChannels map[string]bool
 }
 
@@ -71,6 +79,7 @@ func NewManager() *Manager {
 		rateLimiter:     NewRateLimiter(30 * time.Second), // Min 30 seconds between same notifications
 		queue:           make(chan *database.Notification, 100),
 		stopChan:        make(chan struct{}),
+		stopped:         make(chan struct{}),
 		enabledChannels: make(map[string]bool),
 	}
 
@@ -85,6 +94,10 @@ func NewManager() *Manager {
 	// Start queue processor
 	go manager.processQueue()
 
+	telemetry.NewGaugeFunc("scanner_notification_queue_depth", "Notifications waiting to be sent.", func() float64 {
+		return float64(manager.GetQueueSize())
+	})
+
 	return manager
 }
 
@@ -147,14 +160,23 @@ func (m *Manager) Notify(notification *database.Notification) error {
 	}
 }
 
-// processQueue processes notifications from the queue
+// processQueue processes notifications from the queue. Once stopped, it
+// hands the notifications still queued to the notifiers and returns.
 func (m *Manager) processQueue() {
+	defer close(m.stopped)
 	for {
 		select {
 		case notification := <-m.queue:
 			m.sendToNotifiers(notification)
 		case <-m.stopChan:
-			return
+			for {
+				select {
+				case notification := <-m.queue:
+					m.sendToNotifiers(notification)
+				default:
+					return
+				}
+			}
 		}
 	}
 }
@@ -166,9 +188,12 @@ func (m *Manager) sendToNotifiers(notification *database.Notification) {
 	m.mu.RUnlock()
 
 	for _, notifier := range notifiers {
+		m.sending.Add(1)
 		go func(n Notifier) {
+			defer m.sending.Done()
 			err := n.Send(notification)
 			if err != nil {
+				sendFailures.Inc(n.Name())
 				log.Printf("Failed to send notification via %s: %v", n.Name(), err)
 			}
 		}(notifier)
@@ -214,9 +239,22 @@ func (m *Manager) GetConfig() *database.NotificationConfig {
 	return m.config
 }
 
-// Stop stops the notification manager
+// Stop drains the queue and waits up to stopTimeout for the notifications
+// being sent. Notifications submitted afterwards are not delivered.
 func (m *Manager) Stop() {
 	close(m.stopChan)
+	<-m.stopped
+
+	sent := make(chan struct{})
+	go func() {
+		m.sending.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(stopTimeout):
+		log.Printf("Gave up waiting for notifications after %s", stopTimeout)
+	}
 }
 
 // GetQueueSize returns the current queue size
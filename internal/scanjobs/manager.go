@@ -0,0 +1,345 @@
+// Package scanjobs runs on-demand port scans as persistent jobs on a bounded
+// pool of workers.
+package scanjobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"network-scanner-go/internal/database"
+	"network-scanner-go/internal/scanner"
+	"network-scanner-go/internal/security"
+	"sync"
+	"time"
+)
+
+// maxQueuedJobs bounds how many jobs may wait for a worker
+const maxQueuedJobs = 100
+
+// ErrQueueFull is returned by Submit when too many jobs are waiting
+var ErrQueueFull = errors.New("scan queue is full")
+
+// runningJob is a job a worker is scanning
+type runningJob struct {
+	cancel    context.CancelFunc
+	cancelled bool // Cancelled by a user rather than by Stop
+}
+
+// Manager queues scan jobs in the database and runs them
+type Manager struct {
+	workers   int
+	inventory *database.Inventory
+	broadcast func(msg interface{}) // Sends progress to WebSocket clients
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[int64]*runningJob
+}
+
+// NewManager creates a manager with the given number of workers. Call Start
+// to begin running jobs.
+func NewManager(workers int, inventory *database.Inventory, broadcast func(msg interface{})) *Manager {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		workers:   workers,
+		inventory: inventory,
+		broadcast: broadcast,
+		wake:      make(chan struct{}, workers),
+		ctx:       ctx,
+		cancel:    cancel,
+		running:   make(map[int64]*runningJob),
+	}
+}
+
+// Start resumes jobs interrupted by a restart and starts the workers
+func (m *Manager) Start() {
+	if n, err := database.RequeueRunningScanJobs(); err != nil {
+		log.Printf("Failed to requeue interrupted scans: %v", err)
+	} else if n > 0 {
+		log.Printf("Requeued %d interrupted port scans", n)
+	}
+
+	for i := 0; i < m.workers; i++ {
+		m.wg.Add(1)
+		go m.work()
+	}
+}
+
+// Stop interrupts running jobs and waits for the workers to exit. Interrupted
+// jobs go back to the queue and start over on the next Start.
+func (m *Manager) Stop() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// Submit queues a port scan of target with a port profile
+func (m *Manager) Submit(target, profileName, requestedBy string) (*database.ScanJob, error) {
+	ip := net.ParseIP(target)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid target %q: expected an IP address", target)
+	}
+	if profileName == "" {
+		profileName = "full"
+	}
+	profile, err := scanner.ResolvePortProfile(profileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(profile.PortList()) == 0 {
+		return nil, fmt.Errorf("port profile %q has no TCP ports", profile.Name)
+	}
+
+	queued, err := database.CountQueuedScanJobs()
+	if err != nil {
+		return nil, err
+	}
+	if queued >= maxQueuedJobs {
+		return nil, ErrQueueFull
+	}
+
+	job := &database.ScanJob{
+		Target:      ip.String(),
+		Profile:     profile.Name,
+		Status:      database.ScanJobQueued,
+		TotalPorts:  len(profile.PortList()),
+		OpenPorts:   []int{},
+		RequestedBy: requestedBy,
+		CreatedAt:   time.Now(),
+	}
+	if err := database.CreateScanJob(job); err != nil {
+		return nil, err
+	}
+
+	// Wake an idle worker; busy workers check the queue when they finish
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Cancel stops a queued or running job. It reports false when the job is
+// not active. Running jobs stop promptly and keep the ports found so far.
+func (m *Manager) Cancel(id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job, ok := m.running[id]; ok {
+		job.cancelled = true
+		job.cancel()
+		return true, nil
+	}
+
+	cancelled, err := database.CancelQueuedScanJob(id)
+	if err != nil || !cancelled {
+		return false, err
+	}
+	if job, err := database.GetScanJob(id); err == nil && job != nil {
+		m.publish("scan_complete", job)
+	}
+	return true, nil
+}
+
+// work runs queued jobs until the manager stops
+func (m *Manager) work() {
+	defer m.wg.Done()
+	for m.ctx.Err() == nil {
+		job, ctx, err := m.claim()
+		if err != nil {
+			log.Printf("Failed to claim a scan job: %v", err)
+		}
+		if job == nil {
+			select {
+			case <-m.wake:
+			case <-m.ctx.Done():
+			}
+			continue
+		}
+		m.run(ctx, job)
+	}
+}
+
+// claim takes the oldest queued job and registers it as running, so Cancel
+// always finds it either in the queue or among the running jobs
+func (m *Manager) claim() (*database.ScanJob, context.Context, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, err := database.ClaimScanJob()
+	if err != nil || job == nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.running[job.ID] = &runningJob{cancel: cancel}
+	return job, ctx, nil
+}
+
+// run scans the ports of a job, saving progress after each chunk of ports
+func (m *Manager) run(ctx context.Context, job *database.ScanJob) {
+	defer func() {
+		m.mu.Lock()
+		m.running[job.ID].cancel()
+		delete(m.running, job.ID)
+		m.mu.Unlock()
+	}()
+
+	profile, err := scanner.ResolvePortProfile(job.Profile)
+	if err != nil {
+		job.Status = database.ScanJobError
+		job.Error = err.Error()
+		m.finish(job)
+		return
+	}
+
+	log.Printf("Starting %s port scan for %s (scan %d)\n", profile.Name, job.Target, job.ID)
+	m.publish("scan_progress", job)
+
+	ports := profile.PortList()
+	job.TotalPorts = len(ports)
+
+	// The job is only touched by the event loop while the scanner runs
+	events := make(chan scanner.ProgressEvent)
+	eventsDone := make(chan struct{})
+	go func() {
+		defer close(eventsDone)
+		for event := range events {
+			job.OpenPorts = event.OpenPorts
+			job.CurrentPort = event.Done
+			job.Progress = (event.Done * 100) / event.Total
+			job.PortsFound = event.Found
+
+			if err := database.UpdateScanJob(job); err != nil {
+				log.Printf("Failed to save progress of scan %d: %v", job.ID, err)
+			}
+			m.publish("scan_progress", job)
+		}
+	}()
+
+	portScanner := scanner.NewPortScanner(job.Target, profile.Timeout(), nil)
+	openPorts, err := portScanner.ScanChunked(ctx, ports, events)
+	close(events)
+	<-eventsDone
+
+	// Includes the open ports of a chunk cut short by a cancel
+	job.OpenPorts = append([]int{}, openPorts...)
+	job.PortsFound = len(job.OpenPorts)
+	if err == nil {
+		// Identify the services behind the open ports
+		job.Services = scanner.IdentifyServices(ctx, job.Target, job.OpenPorts, profile.Banners)
+	}
+
+	if ctx.Err() != nil {
+		m.interrupted(job, profile)
+		return
+	}
+
+	m.applyResults(job, profile)
+
+	job.Status = database.ScanJobComplete
+	job.Progress = 100
+	m.finish(job)
+	log.Printf("%s port scan complete for %s. Found %d open ports\n", profile.Name, job.Target, len(job.OpenPorts))
+}
+
+// interrupted saves a job whose scan stopped early. A job cancelled by a user
+// keeps its partial results; one stopped by Stop goes back to the queue.
+func (m *Manager) interrupted(job *database.ScanJob, profile *scanner.PortProfile) {
+	m.mu.Lock()
+	cancelled := m.running[job.ID].cancelled
+	m.mu.Unlock()
+
+	if !cancelled {
+		// Shutting down: start over after the restart
+		job.Status = database.ScanJobQueued
+		job.Progress, job.CurrentPort, job.PortsFound = 0, 0, 0
+		job.OpenPorts = []int{}
+		job.Services = nil
+		job.StartTime = nil
+		if err := database.UpdateScanJob(job); err != nil {
+			log.Printf("Failed to requeue scan %d: %v", job.ID, err)
+		}
+		return
+	}
+
+	// Partial results are kept on the job but not applied to the device
+	job.Status = database.ScanJobCancelled
+	m.finish(job)
+	log.Printf("%s port scan for %s cancelled after %d ports\n", profile.Name, job.Target, job.CurrentPort)
+}
+
+// finish saves a job that has stopped and tells the clients
+func (m *Manager) finish(job *database.ScanJob) {
+	now := time.Now()
+	job.EndTime = &now
+	if err := database.UpdateScanJob(job); err != nil {
+		log.Printf("Failed to save scan %d: %v", job.ID, err)
+	}
+	m.publish("scan_complete", job)
+}
+
+// applyResults merges the services found by a completed job into the device
+// and checks it for vulnerabilities
+func (m *Manager) applyResults(job *database.ScanJob, profile *scanner.PortProfile) {
+	device := m.inventory.GetDeviceByIP(job.Target)
+	if device == nil {
+		return
+	}
+
+	// Ports of the profile that are no longer open are recorded as closed
+	scanner.CloseMissingServices(device.Services, "tcp", profile.PortList(), job.OpenPorts)
+	device.Services = scanner.MergeServices(device.Services, job.Services)
+	device.OpenPorts = device.OpenTCPPorts()
+	scanner.ClassifyDevice(device)
+
+	// Check for vulnerabilities
+	device.Vulnerabilities = security.CheckDevice(device)
+
+	if err := m.inventory.UpsertDevice(device); err != nil {
+		log.Printf("Failed to save device %s: %v", device.IP, err)
+	}
+
+	// Notify if critical vulnerabilities found
+	for _, v := range device.Vulnerabilities {
+		if v.Severity == "critical" || v.Severity == "high" {
+			database.SaveNotification(&database.Notification{
+				Type:      "security_alert",
+				DeviceIP:  device.IP,
+				DeviceMAC: device.MAC,
+				Message:   fmt.Sprintf("Security Risk: %s detected on %s", v.Name, v.Severity),
+				Timestamp: time.Now(),
+				Read:      false,
+				Severity:  v.Severity,
+			})
+			if m.broadcast != nil {
+				m.broadcast(map[string]interface{}{
+					"type": "notification_alert", // Special type for security
+					"data": v,
+				})
+			}
+		}
+	}
+}
+
+// publish sends a copy of the job to the WebSocket clients, since the worker
+// keeps changing the job while the message is sent
+func (m *Manager) publish(eventType string, job *database.ScanJob) {
+	if m.broadcast == nil {
+		return
+	}
+	snapshot := *job
+	snapshot.OpenPorts = append([]int{}, job.OpenPorts...)
+	m.broadcast(map[string]interface{}{
+		"type": eventType,
+		"ip":   job.Target,
+		"data": &snapshot,
+	})
+}
@@ -0,0 +1,206 @@
+//go:build linux
+
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	etherTypeARP = 0x0806
+	arpRequest   = 1
+	arpReply     = 2
+	arpFrameLen  = 42 // Ethernet header (14) + ARP payload (28)
+)
+
+// ARPDiscoverer sweeps a subnet with ARP requests over an AF_PACKET socket
+type ARPDiscoverer struct {
+	config ARPConfig
+}
+
+// NewARPDiscoverer creates an ARP discoverer. It fails when the process
+// is not allowed to open raw packet sockets.
+func NewARPDiscoverer(config ARPConfig) (*ARPDiscoverer, error) {
+	// Probe for CAP_NET_RAW once so callers can fall back early
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(etherTypeARP)))
+	if err != nil {
+		return nil, fmt.Errorf("raw socket: %w", err)
+	}
+	syscall.Close(fd)
+
+	defaults := DefaultARPConfig()
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+
+	return &ARPDiscoverer{config: config}, nil
+}
+
+// Name returns the discoverer name
+func (a *ARPDiscoverer) Name() string {
+	return "arp"
+}
+
+// Discover sends an ARP request to every host and collects the replies
+func (a *ARPDiscoverer) Discover(ctx context.Context, network *net.IPNet, hosts []net.IP) (map[string]string, error) {
+	iface, srcIP, err := localInterfaceFor(network)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(etherTypeARP)))
+	if err != nil {
+		return nil, fmt.Errorf("raw socket: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	bindAddr := &syscall.SockaddrLinklayer{Protocol: htons(etherTypeARP), Ifindex: iface.Index}
+	if err := syscall.Bind(fd, bindAddr); err != nil {
+		return nil, fmt.Errorf("bind to %s: %w", iface.Name, err)
+	}
+
+	// Wake up periodically so the receiver can notice the deadline
+	tv := syscall.NsecToTimeval((100 * time.Millisecond).Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return nil, fmt.Errorf("set receive timeout: %w", err)
+	}
+
+	wanted := make(map[[4]byte]bool, len(hosts))
+	for _, ip := range hosts {
+		var key [4]byte
+		copy(key[:], ip.To4())
+		wanted[key] = true
+	}
+
+	results := make(map[string]string)
+	var mu sync.Mutex
+	var deadline time.Time
+	var deadlineMu sync.Mutex
+	done := make(chan struct{})
+
+	// Receive replies concurrently with sending
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1500)
+		for {
+			deadlineMu.Lock()
+			expired := !deadline.IsZero() && time.Now().After(deadline)
+			deadlineMu.Unlock()
+			if expired {
+				return
+			}
+
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				continue
+			}
+
+			ip, mac, ok := parseARPReply(buf[:n])
+			if !ok {
+				continue
+			}
+			var key [4]byte
+			copy(key[:], ip)
+			if !wanted[key] {
+				continue
+			}
+
+			mu.Lock()
+			results[ip.String()] = mac.String()
+			mu.Unlock()
+		}
+	}()
+
+	dst := &syscall.SockaddrLinklayer{
+		Protocol: htons(etherTypeARP),
+		Ifindex:  iface.Index,
+		Halen:    6,
+	}
+	copy(dst.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	frame := make([]byte, arpFrameLen)
+	for i, target := range hosts {
+		if target.Equal(srcIP) {
+			continue
+		}
+
+		buildARPRequest(frame, iface.HardwareAddr, srcIP, target)
+		if probes.Wait(ctx) != nil {
+			break
+		}
+		if err := syscall.Sendto(fd, frame, 0, dst); err != nil {
+			// A full send buffer is transient; back off briefly and move on
+			sleepContext(ctx, a.config.BatchInterval)
+		}
+
+		if (i+1)%a.config.BatchSize == 0 && a.config.BatchInterval > 0 {
+			sleepContext(ctx, a.config.BatchInterval)
+		}
+	}
+
+	// Wait for the last replies, or stop listening as soon as ctx is done
+	setDeadline := func(t time.Time) {
+		deadlineMu.Lock()
+		deadline = t
+		deadlineMu.Unlock()
+	}
+	setDeadline(time.Now().Add(a.config.Timeout))
+	stop := context.AfterFunc(ctx, func() { setDeadline(time.Now()) })
+	<-done
+	stop()
+
+	return results, ctx.Err()
+}
+
+// buildARPRequest fills frame with a broadcast "who-has target tell src" request
+func buildARPRequest(frame []byte, srcMAC net.HardwareAddr, srcIP, target net.IP) {
+	// Ethernet header
+	copy(frame[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(frame[6:12], srcMAC)
+	binary.BigEndian.PutUint16(frame[12:14], etherTypeARP)
+
+	// ARP payload
+	binary.BigEndian.PutUint16(frame[14:16], 1)      // Hardware type: Ethernet
+	binary.BigEndian.PutUint16(frame[16:18], 0x0800) // Protocol type: IPv4
+	frame[18] = 6                                    // Hardware address length
+	frame[19] = 4                                    // Protocol address length
+	binary.BigEndian.PutUint16(frame[20:22], arpRequest)
+	copy(frame[22:28], srcMAC)
+	copy(frame[28:32], srcIP.To4())
+	copy(frame[32:38], make([]byte, 6))
+	copy(frame[38:42], target.To4())
+}
+
+// parseARPReply extracts the sender IP and MAC from an ARP reply frame
+func parseARPReply(frame []byte) (net.IP, net.HardwareAddr, bool) {
+	if len(frame) < arpFrameLen {
+		return nil, nil, false
+	}
+	if binary.BigEndian.Uint16(frame[12:14]) != etherTypeARP {
+		return nil, nil, false
+	}
+	if binary.BigEndian.Uint16(frame[20:22]) != arpReply {
+		return nil, nil, false
+	}
+
+	mac := make(net.HardwareAddr, 6)
+	copy(mac, frame[22:28])
+	ip := net.IPv4(frame[28], frame[29], frame[30], frame[31]).To4()
+	return ip, mac, true
+}
+
+// htons converts a uint16 from host to network byte order
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.NativeEndian.Uint16(b[:])
+}
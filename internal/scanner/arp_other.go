@@ -0,0 +1,27 @@
+//go:build !linux
+
+package scanner
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// ARPDiscoverer is only implemented on Linux
+type ARPDiscoverer struct{}
+
+// NewARPDiscoverer always fails on this platform so callers fall back to ping
+func NewARPDiscoverer(config ARPConfig) (*ARPDiscoverer, error) {
+	return nil, errors.New("raw ARP sweep is not supported on this platform")
+}
+
+// Name returns the discoverer name
+func (a *ARPDiscoverer) Name() string {
+	return "arp"
+}
+
+// Discover is not supported on this platform
+func (a *ARPDiscoverer) Discover(ctx context.Context, network *net.IPNet, hosts []net.IP) (map[string]string, error) {
+	return nil, errors.New("raw ARP sweep is not supported on this platform")
+}
@@ -0,0 +1,377 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"network-scanner-go/internal/database"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bannerWait is how long a server-first protocol gets to greet us before we probe
+const bannerWait = 800 * time.Millisecond
+
+// maxBannerLen caps the stored banner text
+const maxBannerLen = 256
+
+// wellKnownServices names the service usually found on a port
+var wellKnownServices = map[int]string{
+	21: "ftp", 22: "ssh", 23: "telnet", 25: "smtp", 53: "domain", 80: "http",
+	110: "pop3", 143: "imap", 443: "https", 445: "microsoft-ds", 465: "smtps",
+	587: "submission", 993: "imaps", 995: "pop3s", 1883: "mqtt", 3306: "mysql",
+	3389: "ms-wbt-server", 5000: "http", 5432: "postgresql", 5900: "vnc",
+	6379: "redis", 8000: "http", 8080: "http-proxy", 8443: "https-alt",
+	8883: "secure-mqtt", 9090: "http", 9100: "jetdirect", 27017: "mongodb",
+}
+
+// GrabBanners identifies the services on open ports, a few ports at a time.
+// Ports not reached before ctx is done keep their well-known service name.
+func GrabBanners(ctx context.Context, ip string, ports []int, timeout time.Duration) []database.Service {
+	services := make([]database.Service, len(ports))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 10)
+
+	for i, port := range ports {
+		wg.Add(1)
+		go func(i, port int) {
+			defer wg.Done()
+			sem <- struct{}{}        // Acquire
+			defer func() { <-sem }() // Release
+			services[i] = GrabBanner(ctx, ip, port, timeout)
+		}(i, port)
+	}
+
+	wg.Wait()
+	return services
+}
+
+// GrabBanner connects to an open port and identifies its service. It first
+// waits for a greeting (SSH, SMTP, FTP, ...) and otherwise sends a
+// protocol-appropriate probe. The well-known service name is used as a fallback.
+func GrabBanner(ctx context.Context, ip string, port int, timeout time.Duration) database.Service {
+	service := NewTCPService(port)
+	if state := readBanner(ctx, &service, ip, port, timeout); state != nil {
+		// Described once the connection is closed, since checking for legacy
+		// versions takes another of the host's probes
+		service.TLS = describeTLS(ctx, *state, ip, port, timeout)
+	}
+	return service
+}
+
+// readBanner reads and parses the banner of a port. It returns the TLS
+// session state when the service was reached over TLS.
+func readBanner(ctx context.Context, service *database.Service, ip string, port int, timeout time.Duration) *tls.ConnectionState {
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	var conn net.Conn
+	var state *tls.ConnectionState
+	if IsTLSPort(port) {
+		// Talk to the service inside TLS; fall back to plain TCP if the handshake fails
+		if tlsConn, err := dialTLS(ctx, ip, port, timeout, tls.VersionTLS10, 0); err == nil {
+			s := tlsConn.ConnectionState()
+			state = &s
+			conn = tlsConn
+		}
+	}
+	if conn == nil {
+		var err error
+		conn, err = DialProbe(ctx, "tcp", address, timeout)
+		if err != nil {
+			return nil
+		}
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	buf := make([]byte, 2048)
+	read := func(wait time.Duration) []byte {
+		conn.SetReadDeadline(time.Now().Add(wait))
+		n, _ := conn.Read(buf)
+		return buf[:n]
+	}
+
+	data := read(bannerWait)
+	if len(data) == 0 {
+		// Client-first protocol: HTTP is by far the most common
+		probe := fmt.Sprintf("HEAD / HTTP/1.0\r\nHost: %s\r\nUser-Agent: network-scanner\r\n\r\n", address)
+		if port == 22 {
+			probe = "SSH-2.0-network-scanner\r\n"
+		}
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write([]byte(probe)); err != nil {
+			return state
+		}
+		data = read(2 * timeout)
+	} else if bytes.HasPrefix(data, []byte("220")) && isSMTPGreeting(data, port) {
+		// The EHLO response often names the MTA when the greeting does not
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write([]byte("EHLO network-scanner\r\n")); err == nil {
+			greeting := append([]byte(nil), data...)
+			data = append(greeting, read(2*timeout)...)
+		}
+	}
+
+	if len(data) > 0 {
+		parseBanner(service, data)
+	}
+	return state
+}
+
+// IdentifyServices returns the services on open TCP ports. With banners set,
+// each port is probed with GrabBanners; otherwise only TLS ports are
+// contacted, to inspect their certificates. Web and SSH servers are
+// inspected either way. Services not inspected before ctx is done are
+// returned with what is known of them.
+func IdentifyServices(ctx context.Context, ip string, ports []int, banners bool) []database.Service {
+	var services []database.Service
+	if banners {
+		services = GrabBanners(ctx, ip, ports, time.Second)
+	} else {
+		services = make([]database.Service, 0, len(ports))
+		for _, port := range ports {
+			service := NewTCPService(port)
+			if IsTLSPort(port) {
+				service.TLS, _ = InspectTLS(ctx, ip, port, time.Second)
+			}
+			services = append(services, service)
+		}
+	}
+
+	InspectServices(ctx, ip, services)
+	return services
+}
+
+// InspectServices fetches the web interface of HTTP services and the host
+// keys of SSH services
+func InspectServices(ctx context.Context, ip string, services []database.Service) {
+	var wg sync.WaitGroup
+	for i := range services {
+		service := &services[i]
+		if service.Protocol != "tcp" || service.State != database.StateOpen {
+			continue
+		}
+		switch {
+		case IsHTTPService(service):
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				useTLS := service.TLS != nil || IsTLSPort(service.Port)
+				if info, err := InspectHTTP(ctx, ip, service.Port, useTLS, httpTimeout); err == nil {
+					service.HTTP = info
+				}
+			}()
+		case service.Name == "ssh":
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if info, err := InspectSSH(ctx, ip, service.Port, time.Second); err == nil {
+					service.SSH = info
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// NewTCPService returns an open TCP service named after its well-known port
+func NewTCPService(port int) database.Service {
+	now := time.Now()
+	return database.Service{
+		Protocol:  "tcp",
+		Port:      port,
+		State:     database.StateOpen,
+		Name:      wellKnownServices[port],
+		FirstSeen: now,
+		LastSeen:  now,
+	}
+}
+
+// MergeServices combines previously identified services with new ones, the
+// newer entry winning for each protocol and port. A service keeps the
+// first_seen time of its earlier entry, and details the new scan did not
+// identify are kept from it. The result is ordered by protocol and port.
+func MergeServices(existing, updated []database.Service) []database.Service {
+	byKey := make(map[string]database.Service, len(existing)+len(updated))
+	for _, service := range existing {
+		byKey[service.Key()] = service
+	}
+	for _, service := range updated {
+		if old, ok := byKey[service.Key()]; ok {
+			if !old.FirstSeen.IsZero() {
+				service.FirstSeen = old.FirstSeen
+			}
+			if service.State == old.State && service.Product == "" && service.Banner == "" {
+				// Scanned without banners: keep what was identified before
+				service.Name, service.Product, service.Version, service.Banner =
+					old.Name, old.Product, old.Version, old.Banner
+			}
+			if service.State == old.State && service.TLS == nil {
+				service.TLS = old.TLS
+			}
+			if service.State == old.State && service.HTTP == nil {
+				service.HTTP = old.HTTP
+			}
+			if service.State == old.State && service.SSH == nil {
+				service.SSH = old.SSH
+			}
+		}
+		byKey[service.Key()] = service
+	}
+
+	merged := make([]database.Service, 0, len(byKey))
+	for _, service := range byKey {
+		merged = append(merged, service)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Protocol != merged[j].Protocol {
+			return merged[i].Protocol < merged[j].Protocol
+		}
+		return merged[i].Port < merged[j].Port
+	})
+	return merged
+}
+
+// CloseMissingServices marks the open services of a protocol on scanned ports
+// that were not found open again as closed
+func CloseMissingServices(services []database.Service, protocol string, scanned, open []int) {
+	scannedSet := make(map[int]bool, len(scanned))
+	for _, port := range scanned {
+		scannedSet[port] = true
+	}
+	for _, port := range open {
+		delete(scannedSet, port)
+	}
+
+	now := time.Now()
+	for i := range services {
+		service := &services[i]
+		if service.Protocol == protocol && service.State == database.StateOpen && scannedSet[service.Port] {
+			service.State = database.StateClosed
+			service.LastSeen = now
+		}
+	}
+}
+
+// isSMTPGreeting tells SMTP apart from FTP, which also greets with 220
+func isSMTPGreeting(data []byte, port int) bool {
+	text := strings.ToUpper(string(data))
+	return port == 25 || port == 465 || port == 587 || strings.Contains(text, "SMTP")
+}
+
+var (
+	sshIdentRe   = regexp.MustCompile(`^SSH-[\d.]+-([^\s_-]+)(?:[_-]([^\s]+))?`)
+	httpServerRe = regexp.MustCompile(`(?im)^Server:\s*(.+?)\s*$`)
+	rfbRe        = regexp.MustCompile(`^RFB (\d{3})\.(\d{3})`)
+	productRe    = regexp.MustCompile(`(?i)\b(Postfix|Exim|Sendmail|Microsoft ESMTP MAIL Service|vsFTPd|ProFTPD|Pure-FTPd|FileZilla Server|Dovecot|Courier|Cyrus|OpenSMTPD|Serv-U)\b[ /v]*(\d+(?:\.\d+)+[\w.-]*)?`)
+)
+
+// parseBanner fills the service name, product and version from a response
+func parseBanner(service *database.Service, data []byte) {
+	text := string(data)
+	service.Banner = cleanBanner(text)
+
+	switch {
+	case strings.HasPrefix(text, "SSH-"):
+		service.Name = "ssh"
+		if m := sshIdentRe.FindStringSubmatch(text); m != nil {
+			service.Product, service.Version = m[1], m[2]
+		}
+
+	case strings.HasPrefix(text, "HTTP/"):
+		if service.Name == "" || !strings.HasPrefix(service.Name, "http") {
+			service.Name = "http"
+		}
+		if m := httpServerRe.FindStringSubmatch(text); m != nil {
+			service.Product, service.Version = splitProductVersion(m[1])
+		}
+
+	case rfbRe.MatchString(text):
+		m := rfbRe.FindStringSubmatch(text)
+		major, _ := strconv.Atoi(m[1])
+		minor, _ := strconv.Atoi(m[2])
+		service.Name = "vnc"
+		service.Product = "RFB"
+		service.Version = fmt.Sprintf("%d.%d", major, minor)
+
+	case strings.HasPrefix(text, "220"):
+		if isSMTPGreeting(data, service.Port) {
+			service.Name = "smtp"
+		} else {
+			service.Name = "ftp"
+		}
+		matchProduct(service, text)
+
+	case strings.HasPrefix(text, "+OK"):
+		service.Name = "pop3"
+		matchProduct(service, text)
+
+	case strings.HasPrefix(text, "* OK"):
+		service.Name = "imap"
+		matchProduct(service, text)
+
+	case len(data) > 5 && data[3] == 0 && data[4] == 10 && data[5] >= '0' && data[5] <= '9':
+		// MySQL handshake: 3-byte length, sequence 0, protocol 10, NUL-terminated version
+		service.Name = "mysql"
+		version := data[5:]
+		if end := bytes.IndexByte(version, 0); end >= 0 {
+			version = version[:end]
+		}
+		service.Product = "MySQL"
+		if bytes.Contains(version, []byte("MariaDB")) {
+			service.Product = "MariaDB"
+		}
+		service.Version = string(version)
+		service.Banner = "" // Binary handshake
+
+	case data[0] == 0xff:
+		// Telnet option negotiation (IAC)
+		service.Name = "telnet"
+		service.Banner = ""
+	}
+}
+
+// matchProduct extracts well-known mail and FTP server products from a greeting
+func matchProduct(service *database.Service, text string) {
+	if m := productRe.FindStringSubmatch(text); m != nil {
+		service.Product, service.Version = m[1], m[2]
+	}
+}
+
+// splitProductVersion splits a Server header such as "nginx/1.18.0 (Ubuntu)"
+func splitProductVersion(server string) (string, string) {
+	fields := strings.Fields(server)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	first := fields[0]
+	if idx := strings.Index(first, "/"); idx > 0 {
+		return first[:idx], first[idx+1:]
+	}
+	return server, ""
+}
+
+// cleanBanner keeps the first line of a text response, without control characters
+func cleanBanner(text string) string {
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	if !scanner.Scan() {
+		return ""
+	}
+	line := strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == 0xfffd {
+			return -1
+		}
+		return r
+	}, scanner.Text())
+	line = strings.TrimSpace(line)
+	if len(line) > maxBannerLen {
+		line = line[:maxBannerLen]
+	}
+	return line
+}
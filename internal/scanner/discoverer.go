@@ -0,0 +1,212 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrNotOnLink is returned when a range is not attached to any local interface
+var ErrNotOnLink = errors.New("range is not on a local link")
+
+// Discoverer finds live hosts in a network range and resolves their MAC addresses
+type Discoverer interface {
+	// Discover probes hosts (all inside network) and returns a map of
+	// IP -> MAC for every host that answered. When ctx is done it returns
+	// the hosts found until then along with the context's error.
+	Discover(ctx context.Context, network *net.IPNet, hosts []net.IP) (map[string]string, error)
+	Name() string
+}
+
+// ARPConfig configures the raw-socket ARP sweep
+type ARPConfig struct {
+	Timeout       time.Duration // Time to wait for replies after the last request
+	BatchSize     int           // Requests sent back-to-back before pausing
+	BatchInterval time.Duration // Pause between batches
+}
+
+// DefaultARPConfig returns sensible ARP sweep settings for a typical LAN
+func DefaultARPConfig() ARPConfig {
+	return ARPConfig{
+		Timeout:       2 * time.Second,
+		BatchSize:     64,
+		BatchInterval: 10 * time.Millisecond,
+	}
+}
+
+// NewDiscoverer returns the ARP discoverer when the process may open raw sockets,
+// and the ping+arp fallback otherwise (e.g. missing CAP_NET_RAW)
+func NewDiscoverer(config ARPConfig) Discoverer {
+	arp, err := NewARPDiscoverer(config)
+	if err != nil {
+		log.Printf("ARP discovery unavailable (%v), falling back to ping", err)
+		return NewPingDiscoverer()
+	}
+	return arp
+}
+
+// PingDiscoverer discovers hosts with the system ping and arp commands
+type PingDiscoverer struct {
+	Workers int // Maximum number of concurrent ping processes
+}
+
+// NewPingDiscoverer creates a ping-based discoverer
+func NewPingDiscoverer() *PingDiscoverer {
+	return &PingDiscoverer{Workers: 64}
+}
+
+// Name returns the discoverer name
+func (p *PingDiscoverer) Name() string {
+	return "ping"
+}
+
+// Discover pings every host in the range and reads MACs from the neighbour table.
+// Echo requests are sent in-process when an ICMP socket is available.
+func (p *PingDiscoverer) Discover(ctx context.Context, network *net.IPNet, hosts []net.IP) (map[string]string, error) {
+	var alive []string
+	if prober, err := NewICMPProber(ICMPConfig{Count: 1}); err == nil {
+		alive, err = p.sweepICMP(ctx, prober, hosts)
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+	} else {
+		alive = p.sweepCommand(ctx, hosts)
+	}
+
+	// The pings populated the neighbour table, so a single read resolves every MAC
+	neighbors := neighborMACs()
+	results := make(map[string]string, len(alive))
+	for _, ip := range alive {
+		mac, ok := neighbors[ip]
+		if !ok {
+			mac = PlaceholderMAC(ip)
+		}
+		results[ip] = mac
+	}
+	return results, ctx.Err()
+}
+
+// sweepICMP sends a single echo request to every host from one socket
+func (p *PingDiscoverer) sweepICMP(ctx context.Context, prober *ICMPProber, hosts []net.IP) ([]string, error) {
+	targets := make([]string, len(hosts))
+	for i, ip := range hosts {
+		targets[i] = ip.String()
+	}
+
+	results, err := prober.Probe(ctx, targets)
+	if results == nil {
+		return nil, err
+	}
+
+	var alive []string
+	for ip, result := range results {
+		if result.Alive() {
+			alive = append(alive, ip)
+		}
+	}
+	return alive, err
+}
+
+// sweepCommand runs the system ping binary with bounded concurrency, until ctx is done
+func (p *PingDiscoverer) sweepCommand(ctx context.Context, hosts []net.IP) []string {
+	var alive []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	workers := p.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+
+	for _, ip := range hosts {
+		select {
+		case sem <- struct{}{}: // Acquire
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(targetIP string) {
+			defer wg.Done()
+			defer func() { <-sem }() // Release
+
+			release, err := probes.Acquire(ctx, targetIP)
+			if err != nil {
+				return
+			}
+			defer release()
+			if isHostAlive(ctx, targetIP) {
+				mu.Lock()
+				alive = append(alive, targetIP)
+				mu.Unlock()
+			}
+		}(ip.String())
+	}
+
+	wg.Wait()
+	return alive
+}
+
+// hostsInRange returns the usable IPv4 host addresses of a network,
+// excluding the network and broadcast addresses where they exist
+func hostsInRange(ipnet *net.IPNet) []net.IP {
+	base := ipnet.IP.Mask(ipnet.Mask).To4()
+	if base == nil {
+		return nil
+	}
+
+	ones, bits := ipnet.Mask.Size()
+	size := uint64(1) << uint(bits-ones)
+	start := uint64(binary.BigEndian.Uint32(base))
+
+	first, last := uint64(0), size-1
+	if size > 2 {
+		first, last = 1, size-2
+	}
+
+	hosts := make([]net.IP, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(start+i))
+		hosts = append(hosts, ip)
+	}
+	return hosts
+}
+
+// localInterfaceFor finds the interface and local address attached to a network
+func localInterfaceFor(ipnet *net.IPNet) (*net.Interface, net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) != 6 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ifnet, ok := addr.(*net.IPNet)
+			if !ok || ifnet.IP.To4() == nil {
+				continue
+			}
+			if ifnet.Contains(ipnet.IP) || ipnet.Contains(ifnet.IP) {
+				return iface, ifnet.IP.To4(), nil
+			}
+		}
+	}
+
+	return nil, nil, ErrNotOnLink
+}
@@ -1,117 +1,298 @@
 package scanner
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
 	"network-scanner-go/internal/database"
+	"network-scanner-go/internal/telemetry"
 	"os/exec"
 	"runtime"
-	"strings"
 	"sync"
 	"time"
 )
 
+var (
+	defaultDiscoverer     Discoverer
+	defaultDiscovererOnce sync.Once
+)
+
+var (
+	discoveryDuration = telemetry.NewHistogram("scanner_discovery_duration_seconds", "Time taken to sweep an IPv4 range for live hosts.", telemetry.ScanBuckets, "range")
+	hostsProbed       = telemetry.NewCounter("scanner_hosts_probed_total", "Addresses probed by host discovery.", "range")
+	hostsAlive        = telemetry.NewGauge("scanner_hosts_alive", "Hosts that answered the last sweep of a range.", "range")
+)
+
 // DiscoverDevices discovers devices on the local network
 func DiscoverDevices(ipRange string) ([]*database.Device, error) {
-	// Parse network range
-	_, ipnet, err := net.ParseCIDR(ipRange)
+	return DiscoverDevicesContext(context.Background(), ipRange, nil)
+}
+
+// DiscoverDevicesContext is DiscoverDevices stopping when ctx is done and
+// sending a progress event as each range is swept. A cancelled discovery
+// returns the devices found until then along with the context's error.
+func DiscoverDevicesContext(ctx context.Context, ipRange string, events chan<- ProgressEvent) ([]*database.Device, error) {
+	defaultDiscovererOnce.Do(func() {
+		defaultDiscoverer = NewDiscoverer(DefaultARPConfig())
+	})
+	return DiscoverDevicesWith(ctx, defaultDiscoverer, ipRange, events)
+}
+
+// DiscoverDevicesWith discovers devices in a range (CIDR, address range or
+// interface name) using the given discoverer
+func DiscoverDevicesWith(ctx context.Context, discoverer Discoverer, ipRange string, events chan<- ProgressEvent) ([]*database.Device, error) {
+	scopes, err := resolveSpec(ipRange)
 	if err != nil {
 		return nil, fmt.Errorf("invalid IP range: %w", err)
 	}
+	return DiscoverScopes(ctx, discoverer, scopes, events)
+}
+
+// DiscoverTarget resolves a scan target and discovers the devices in all of its scopes
+func DiscoverTarget(ctx context.Context, discoverer Discoverer, target ScanTarget, events chan<- ProgressEvent) ([]*database.Device, error) {
+	scopes, err := target.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return DiscoverScopes(ctx, discoverer, scopes, events)
+}
+
+// link is a local interface touched by a discovery, with the IPv6 scopes on it
+type link struct {
+	iface  *net.Interface
+	scopes []Scope
+}
 
-	var devices []*database.Device
-	var mu sync.Mutex
-	var wg sync.WaitGroup
+// DiscoverScopes sweeps each IPv4 scope with the discoverer and each link with
+// NDP. Scopes that are not on a local link are swept with ping instead of ARP.
+// Addresses are grouped by MAC, so a dual-stack host becomes a single device.
+// A progress event follows each IPv4 scope and each link. When ctx is done the
+// sweep stops and the devices found so far are returned with the context's error.
+func DiscoverScopes(ctx context.Context, discoverer Discoverer, scopes []Scope, events chan<- ProgressEvent) ([]*database.Device, error) {
+	_, passive := discoverer.(*NeighborDiscoverer)
+	hosts := make(map[string]string)
+	links := make(map[string]*link)
 
-	// Generate all IPs in range
-	for ip := ipnet.IP.Mask(ipnet.Mask); ipnet.Contains(ip); inc(ip) {
-		ipStr := ip.String()
-		if strings.HasSuffix(ipStr, ".0") || strings.HasSuffix(ipStr, ".255") {
+	addLink := func(iface *net.Interface) *link {
+		l, ok := links[iface.Name]
+		if !ok {
+			l = &link{iface: iface}
+			links[iface.Name] = l
+		}
+		return l
+	}
+
+	done := 0
+	for _, scope := range scopes {
+		if ctx.Err() != nil {
+			break
+		}
+		if scope.IsIPv6() {
+			// A missing interface only loses this scope
+			iface, err := net.InterfaceByName(scope.Interface)
+			if err != nil {
+				log.Printf("IPv6 discovery of %s skipped: %v", scope.Network, err)
+				continue
+			}
+			l := addLink(iface)
+			l.scopes = append(l.scopes, scope)
 			continue
 		}
 
-		wg.Add(1)
-		go func(targetIP string) {
-			defer wg.Done()
+		start := time.Now()
+		found, err := discoverer.Discover(ctx, scope.Network, scope.Hosts)
+		if err != nil && ctx.Err() == nil {
+			if _, isARP := discoverer.(*ARPDiscoverer); !isARP {
+				return nil, err
+			}
+			log.Printf("%s discovery failed for %s (%v), falling back to ping", discoverer.Name(), scope.Network, err)
+			found, err = NewPingDiscoverer().Discover(ctx, scope.Network, scope.Hosts)
+			if err != nil && ctx.Err() == nil {
+				return nil, err
+			}
+		}
+
+		for ip, mac := range found {
+			hosts[ip] = mac
+		}
+
+		rangeName := scope.Network.String()
+		discoveryDuration.ObserveDuration(start, rangeName)
+		if !passive {
+			hostsProbed.Add(float64(len(scope.Hosts)), rangeName)
+		}
+		hostsAlive.Set(float64(len(found)), rangeName)
+		done++
+		emit(ctx, events, ProgressEvent{Stage: StageDiscovery, Target: rangeName, Done: done, Total: len(scopes), Found: len(hosts)})
 
-			if isHostAlive(targetIP) {
-				mac := getMACAddress(targetIP)
-				device := &database.Device{
-					IP:       targetIP,
-					MAC:      mac,
-					LastSeen: time.Now(),
-				}
+		// IPv6 addresses of hosts on this link are attached to their devices
+		if iface, _, err := localInterfaceFor(scope.Network); err == nil {
+			addLink(iface)
+		}
+	}
+
+	// IPv6 neighbours inside an IPv6 scope become devices; the rest only add
+	// addresses to devices already found over IPv4
+	ipv6Addrs := make(map[string]string)
+	for _, l := range links {
+		if ctx.Err() != nil {
+			break
+		}
+		found, err := discoverIPv6(ctx, l.iface, passive)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("IPv6 discovery on %s failed: %v", l.iface.Name, err)
+			continue
+		}
+		for ip, mac := range found {
+			if l.contains(net.ParseIP(ip)) {
+				hosts[ip] = mac
+			} else if mac != "" {
+				ipv6Addrs[ip] = mac
+			}
+		}
+		if len(l.scopes) > 0 {
+			done += len(l.scopes)
+			emit(ctx, events, ProgressEvent{Stage: StageDiscovery, Target: l.iface.Name, Done: done, Total: len(scopes), Found: len(hosts)})
+		}
+	}
 
-				mu.Lock()
-				devices = append(devices, device)
-				mu.Unlock()
+	// Resolve hosts that answered without a MAC from the neighbour table
+	var neighbors map[string]string
+	addresses := make(map[string][]string)
+	for ip, mac := range hosts {
+		if mac == "" || IsPlaceholderMAC(mac) {
+			if neighbors == nil {
+				neighbors = neighborMACs()
 			}
-		}(ipStr)
+			mac = neighbors[ip]
+			if mac == "" {
+				mac = PlaceholderMAC(ip)
+			}
+		}
+		addresses[mac] = append(addresses[mac], ip)
+	}
+	for ip, mac := range ipv6Addrs {
+		if _, ok := addresses[mac]; ok {
+			addresses[mac] = append(addresses[mac], ip)
+		}
+	}
+
+	devices := make([]*database.Device, 0, len(addresses))
+	now := time.Now()
+	for mac, addrs := range addresses {
+		addrs = SortAddresses(addrs)
+		devices = append(devices, &database.Device{
+			IP:        addrs[0],
+			Addresses: addrs,
+			MAC:       mac,
+			LastSeen:  now,
+		})
+	}
+
+	// Passive discovery must not send any traffic
+	if !passive {
+		measureLatency(ctx, devices)
 	}
 
-	wg.Wait()
 	log.Printf("Discovered %d devices\n", len(devices))
-	return devices, nil
+	return devices, ctx.Err()
+}
+
+// contains reports whether ip belongs to one of the link's IPv6 scopes
+func (l *link) contains(ip net.IP) bool {
+	for i := range l.scopes {
+		if l.scopes[i].Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// measureLatency records RTT and TTL for the discovered devices
+func measureLatency(ctx context.Context, devices []*database.Device) {
+	if len(devices) == 0 {
+		return
+	}
+
+	prober, err := NewICMPProber(DefaultICMPConfig())
+	if err != nil {
+		return
+	}
+
+	targets := make([]string, len(devices))
+	for i, d := range devices {
+		targets[i] = d.IP
+	}
+
+	results, err := prober.Probe(ctx, targets)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Latency measurement failed: %v", err)
+		return
+	}
+
+	for _, d := range devices {
+		if result, ok := results[d.IP]; ok && result.Alive() {
+			d.RTT = float64(result.AvgRTT.Microseconds()) / 1000.0
+			d.TTL = result.TTL
+		}
+	}
 }
 
 // isHostAlive checks if a host is alive using ping
-func isHostAlive(ip string) bool {
+func isHostAlive(ctx context.Context, ip string) bool {
 	var cmd *exec.Cmd
 
 	if runtime.GOOS == "windows" {
-		cmd = exec.Command("ping", "-n", "1", "-w", "500", ip)
+		cmd = exec.CommandContext(ctx, "ping", "-n", "1", "-w", "500", ip)
 	} else {
-		cmd = exec.Command("ping", "-c", "1", "-W", "1", ip)
+		cmd = exec.CommandContext(ctx, "ping", "-c", "1", "-W", "1", ip)
 	}
 
 	err := cmd.Run()
 	return err == nil
 }
 
-// getMACAddress gets the MAC address for an IP from ARP table
-func getMACAddress(ip string) string {
-	var cmd *exec.Cmd
-
-	if runtime.GOOS == "windows" {
-		cmd = exec.Command("arp", "-a", ip)
-	} else {
-		cmd = exec.Command("arp", "-n", ip)
+// GetLocalInterface returns the name of the interface that carries the
+// default route, trying IPv4 first and then IPv6
+func GetLocalInterface() (string, error) {
+	var localIP net.IP
+	for _, probe := range []string{"8.8.8.8:80", "[2001:4860:4860::8888]:80"} {
+		conn, err := net.Dial("udp", probe)
+		if err != nil {
+			continue
+		}
+		localIP = conn.LocalAddr().(*net.UDPAddr).IP
+		conn.Close()
+		break
+	}
+	if localIP == nil {
+		return "", fmt.Errorf("no default route")
 	}
 
-	output, err := cmd.Output()
+	ifaces, err := net.Interfaces()
 	if err != nil {
-		return fmt.Sprintf("unknown_%s", ip)
-	}
-
-	// Parse MAC address from output
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, ip) {
-			fields := strings.Fields(line)
-			for _, field := range fields {
-				if strings.Contains(field, "-") || strings.Contains(field, ":") {
-					mac := strings.ReplaceAll(field, "-", ":")
-					return strings.ToLower(mac)
-				}
+		return "", err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ifnet, ok := addr.(*net.IPNet); ok && ifnet.IP.Equal(localIP) {
+				return iface.Name, nil
 			}
 		}
 	}
 
-	return fmt.Sprintf("unknown_%s", ip)
+	return "", fmt.Errorf("no interface owns %s", localIP)
 }
 
-// inc increments an IP address
-func inc(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
-		}
-	}
-}
-
-// GetLocalNetwork detects the local network range
+// GetLocalNetwork detects the IPv4 network of the interface that carries the default route
 func GetLocalNetwork() (string, error) {
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
@@ -119,9 +300,20 @@ func GetLocalNetwork() (string, error) {
 	}
 	defer conn.Close()
 
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-	ip := localAddr.IP.To4()
+	localIP := conn.LocalAddr().(*net.UDPAddr).IP.To4()
+	if localIP == nil {
+		return "", fmt.Errorf("default route is not IPv4")
+	}
+
+	networks, err := LocalNetworks()
+	if err != nil {
+		return "", err
+	}
+	for _, ipnet := range networks {
+		if ipnet.Contains(localIP) {
+			return ipnet.String(), nil
+		}
+	}
 
-	// Assume /24 network
-	return fmt.Sprintf("%d.%d.%d.0/24", ip[0], ip[1], ip[2]), nil
+	return "", fmt.Errorf("no interface owns %s", localIP)
 }
@@ -0,0 +1,456 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"network-scanner-go/internal/database"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FingerprintRule classifies devices whose signals satisfy all of its match
+// conditions. Each rule votes for a type, OS and/or model with its weight.
+type FingerprintRule struct {
+	ID          string           `json:"id"`
+	Description string           `json:"description,omitempty"`
+	Match       FingerprintMatch `json:"match"`
+	Type        string           `json:"type,omitempty"`
+	OS          string           `json:"os,omitempty"`
+	Model       string           `json:"model,omitempty"`
+	Weight      float64          `json:"weight"` // Strength of the evidence, 0-1
+}
+
+// FingerprintMatch holds the conditions of a rule. Text conditions are
+// case-insensitive regular expressions; ports are "22" (TCP) or "161/udp".
+type FingerprintMatch struct {
+	Vendor    string   `json:"vendor,omitempty"`     // OUI vendor
+	Ports     []string `json:"ports,omitempty"`      // All of these are open
+	AnyPorts  []string `json:"any_ports,omitempty"`  // At least one of these is open
+	NoPorts   []string `json:"no_ports,omitempty"`   // None of these is open
+	Service   string   `json:"service,omitempty"`    // "name product version" of an open service
+	Banner    string   `json:"banner,omitempty"`     // Banner of an open service
+	HTTPTitle string   `json:"http_title,omitempty"` // Title of a web page
+	HTTP      string   `json:"http,omitempty"`       // Server, X-Powered-By or authentication realm of a web interface
+	Favicon   []int32  `json:"favicon,omitempty"`    // Any of these favicon hashes (see FaviconHash)
+	MDNS      string   `json:"mdns,omitempty"`       // Advertised mDNS service types and TXT records
+	Hostname  string   `json:"hostname,omitempty"`   // Any mDNS, DNS, NetBIOS or LLMNR name
+	SSDP      string   `json:"ssdp,omitempty"`       // SSDP SERVER header or device description
+	DHCP      string   `json:"dhcp,omitempty"`       // DHCP vendor class, hostname or parameter list
+	SNMP      string   `json:"snmp,omitempty"`       // SNMP sysDescr, sysObjectID, sysName or model
+	TTL       string   `json:"ttl,omitempty"`        // OS family guessed from the TTL (see GuessOSFromTTL)
+
+	vendor, service, banner, httpTitle, http, mdns, hostname, ssdp, dhcp, snmp *regexp.Regexp
+}
+
+// Signals is the evidence about a device that fingerprint rules match against
+type Signals struct {
+	Vendor     string
+	TTL        int
+	OpenPorts  map[string]bool // "22/tcp", "161/udp"
+	Services   []string        // "name product version" of open services
+	Banners    []string
+	HTTPTitles []string
+	HTTP       []string // Server and X-Powered-By headers and authentication realms
+	Favicons   []int32
+	MDNS       []string // "_type._proto instance txt..." per advertised service
+	Hostnames  []string
+	SSDP       []string
+	DHCP       []string
+	SNMP       []string // sysDescr, sysObjectID, sysName and model
+}
+
+// Classification is the outcome of fingerprinting a device
+type Classification struct {
+	Type       string                         `json:"type"`
+	OS         string                         `json:"os"`
+	Model      string                         `json:"model"`
+	Confidence float64                        `json:"confidence"` // Confidence in the type, 0-1
+	Evidence   []database.FingerprintEvidence `json:"evidence"`
+}
+
+var (
+	fingerprintRules   []*FingerprintRule
+	fingerprintRulesMu sync.RWMutex
+)
+
+func init() {
+	// Port-based defaults, used until a rule file is loaded
+	builtins := []*FingerprintRule{
+		{ID: "builtin-node-exporter", Match: FingerprintMatch{Ports: []string{"9100"}}, Type: "Node Exporter", Weight: 0.5},
+		{ID: "builtin-rdp", Match: FingerprintMatch{Ports: []string{"3389"}}, Type: "Windows PC", OS: "Windows", Weight: 0.6},
+		{ID: "builtin-smb", Match: FingerprintMatch{Ports: []string{"445"}}, Type: "Windows/Samba", Weight: 0.3},
+		{ID: "builtin-ssh", Match: FingerprintMatch{Ports: []string{"22"}, NoPorts: []string{"80"}}, Type: "Linux Server", Weight: 0.3},
+		{ID: "builtin-web", Match: FingerprintMatch{AnyPorts: []string{"80", "443"}}, Type: "Web Server", Weight: 0.2},
+		{ID: "builtin-mqtt", Match: FingerprintMatch{AnyPorts: []string{"1883", "8883"}}, Type: "MQTT Broker", Weight: 0.4},
+	}
+	for _, rule := range builtins {
+		if err := rule.compile(); err != nil {
+			panic(err)
+		}
+	}
+	fingerprintRules = builtins
+}
+
+// LoadFingerprintRules loads fingerprint rules from a JSON file. They replace
+// the built-in port-based rules.
+func LoadFingerprintRules(configPath string) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	var rules []*FingerprintRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+
+	seen := make(map[string]bool)
+	for i, rule := range rules {
+		if rule.ID == "" {
+			return fmt.Errorf("fingerprint rule %d has no id", i+1)
+		}
+		if seen[rule.ID] {
+			return fmt.Errorf("duplicate fingerprint rule %q", rule.ID)
+		}
+		seen[rule.ID] = true
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("fingerprint rule %q: %w", rule.ID, err)
+		}
+	}
+
+	fingerprintRulesMu.Lock()
+	fingerprintRules = rules
+	fingerprintRulesMu.Unlock()
+	return nil
+}
+
+// GetDefaultFingerprintRulesPath returns the likely path for fingerprint rules
+func GetDefaultFingerprintRulesPath() string {
+	return filepath.Join("configs", "fingerprint_rules.json")
+}
+
+// compile validates a rule and compiles its patterns
+func (r *FingerprintRule) compile() error {
+	if r.Weight <= 0 || r.Weight > 1 {
+		return fmt.Errorf("weight must be in (0, 1], got %v", r.Weight)
+	}
+	if r.Type == "" && r.OS == "" && r.Model == "" {
+		return fmt.Errorf("rule sets no type, os or model")
+	}
+
+	m := &r.Match
+	for _, ports := range [][]string{m.Ports, m.AnyPorts, m.NoPorts} {
+		for i, port := range ports {
+			key, err := portKey(port)
+			if err != nil {
+				return err
+			}
+			ports[i] = key
+		}
+	}
+
+	patterns := []struct {
+		expr string
+		re   **regexp.Regexp
+	}{
+		{m.Vendor, &m.vendor}, {m.Service, &m.service}, {m.Banner, &m.banner},
+		{m.HTTPTitle, &m.httpTitle}, {m.HTTP, &m.http}, {m.MDNS, &m.mdns}, {m.Hostname, &m.hostname},
+		{m.SSDP, &m.ssdp}, {m.DHCP, &m.dhcp}, {m.SNMP, &m.snmp},
+	}
+	empty := m.TTL == "" && len(m.Ports) == 0 && len(m.AnyPorts) == 0 && len(m.NoPorts) == 0 && len(m.Favicon) == 0
+	for _, p := range patterns {
+		if p.expr == "" {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + p.expr)
+		if err != nil {
+			return err
+		}
+		*p.re = re
+		empty = false
+	}
+	if empty {
+		return fmt.Errorf("rule has no match conditions")
+	}
+	return nil
+}
+
+// portKey normalizes "22" and "161/udp" to "22/tcp" and "161/udp"
+func portKey(spec string) (string, error) {
+	port, protocol := spec, "tcp"
+	if idx := strings.Index(spec, "/"); idx >= 0 {
+		port, protocol = spec[:idx], strings.ToLower(spec[idx+1:])
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 || (protocol != "tcp" && protocol != "udp") {
+		return "", fmt.Errorf("invalid port %q", spec)
+	}
+	return fmt.Sprintf("%d/%s", n, protocol), nil
+}
+
+// DeviceSignals collects the fingerprinting evidence known about a device
+func DeviceSignals(device *database.Device) Signals {
+	signals := Signals{
+		Vendor:    device.Vendor,
+		TTL:       device.TTL,
+		OpenPorts: make(map[string]bool),
+	}
+
+	for _, port := range device.OpenPorts {
+		signals.OpenPorts[fmt.Sprintf("%d/tcp", port)] = true
+	}
+	for _, service := range device.Services {
+		if service.State != database.StateOpen {
+			continue
+		}
+		signals.OpenPorts[service.Key()] = true
+		if text := strings.TrimSpace(strings.Join([]string{service.Name, service.Product, service.Version}, " ")); text != "" {
+			signals.Services = append(signals.Services, text)
+		}
+		if service.Banner != "" {
+			signals.Banners = append(signals.Banners, service.Banner)
+		}
+		if web := service.HTTP; web != nil {
+			if web.Title != "" {
+				signals.HTTPTitles = append(signals.HTTPTitles, web.Title)
+			}
+			for _, text := range []string{web.Server, web.PoweredBy, web.AuthRealm} {
+				if text != "" {
+					signals.HTTP = append(signals.HTTP, text)
+				}
+			}
+			if web.FaviconHash != 0 {
+				signals.Favicons = append(signals.Favicons, web.FaviconHash)
+			}
+		}
+	}
+	for _, service := range device.MDNSServices {
+		fields := append([]string{service.Type, service.Name}, service.TXT...)
+		signals.MDNS = append(signals.MDNS, strings.Join(fields, " "))
+	}
+	for _, name := range device.Names {
+		signals.Hostnames = append(signals.Hostnames, name.Name)
+	}
+	if len(device.Names) == 0 && device.Hostname != "" {
+		signals.Hostnames = append(signals.Hostnames, device.Hostname)
+	}
+	if upnp := device.UPnP; upnp != nil {
+		model := strings.TrimSpace(strings.Join([]string{upnp.Manufacturer, upnp.ModelName, upnp.ModelNumber}, " "))
+		for _, text := range []string{upnp.DeviceType, upnp.Server, model, upnp.FriendlyName} {
+			if text != "" {
+				signals.SSDP = append(signals.SSDP, text)
+			}
+		}
+	}
+	if dhcp := device.DHCP; dhcp != nil {
+		for _, text := range []string{dhcp.VendorClass, dhcp.Hostname, dhcp.Fingerprint} {
+			if text != "" {
+				signals.DHCP = append(signals.DHCP, text)
+			}
+		}
+	}
+	if info := device.SNMP; info != nil {
+		for _, text := range []string{info.SysDescr, info.SysObjectID, info.SysName, info.Model} {
+			if text != "" {
+				signals.SNMP = append(signals.SNMP, text)
+			}
+		}
+	}
+	if service := device.FindService("udp", 1900); service != nil && service.State == database.StateOpen && service.Banner != "" {
+		signals.SSDP = append(signals.SSDP, service.Banner)
+	}
+
+	return signals
+}
+
+// match returns the conditions of the rule satisfied by the signals, or
+// false if any condition fails
+func (m *FingerprintMatch) match(s *Signals) ([]string, bool) {
+	var matched []string
+
+	if m.vendor != nil {
+		if !m.vendor.MatchString(s.Vendor) {
+			return nil, false
+		}
+		matched = append(matched, "vendor="+s.Vendor)
+	}
+
+	for _, port := range m.Ports {
+		if !s.OpenPorts[port] {
+			return nil, false
+		}
+	}
+	if len(m.Ports) > 0 {
+		matched = append(matched, "ports="+strings.Join(m.Ports, ","))
+	}
+
+	if len(m.AnyPorts) > 0 {
+		found := ""
+		for _, port := range m.AnyPorts {
+			if s.OpenPorts[port] {
+				found = port
+				break
+			}
+		}
+		if found == "" {
+			return nil, false
+		}
+		matched = append(matched, "port="+found)
+	}
+
+	for _, port := range m.NoPorts {
+		if s.OpenPorts[port] {
+			return nil, false
+		}
+	}
+	if len(m.NoPorts) > 0 {
+		matched = append(matched, "not_open="+strings.Join(m.NoPorts, ","))
+	}
+
+	texts := []struct {
+		name   string
+		re     *regexp.Regexp
+		values []string
+	}{
+		{"service", m.service, s.Services}, {"banner", m.banner, s.Banners},
+		{"http_title", m.httpTitle, s.HTTPTitles}, {"http", m.http, s.HTTP},
+		{"mdns", m.mdns, s.MDNS}, {"hostname", m.hostname, s.Hostnames},
+		{"ssdp", m.ssdp, s.SSDP}, {"dhcp", m.dhcp, s.DHCP},
+		{"snmp", m.snmp, s.SNMP},
+	}
+	for _, t := range texts {
+		if t.re == nil {
+			continue
+		}
+		value, ok := firstMatch(t.re, t.values)
+		if !ok {
+			return nil, false
+		}
+		matched = append(matched, t.name+"="+value)
+	}
+
+	if len(m.Favicon) > 0 {
+		hash, ok := firstFavicon(m.Favicon, s.Favicons)
+		if !ok {
+			return nil, false
+		}
+		matched = append(matched, fmt.Sprintf("favicon=%d", hash))
+	}
+
+	if m.TTL != "" {
+		family := GuessOSFromTTL(s.TTL)
+		if family == "" || !strings.EqualFold(family, m.TTL) {
+			return nil, false
+		}
+		matched = append(matched, fmt.Sprintf("ttl=%d (%s)", s.TTL, family))
+	}
+
+	return matched, true
+}
+
+// firstMatch returns the first value matched by re
+func firstMatch(re *regexp.Regexp, values []string) (string, bool) {
+	for _, value := range values {
+		if re.MatchString(value) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// firstFavicon returns the first wanted hash that was seen
+func firstFavicon(wanted, seen []int32) (int32, bool) {
+	for _, hash := range wanted {
+		for _, s := range seen {
+			if hash == s {
+				return hash, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Classify runs the fingerprint rules against the signals. Rules voting for
+// the same value reinforce each other: the score of a value is the chance
+// that at least one of its rules is right, 1 - Π(1 - weight). The best-scoring
+// type, OS and model win, and the type's score is the confidence.
+func Classify(signals Signals) Classification {
+	fingerprintRulesMu.RLock()
+	rules := fingerprintRules
+	fingerprintRulesMu.RUnlock()
+
+	scores := map[string]map[string]float64{"type": {}, "os": {}, "model": {}}
+	vote := func(dimension, value string, weight float64) {
+		if value != "" {
+			scores[dimension][value] = 1 - (1-scores[dimension][value])*(1-weight)
+		}
+	}
+
+	result := Classification{Type: "Unknown", Evidence: []database.FingerprintEvidence{}}
+	for _, rule := range rules {
+		matched, ok := rule.Match.match(&signals)
+		if !ok {
+			continue
+		}
+
+		vote("type", rule.Type, rule.Weight)
+		vote("os", rule.OS, rule.Weight)
+		vote("model", rule.Model, rule.Weight)
+		result.Evidence = append(result.Evidence, database.FingerprintEvidence{
+			RuleID:  rule.ID,
+			Type:    rule.Type,
+			OS:      rule.OS,
+			Model:   rule.Model,
+			Weight:  rule.Weight,
+			Matched: matched,
+		})
+	}
+
+	if value, score := best(scores["type"]); value != "" {
+		result.Type = value
+		result.Confidence = math.Round(score*1000) / 1000
+	}
+	result.OS, _ = best(scores["os"])
+	result.Model, _ = best(scores["model"])
+
+	sort.SliceStable(result.Evidence, func(i, j int) bool {
+		return result.Evidence[i].Weight > result.Evidence[j].Weight
+	})
+	return result
+}
+
+// best returns the highest-scoring value, ties going to the alphabetically first
+func best(scores map[string]float64) (string, float64) {
+	var value string
+	var score float64
+	for v, s := range scores {
+		if s > score || (s == score && v < value) {
+			value, score = v, s
+		}
+	}
+	return value, score
+}
+
+// ClassifyDevice fingerprints a device and stores the result on it
+func ClassifyDevice(device *database.Device) Classification {
+	result := Classify(DeviceSignals(device))
+	device.Type = result.Type
+	device.OS = result.OS
+	device.Model = result.Model
+	// The device's own description beats having no model at all
+	if device.Model == "" && device.SNMP != nil {
+		device.Model = device.SNMP.Model
+	}
+	if device.Model == "" && device.UPnP != nil {
+		device.Model = strings.TrimSpace(device.UPnP.Manufacturer + " " + device.UPnP.ModelName)
+	}
+	device.Confidence = result.Confidence
+	device.Evidence = result.Evidence
+	return result
+}
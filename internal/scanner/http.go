@@ -0,0 +1,219 @@
+package scanner
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math/bits"
+	"net"
+	"net/http"
+	"net/url"
+	"network-scanner-go/internal/database"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpTimeout bounds each request made while inspecting a web interface
+const httpTimeout = 2 * time.Second
+
+// maxHTTPBody caps how much of a page or favicon is read
+const maxHTTPBody = 512 * 1024
+
+// maxRedirects is how many redirects are followed from "/"
+const maxRedirects = 5
+
+var (
+	titleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	linkRe  = regexp.MustCompile(`(?is)<link\b[^>]*>`)
+	relRe   = regexp.MustCompile(`(?is)\brel\s*=\s*["']?([^"'>]*)`)
+	hrefRe  = regexp.MustCompile(`(?is)\bhref\s*=\s*["']?([^"'\s>]+)`)
+	realmRe = regexp.MustCompile(`(?i)\brealm\s*=\s*"([^"]*)"`)
+)
+
+// IsHTTPService reports whether a service is a web server
+func IsHTTPService(service *database.Service) bool {
+	return strings.HasPrefix(service.Name, "http")
+}
+
+// InspectHTTP requests "/" and records the status, headers, title, redirect
+// chain and authentication realm of the response, then hashes the favicon.
+// Redirects are only followed on the same host.
+func InspectHTTP(ctx context.Context, ip string, port int, useTLS bool, timeout time.Duration) (*database.HTTPInfo, error) {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	base := &url.URL{Scheme: scheme, Host: net.JoinHostPort(ip, strconv.Itoa(port)), Path: "/"}
+
+	info := &database.HTTPInfo{}
+	client := newHTTPClient(timeout, func(req *http.Request, via []*http.Request) error {
+		info.Redirects = append(info.Redirects, req.URL.String())
+		if len(via) >= maxRedirects || req.URL.Host != base.Host {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	})
+
+	resp, err := httpGet(ctx, client, base.String())
+	if err != nil {
+		return nil, err
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxHTTPBody))
+	resp.Body.Close()
+
+	info.Status = resp.StatusCode
+	info.Server = resp.Header.Get("Server")
+	info.PoweredBy = resp.Header.Get("X-Powered-By")
+	info.Title = pageTitle(body)
+	if m := realmRe.FindStringSubmatch(resp.Header.Get("WWW-Authenticate")); m != nil {
+		info.AuthRealm = m[1]
+	}
+
+	faviconURL := resp.Request.URL.ResolveReference(&url.URL{Path: "/favicon.ico"})
+	if href := faviconHref(body); href != "" {
+		if ref, err := resp.Request.URL.Parse(href); err == nil && ref.Host == base.Host {
+			faviconURL = ref
+		}
+	}
+	if hash, err := fetchFaviconHash(ctx, client, faviconURL.String()); err == nil {
+		info.FaviconHash = hash
+	}
+
+	return info, nil
+}
+
+// newHTTPClient returns a client that accepts any certificate and does not
+// keep connections open
+func newHTTPClient(timeout time.Duration, checkRedirect func(*http.Request, []*http.Request) error) *http.Client {
+	return &http.Client{
+		Timeout:       timeout,
+		CheckRedirect: checkRedirect,
+		Transport: &http.Transport{
+			DialContext:       probes.DialContext,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+// httpGet sends a GET request identifying the scanner
+func httpGet(ctx context.Context, client *http.Client, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "network-scanner")
+	return client.Do(req)
+}
+
+// pageTitle returns the unescaped, whitespace-collapsed title of an HTML page
+func pageTitle(body []byte) string {
+	m := titleRe.FindSubmatch(body)
+	if m == nil {
+		return ""
+	}
+	title := strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " ")
+	if len(title) > maxBannerLen {
+		title = title[:maxBannerLen]
+	}
+	return title
+}
+
+// faviconHref returns the href of the first <link rel="icon"> of a page
+func faviconHref(body []byte) string {
+	for _, tag := range linkRe.FindAll(body, -1) {
+		rel := relRe.FindSubmatch(tag)
+		if rel == nil || !strings.Contains(strings.ToLower(string(rel[1])), "icon") {
+			continue
+		}
+		if href := hrefRe.FindSubmatch(tag); href != nil && !strings.HasPrefix(string(href[1]), "data:") {
+			return html.UnescapeString(string(href[1]))
+		}
+	}
+	return ""
+}
+
+// fetchFaviconHash downloads a favicon and returns its hash
+func fetchFaviconHash(ctx context.Context, client *http.Client, rawURL string) (int32, error) {
+	resp, err := httpGet(ctx, client, rawURL)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("favicon: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPBody))
+	if err != nil {
+		return 0, err
+	}
+	if len(data) == 0 {
+		return 0, errors.New("favicon: empty response")
+	}
+	return FaviconHash(data), nil
+}
+
+// FaviconHash returns the Shodan-style favicon hash: the signed 32-bit mmh3
+// of the base64 encoding with a newline after every 76 characters
+func FaviconHash(data []byte) int32 {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var b strings.Builder
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76])
+		b.WriteByte('\n')
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded)
+	b.WriteByte('\n')
+	return int32(murmur3([]byte(b.String()), 0))
+}
+
+// murmur3 is MurmurHash3 x86_32
+func murmur3(data []byte, seed uint32) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+
+	h := seed
+	n := len(data) / 4
+	for i := 0; i < n; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	var k uint32
+	tail := data[n*4:]
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,318 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	icmpEchoReply   = 0
+	icmpEchoRequest = 8
+)
+
+// ICMPConfig configures in-process ICMP echo sweeps
+type ICMPConfig struct {
+	Count         int           // Echo requests per host
+	Interval      time.Duration // Delay between rounds of requests
+	Timeout       time.Duration // Time to wait for replies after the last round
+	BatchSize     int           // Requests sent back-to-back before pausing
+	BatchInterval time.Duration // Pause between batches
+}
+
+// DefaultICMPConfig returns settings suitable for measuring latency of live hosts
+func DefaultICMPConfig() ICMPConfig {
+	return ICMPConfig{
+		Count:         3,
+		Interval:      200 * time.Millisecond,
+		Timeout:       1 * time.Second,
+		BatchSize:     128,
+		BatchInterval: 5 * time.Millisecond,
+	}
+}
+
+// PingResult holds the echo statistics of a single host
+type PingResult struct {
+	IP       string        `json:"ip"`
+	Sent     int           `json:"sent"`
+	Received int           `json:"received"`
+	MinRTT   time.Duration `json:"min_rtt"`
+	AvgRTT   time.Duration `json:"avg_rtt"`
+	MaxRTT   time.Duration `json:"max_rtt"`
+	TTL      int           `json:"ttl"`
+
+	totalRTT time.Duration
+}
+
+// Alive reports whether the host answered at least one request
+func (r *PingResult) Alive() bool {
+	return r.Received > 0
+}
+
+// Loss returns the fraction of requests that went unanswered (0.0 - 1.0)
+func (r *PingResult) Loss() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Sent-r.Received) / float64(r.Sent)
+}
+
+// icmpConn abstracts unprivileged (SOCK_DGRAM) and raw ICMP sockets
+type icmpConn interface {
+	writeTo(b []byte, ip net.IP) error
+	readFrom(b []byte) (payload []byte, src net.IP, ttl int, err error)
+	setReadDeadline(t time.Time) error
+	close() error
+	// filtersByID is true when the kernel does not demultiplex replies for us
+	filtersByID() bool
+	mode() string
+}
+
+// ICMPProber sends ICMP echo requests from within the process
+type ICMPProber struct {
+	config ICMPConfig
+	mode   string
+}
+
+var icmpIDCounter uint32
+
+// NewICMPProber verifies that an ICMP socket can be opened. It prefers
+// unprivileged ping sockets (net.ipv4.ping_group_range) and falls back to raw sockets.
+func NewICMPProber(config ICMPConfig) (*ICMPProber, error) {
+	conn, err := listenICMP()
+	if err != nil {
+		return nil, err
+	}
+	mode := conn.mode()
+	conn.close()
+
+	defaults := DefaultICMPConfig()
+	if config.Count <= 0 {
+		config.Count = defaults.Count
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+
+	return &ICMPProber{config: config, mode: mode}, nil
+}
+
+// Mode returns "unprivileged" or "raw" depending on the socket type in use
+func (p *ICMPProber) Mode() string {
+	return p.mode
+}
+
+// Probe pings all targets concurrently and returns per-host statistics.
+// Each call uses its own socket, so Probe is safe for concurrent use. When
+// ctx is done, sending stops and the statistics so far are returned along
+// with the context's error.
+func (p *ICMPProber) Probe(ctx context.Context, targets []string) (map[string]*PingResult, error) {
+	conn, err := listenICMP()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	type pendingKey struct {
+		ip  [4]byte
+		seq uint16
+	}
+
+	results := make(map[string]*PingResult)
+	addrs := make([]net.IP, 0, len(targets))
+	for _, target := range targets {
+		ip := net.ParseIP(target).To4()
+		if ip == nil {
+			continue
+		}
+		if _, dup := results[ip.String()]; dup {
+			continue
+		}
+		results[ip.String()] = &PingResult{IP: ip.String()}
+		addrs = append(addrs, ip)
+	}
+
+	id := uint16(os.Getpid()) ^ uint16(atomic.AddUint32(&icmpIDCounter, 1))
+	pending := make(map[pendingKey]time.Time)
+	var mu sync.Mutex
+	done := make(chan struct{})
+	readerDone := make(chan struct{})
+
+	// Collect replies while requests are being sent
+	go func() {
+		defer close(readerDone)
+		buf := make([]byte, 1500)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			conn.setReadDeadline(time.Now().Add(100 * time.Millisecond))
+			payload, src, ttl, err := conn.readFrom(buf)
+			if err != nil {
+				continue
+			}
+			received := time.Now()
+
+			msgType, replyID, seq, ok := parseICMPEcho(payload)
+			if !ok || msgType != icmpEchoReply {
+				continue
+			}
+			if conn.filtersByID() && replyID != id {
+				continue
+			}
+
+			var key pendingKey
+			copy(key.ip[:], src.To4())
+			key.seq = seq
+
+			mu.Lock()
+			sent, ok := pending[key]
+			if ok {
+				delete(pending, key)
+				result := results[src.String()]
+				rtt := received.Sub(sent)
+				result.Received++
+				result.totalRTT += rtt
+				result.AvgRTT = result.totalRTT / time.Duration(result.Received)
+				if result.MinRTT == 0 || rtt < result.MinRTT {
+					result.MinRTT = rtt
+				}
+				if rtt > result.MaxRTT {
+					result.MaxRTT = rtt
+				}
+				if ttl > 0 {
+					result.TTL = ttl
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	var seq uint16
+	msg := make([]byte, 16)
+	sentInBatch := 0
+send:
+	for round := 0; round < p.config.Count; round++ {
+		if round > 0 && p.config.Interval > 0 {
+			if sleepContext(ctx, p.config.Interval) != nil {
+				break
+			}
+		}
+
+		for _, ip := range addrs {
+			if probes.Wait(ctx) != nil {
+				break send
+			}
+			seq++
+			var key pendingKey
+			copy(key.ip[:], ip)
+			key.seq = seq
+
+			buildICMPEcho(msg, id, seq)
+
+			mu.Lock()
+			pending[key] = time.Now()
+			results[ip.String()].Sent++
+			mu.Unlock()
+
+			conn.writeTo(msg, ip)
+
+			sentInBatch++
+			if sentInBatch >= p.config.BatchSize && p.config.BatchInterval > 0 {
+				sleepContext(ctx, p.config.BatchInterval)
+				sentInBatch = 0
+			}
+		}
+	}
+
+	sleepContext(ctx, p.config.Timeout)
+	close(done)
+	<-readerDone
+
+	return results, ctx.Err()
+}
+
+// buildICMPEcho fills msg with an echo request carrying a send timestamp
+func buildICMPEcho(msg []byte, id, seq uint16) {
+	msg[0] = icmpEchoRequest
+	msg[1] = 0 // Code
+	msg[2], msg[3] = 0, 0
+	binary.BigEndian.PutUint16(msg[4:6], id)
+	binary.BigEndian.PutUint16(msg[6:8], seq)
+	binary.BigEndian.PutUint64(msg[8:16], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint16(msg[2:4], icmpChecksum(msg))
+}
+
+// parseICMPEcho extracts type, identifier and sequence from an echo message
+func parseICMPEcho(msg []byte) (msgType uint8, id, seq uint16, ok bool) {
+	if len(msg) < 8 {
+		return 0, 0, 0, false
+	}
+	return msg[0], binary.BigEndian.Uint16(msg[4:6]), binary.BigEndian.Uint16(msg[6:8]), true
+}
+
+// icmpChecksum computes the Internet checksum (RFC 1071)
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// rawICMPConn wraps an "ip4:icmp" socket, which requires CAP_NET_RAW or Administrator
+type rawICMPConn struct {
+	conn *net.IPConn
+}
+
+func (c *rawICMPConn) writeTo(b []byte, ip net.IP) error {
+	_, err := c.conn.WriteTo(b, &net.IPAddr{IP: ip})
+	return err
+}
+
+func (c *rawICMPConn) setReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *rawICMPConn) close() error {
+	return c.conn.Close()
+}
+
+func (c *rawICMPConn) filtersByID() bool {
+	return true
+}
+
+func (c *rawICMPConn) mode() string {
+	return "raw"
+}
+
+// GuessOSFromTTL estimates the operating system family from a reply TTL,
+// based on the common initial values 64 (Unix), 128 (Windows) and 255 (network gear)
+func GuessOSFromTTL(ttl int) string {
+	switch {
+	case ttl <= 0:
+		return ""
+	case ttl <= 64:
+		return "Linux/Unix"
+	case ttl <= 128:
+		return "Windows"
+	default:
+		return "Network Device"
+	}
+}
@@ -0,0 +1,121 @@
+//go:build linux
+
+package scanner
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// listenICMP opens an unprivileged ping socket if net.ipv4.ping_group_range
+// allows it, and a raw ICMP socket otherwise
+func listenICMP() (icmpConn, error) {
+	dgramConn, dgramErr := listenUnprivilegedICMP()
+	if dgramErr == nil {
+		return dgramConn, nil
+	}
+
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return nil, fmt.Errorf("ping socket: %v; raw socket: %w", dgramErr, err)
+	}
+	return &rawICMPConn{conn: conn.(*net.IPConn)}, nil
+}
+
+// listenUnprivilegedICMP opens a SOCK_DGRAM ICMP socket and enables TTL reporting
+func listenUnprivilegedICMP() (icmpConn, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_ICMP)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_RECVTTL, 1); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+
+	conn, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected connection type %T", conn)
+	}
+	return &dgramICMPConn{conn: udpConn}, nil
+}
+
+// dgramICMPConn wraps an unprivileged ping socket. The kernel assigns the echo
+// identifier and only delivers replies that belong to this socket.
+type dgramICMPConn struct {
+	conn *net.UDPConn
+}
+
+func (c *dgramICMPConn) writeTo(b []byte, ip net.IP) error {
+	_, err := c.conn.WriteTo(b, &net.UDPAddr{IP: ip})
+	return err
+}
+
+func (c *dgramICMPConn) readFrom(b []byte) ([]byte, net.IP, int, error) {
+	oob := make([]byte, 64)
+	n, oobn, _, addr, err := c.conn.ReadMsgUDP(b, oob)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	ttl := 0
+	if msgs, err := syscall.ParseSocketControlMessage(oob[:oobn]); err == nil {
+		for _, m := range msgs {
+			if m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_TTL && len(m.Data) >= 4 {
+				ttl = int(binary.NativeEndian.Uint32(m.Data[:4]))
+			}
+		}
+	}
+	return b[:n], addr.IP, ttl, nil
+}
+
+func (c *dgramICMPConn) setReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *dgramICMPConn) close() error {
+	return c.conn.Close()
+}
+
+func (c *dgramICMPConn) filtersByID() bool {
+	return false
+}
+
+func (c *dgramICMPConn) mode() string {
+	return "unprivileged"
+}
+
+// readFrom returns the ICMP payload of a raw read. ReadMsgIP keeps the IPv4
+// header on Linux, which is where the TTL comes from.
+func (c *rawICMPConn) readFrom(b []byte) ([]byte, net.IP, int, error) {
+	n, _, _, addr, err := c.conn.ReadMsgIP(b, nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if n < 20 || b[0]>>4 != 4 {
+		return nil, nil, 0, fmt.Errorf("short IPv4 packet")
+	}
+
+	headerLen := int(b[0]&0x0f) * 4
+	if headerLen < 20 || headerLen > n {
+		return nil, nil, 0, fmt.Errorf("invalid IPv4 header length")
+	}
+	return b[headerLen:n], addr.IP, int(b[8]), nil
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package scanner
+
+import (
+	"net"
+)
+
+// listenICMP opens a raw ICMP socket (requires Administrator or root)
+func listenICMP() (icmpConn, error) {
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return nil, err
+	}
+	return &rawICMPConn{conn: conn.(*net.IPConn)}, nil
+}
+
+// readFrom returns the ICMP payload of a raw read. The IPv4 header is
+// stripped by the runtime on these platforms, so the TTL is not available.
+func (c *rawICMPConn) readFrom(b []byte) ([]byte, net.IP, int, error) {
+	n, addr, err := c.conn.ReadFrom(b)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return b[:n], addr.(*net.IPAddr).IP, 0, nil
+}
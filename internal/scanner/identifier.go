@@ -1,76 +1,110 @@
 package scanner
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"net/http"
+	"net"
 	"network-scanner-go/internal/database"
 	"network-scanner-go/internal/vendor"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// IdentifyDevice enriches device information
-func IdentifyDevice(device *database.Device) {
-	// Get vendor
-	device.Vendor = vendor.LookupVendor(device.MAC)
+// udpTimeout is how long to wait for a reply to each UDP probe
+const udpTimeout = time.Second
 
-	// Scan common ports
-	device.OpenPorts = ScanCommonPorts(device.IP)
+// maxMetricsBody caps the response read from a candidate metrics endpoint
+const maxMetricsBody = 1 << 20
 
-	// Identify device type based on open ports
-	device.Type = identifyDeviceType(device.OpenPorts)
+// IdentifyDevice enriches device information using the default port profile
+func IdentifyDevice(device *database.Device) {
+	profile, _ := ResolvePortProfile(DefaultPortProfile)
+	IdentifyDeviceWithProfile(device, profile)
+}
 
-	// Check for metrics endpoints
-	device.MetricsURLs = checkMetricsEndpoints(device.IP)
+// IdentifyDeviceWithProfile enriches device information, scanning the ports of a profile
+func IdentifyDeviceWithProfile(device *database.Device, profile *PortProfile) {
+	timing, _ := ResolveTiming(DefaultTiming)
+	IdentifyDeviceContext(context.Background(), device, profile, timing, nil)
 }
 
-// identifyDeviceType identifies device type based on open ports
-func identifyDeviceType(ports []int) string {
-	portSet := make(map[int]bool)
-	for _, p := range ports {
-		portSet[p] = true
-	}
+// IdentifyDeviceContext enriches device information, scanning the ports of a
+// profile with the politeness limits of a timing template and sending a
+// progress event as each stage ends. When ctx is done, the device keeps what
+// was learned until then and the context's error is returned.
+func IdentifyDeviceContext(ctx context.Context, device *database.Device, profile *PortProfile, timing *Timing, events chan<- ProgressEvent) error {
+	release := probes.UseTiming(device.IP, timing)
+	defer release()
 
-	if portSet[9100] {
-		return "Node Exporter"
-	}
-	if portSet[3389] {
-		return "Windows PC"
-	}
-	if portSet[22] && !portSet[80] {
-		return "Linux Server"
-	}
-	if portSet[445] {
-		return "Windows/Samba"
+	// Get vendor
+	device.Vendor = vendor.LookupVendor(device.MAC)
+
+	// Scan ports
+	device.OpenPorts = nil
+	device.Services = nil
+	if ports := profile.PortList(); len(ports) > 0 {
+		var err error
+		device.OpenPorts, err = NewPortScanner(device.IP, profile.Timeout(), timing).ScanChunked(ctx, ports, events)
+		if err != nil {
+			return err
+		}
+
+		device.Services = IdentifyServices(ctx, device.IP, device.OpenPorts, profile.Banners)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		emit(ctx, events, ProgressEvent{Stage: StageServices, Target: device.IP, Done: len(device.OpenPorts), Total: len(device.OpenPorts), Found: len(device.Services)})
 	}
-	if portSet[80] || portSet[443] {
-		return "Web Server"
+
+	// Probe UDP services
+	if ports := profile.UDPPortList(); len(ports) > 0 {
+		udpServices := ScanUDPPorts(ctx, device.IP, ports, udpTimeout)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		device.Services = append(device.Services, udpServices...)
+		emit(ctx, events, ProgressEvent{Stage: StageUDP, Target: device.IP, Done: len(ports), Total: len(ports), Found: len(udpServices)})
 	}
-	if portSet[1883] || portSet[8883] {
-		return "MQTT Broker"
+
+	// Identify device type, OS and model
+	ClassifyDevice(device)
+
+	// Check for metrics endpoints
+	device.MetricsURLs = checkMetricsEndpoints(ctx, device.IP)
+	if err := ctx.Err(); err != nil {
+		return err
 	}
+	emit(ctx, events, ProgressEvent{Stage: StageMetrics, Target: device.IP, Done: 1, Total: 1, Found: len(device.MetricsURLs)})
+	return nil
+}
 
-	return "Unknown"
+// IdentifyDevicePassive enriches a device without sending it any traffic
+func IdentifyDevicePassive(device *database.Device) {
+	device.Vendor = vendor.LookupVendor(device.MAC)
+	ClassifyDevice(device)
 }
 
-// checkMetricsEndpoints checks for Prometheus metrics endpoints
-func checkMetricsEndpoints(ip string) []string {
+// checkMetricsEndpoints checks for Prometheus metrics endpoints, stopping when ctx is done
+func checkMetricsEndpoints(ctx context.Context, ip string) []string {
 	ports := []int{9100, 8080, 80, 3000, 8090, 9090}
 	var metricsURLs []string
 
-	client := &http.Client{Timeout: 1 * time.Second}
+	client := newHTTPClient(time.Second, nil)
 
 	for _, port := range ports {
-		url := fmt.Sprintf("http://%s:%d/metrics", ip, port)
-		resp, err := client.Get(url)
+		url := fmt.Sprintf("http://%s/metrics", net.JoinHostPort(ip, strconv.Itoa(port)))
+		resp, err := httpGet(ctx, client, url)
 		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
 			continue
 		}
-		defer resp.Body.Close()
 
 		if resp.StatusCode == 200 {
-			body, err := io.ReadAll(resp.Body)
+			body, err := io.ReadAll(io.LimitReader(resp.Body, maxMetricsBody))
 			if err == nil {
 				content := string(body)
 				if strings.Contains(content, "# HELP") || strings.Contains(content, "# TYPE") {
@@ -78,6 +112,7 @@ func checkMetricsEndpoints(ip string) []string {
 				}
 			}
 		}
+		resp.Body.Close() // Frees the host's probe for the next port
 	}
 
 	return metricsURLs
@@ -0,0 +1,358 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ICMPv6 message and neighbour discovery option types (RFC 4443, RFC 4861)
+const (
+	icmpv6EchoRequest     = 128
+	icmpv6EchoReply       = 129
+	icmpv6NeighborSolicit = 135
+	icmpv6NeighborAdvert  = 136
+
+	ndOptSourceLinkAddr = 1
+	ndOptTargetLinkAddr = 2
+)
+
+// allNodesMulticast is the link-local all-nodes group every IPv6 host listens on
+var allNodesMulticast = net.ParseIP("ff02::1")
+
+// NDPConfig configures IPv6 neighbour discovery
+type NDPConfig struct {
+	Timeout time.Duration // Time to wait for replies after each round
+}
+
+// DefaultNDPConfig returns sensible neighbour discovery settings for a typical LAN
+func DefaultNDPConfig() NDPConfig {
+	return NDPConfig{Timeout: time.Second}
+}
+
+// NDPDiscoverer finds IPv6 neighbours on a link. A multicast echo to ff02::1
+// finds the hosts, and neighbour solicitations resolve any MAC the kernel
+// did not learn while they answered.
+type NDPDiscoverer struct {
+	config NDPConfig
+}
+
+// NewNDPDiscoverer creates an IPv6 neighbour discoverer
+func NewNDPDiscoverer(config NDPConfig) *NDPDiscoverer {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultNDPConfig().Timeout
+	}
+	return &NDPDiscoverer{config: config}
+}
+
+// Discover returns an IPv6 -> MAC map of the neighbours on iface. The MAC is
+// empty for hosts that answered but could not be resolved. When ctx is done
+// it returns the neighbours found until then along with the context's error.
+func (n *NDPDiscoverer) Discover(ctx context.Context, iface *net.Interface) (map[string]string, error) {
+	localAddrs, err := interfaceIPv6Addrs(iface)
+	if err != nil {
+		return nil, err
+	}
+	if len(localAddrs) == 0 {
+		return nil, fmt.Errorf("interface %s has no IPv6 address", iface.Name)
+	}
+	local := localIPs()
+
+	results := make(map[string]string)
+	var mu sync.Mutex
+	record := func(ip net.IP, mac net.HardwareAddr) {
+		if ip == nil || ip.IsUnspecified() || ip.IsMulticast() || local[ip.String()] {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if len(mac) == 6 && !isZeroMAC(mac) {
+			results[ip.String()] = mac.String()
+		} else if _, ok := results[ip.String()]; !ok {
+			results[ip.String()] = ""
+		}
+	}
+
+	// Hosts answer from an address in the scope of the request's source, so
+	// ping from every local address to learn link-local and global addresses
+	var wg sync.WaitGroup
+	var sweepErr error
+	sent := 0
+	for _, addr := range localAddrs {
+		wg.Add(1)
+		go func(src net.IP) {
+			defer wg.Done()
+			echo := buildICMPv6Echo(uint16(os.Getpid()), 1)
+			err := n.exchange(ctx, iface, src, func(conn *net.IPConn) error {
+				if err := probes.Wait(ctx); err != nil {
+					return err
+				}
+				_, err := conn.WriteTo(echo, &net.IPAddr{IP: allNodesMulticast, Zone: iface.Name})
+				return err
+			}, record)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				sweepErr = err
+			} else {
+				sent++
+			}
+		}(addr.IP)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	if sent == 0 {
+		return nil, fmt.Errorf("multicast echo on %s: %w", iface.Name, sweepErr)
+	}
+
+	// Answering hosts had to resolve us first, which taught the kernel their MACs
+	if neighbors, err := ReadNeighbors(); err == nil {
+		for _, neighbor := range neighbors {
+			if neighbor.IP.To4() == nil && neighbor.Interface == iface.Name {
+				hw, _ := net.ParseMAC(neighbor.MAC)
+				record(neighbor.IP, hw)
+			}
+		}
+	}
+
+	var unresolved []net.IP
+	for ip, mac := range results {
+		if mac == "" {
+			unresolved = append(unresolved, net.ParseIP(ip))
+		}
+	}
+	if len(unresolved) == 0 || len(iface.HardwareAddr) != 6 {
+		return results, nil
+	}
+
+	// Solicit the remaining hosts from the link-local address, as RFC 4861 expects
+	src := localAddrs[0].IP
+	for _, addr := range localAddrs {
+		if addr.IP.IsLinkLocalUnicast() {
+			src = addr.IP
+			break
+		}
+	}
+	// A failure here only leaves the MACs of the remaining hosts unknown
+	n.exchange(ctx, iface, src, func(conn *net.IPConn) error {
+		if err := setNDPHopLimit(conn); err != nil {
+			return err
+		}
+		for _, target := range unresolved {
+			msg := buildNeighborSolicit(target, iface.HardwareAddr)
+			if err := probes.Wait(ctx); err != nil {
+				return err
+			}
+			conn.WriteTo(msg, &net.IPAddr{IP: solicitedNodeAddress(target), Zone: iface.Name})
+		}
+		return nil
+	}, record)
+
+	mu.Lock()
+	defer mu.Unlock()
+	return results, ctx.Err()
+}
+
+// exchange opens an ICMPv6 socket bound to src, runs send and passes every
+// host learned from the messages received until the timeout, or until ctx
+// is done, to record
+func (n *NDPDiscoverer) exchange(ctx context.Context, iface *net.Interface, src net.IP, send func(conn *net.IPConn) error, record func(net.IP, net.HardwareAddr)) error {
+	addr := &net.IPAddr{IP: src}
+	if src.IsLinkLocalUnicast() {
+		addr.Zone = iface.Name
+	}
+
+	pc, err := net.ListenPacket("ip6:ipv6-icmp", addr.String())
+	if err != nil {
+		return err
+	}
+	conn := pc.(*net.IPConn)
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	if err := send(conn); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(n.config.Timeout))
+	for {
+		size, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			// Deadline reached
+			return nil
+		}
+		if size < 8 {
+			continue
+		}
+
+		msg := buf[:size]
+		srcIP := from.(*net.IPAddr).IP
+		switch msg[0] {
+		case icmpv6EchoReply:
+			record(srcIP, nil)
+		case icmpv6NeighborAdvert:
+			if target, mac, ok := parseNDPMessage(msg); ok {
+				record(target, mac)
+			}
+		case icmpv6NeighborSolicit:
+			// Hosts resolving us announce their own MAC; DAD probes come from ::
+			if _, mac, ok := parseNDPMessage(msg); ok && !srcIP.IsUnspecified() {
+				record(srcIP, mac)
+			}
+		}
+	}
+}
+
+// discoverIPv6 returns the IPv6 neighbours on a link. Passive mode only reads
+// the neighbour table and sends nothing.
+func discoverIPv6(ctx context.Context, iface *net.Interface, passive bool) (map[string]string, error) {
+	if !passive {
+		return NewNDPDiscoverer(DefaultNDPConfig()).Discover(ctx, iface)
+	}
+
+	neighbors, err := ReadNeighbors()
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]string)
+	for _, neighbor := range neighbors {
+		if neighbor.IP.To4() == nil && neighbor.Interface == iface.Name {
+			results[neighbor.IP.String()] = neighbor.MAC
+		}
+	}
+	return results, nil
+}
+
+// buildICMPv6Echo returns an echo request. The kernel fills in the ICMPv6
+// checksum, which covers the IPv6 pseudo-header.
+func buildICMPv6Echo(id, seq uint16) []byte {
+	msg := make([]byte, 8)
+	msg[0] = icmpv6EchoRequest
+	msg[4], msg[5] = byte(id>>8), byte(id)
+	msg[6], msg[7] = byte(seq>>8), byte(seq)
+	return msg
+}
+
+// buildNeighborSolicit returns a neighbour solicitation for target carrying
+// our link-layer address, so the answer can be sent without resolving us
+func buildNeighborSolicit(target net.IP, mac net.HardwareAddr) []byte {
+	msg := make([]byte, 32)
+	msg[0] = icmpv6NeighborSolicit
+	copy(msg[8:24], target.To16())
+	msg[24] = ndOptSourceLinkAddr
+	msg[25] = 1 // Option length in units of 8 bytes
+	copy(msg[26:32], mac)
+	return msg
+}
+
+// parseNDPMessage extracts the target address and the link-layer address
+// option of a neighbour solicitation or advertisement
+func parseNDPMessage(msg []byte) (net.IP, net.HardwareAddr, bool) {
+	if len(msg) < 24 {
+		return nil, nil, false
+	}
+	target := net.IP(append([]byte(nil), msg[8:24]...))
+
+	var mac net.HardwareAddr
+	opts := msg[24:]
+	for len(opts) >= 8 {
+		optLen := int(opts[1]) * 8
+		if optLen == 0 || optLen > len(opts) {
+			break
+		}
+		if (opts[0] == ndOptSourceLinkAddr || opts[0] == ndOptTargetLinkAddr) && optLen >= 8 {
+			mac = append(net.HardwareAddr(nil), opts[2:8]...)
+		}
+		opts = opts[optLen:]
+	}
+	return target, mac, true
+}
+
+// solicitedNodeAddress returns the ff02::1:ffXX:XXXX group a host joins for ip
+func solicitedNodeAddress(ip net.IP) net.IP {
+	addr := net.ParseIP("ff02::1:ff00:0")
+	copy(addr[13:], ip.To16()[13:])
+	return addr
+}
+
+// interfaceIPv6Addrs returns the IPv6 addresses assigned to an interface
+func interfaceIPv6Addrs(iface *net.Interface) ([]*net.IPNet, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var networks []*net.IPNet
+	for _, addr := range addrs {
+		ifnet, ok := addr.(*net.IPNet)
+		if !ok || ifnet.IP.To4() != nil || ifnet.IP.To16() == nil {
+			continue
+		}
+		networks = append(networks, ifnet)
+	}
+	return networks, nil
+}
+
+// localIPs returns the set of addresses assigned to this host
+func localIPs() map[string]bool {
+	local := make(map[string]bool)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return local
+	}
+	for _, addr := range addrs {
+		if ifnet, ok := addr.(*net.IPNet); ok {
+			local[ifnet.IP.String()] = true
+		}
+	}
+	return local
+}
+
+// SortAddresses orders addresses IPv4 first, then global IPv6, then
+// link-local IPv6, and drops duplicates and unparsable entries
+func SortAddresses(addrs []string) []string {
+	rank := func(ip net.IP) int {
+		switch {
+		case ip.To4() != nil:
+			return 0
+		case ip.IsLinkLocalUnicast():
+			return 2
+		default:
+			return 1
+		}
+	}
+
+	seen := make(map[string]bool)
+	var ips []net.IP
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil || seen[ip.String()] {
+			continue
+		}
+		seen[ip.String()] = true
+		ips = append(ips, ip)
+	}
+
+	sort.Slice(ips, func(i, j int) bool {
+		ri, rj := rank(ips[i]), rank(ips[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return bytes.Compare(ips[i].To16(), ips[j].To16()) < 0
+	})
+
+	sorted := make([]string, len(ips))
+	for i, ip := range ips {
+		sorted[i] = ip.String()
+	}
+	return sorted
+}
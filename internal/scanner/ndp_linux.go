@@ -0,0 +1,29 @@
+//go:build linux
+
+package scanner
+
+import (
+	"net"
+	"syscall"
+)
+
+// setNDPHopLimit sets the hop limit of outgoing packets to 255. Hosts
+// discard neighbour discovery messages with any other value (RFC 4861).
+func setNDPHopLimit(conn *net.IPConn) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = rc.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, 255)
+		if sockErr == nil {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, 255)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
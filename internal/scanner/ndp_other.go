@@ -0,0 +1,14 @@
+//go:build !linux
+
+package scanner
+
+import (
+	"errors"
+	"net"
+)
+
+// setNDPHopLimit is only implemented on Linux. Without it neighbour
+// solicitations are dropped, so MACs come from the neighbour table only.
+func setNDPHopLimit(conn *net.IPConn) error {
+	return errors.New("neighbour solicitation is not supported on this platform")
+}
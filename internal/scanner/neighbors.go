@@ -0,0 +1,259 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Neighbor is an entry of the kernel neighbour (ARP) table
+type Neighbor struct {
+	IP        net.IP
+	MAC       string
+	Interface string
+	State     string // reachable, stale, delay, probe, permanent
+}
+
+// NeighborDiscoverer builds inventory from the neighbour table only.
+// It sends no packets, so hosts appear once something else has talked to them.
+type NeighborDiscoverer struct{}
+
+// NewNeighborDiscoverer creates a passive discoverer
+func NewNeighborDiscoverer() *NeighborDiscoverer {
+	return &NeighborDiscoverer{}
+}
+
+// Name returns the discoverer name
+func (n *NeighborDiscoverer) Name() string {
+	return "passive"
+}
+
+// Discover returns the neighbour table entries for the requested hosts. It
+// sends nothing, so there is nothing to cancel.
+func (n *NeighborDiscoverer) Discover(ctx context.Context, network *net.IPNet, hosts []net.IP) (map[string]string, error) {
+	neighbors, err := ReadNeighbors()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(hosts))
+	for _, ip := range hosts {
+		wanted[ip.String()] = true
+	}
+
+	results := make(map[string]string)
+	for _, neighbor := range neighbors {
+		if wanted[neighbor.IP.String()] {
+			results[neighbor.IP.String()] = neighbor.MAC
+		}
+	}
+	return results, nil
+}
+
+// PlaceholderMAC returns the key used for hosts whose MAC could not be resolved
+func PlaceholderMAC(ip string) string {
+	return fmt.Sprintf("unknown_%s", ip)
+}
+
+// IsPlaceholderMAC reports whether mac was generated by PlaceholderMAC
+func IsPlaceholderMAC(mac string) bool {
+	return strings.HasPrefix(mac, "unknown_")
+}
+
+// neighborMACs returns the neighbour table as an IP -> MAC map.
+// Local addresses never appear in the table, so they are added from the interfaces.
+func neighborMACs() map[string]string {
+	table := make(map[string]string)
+	if neighbors, err := ReadNeighbors(); err == nil {
+		for _, neighbor := range neighbors {
+			table[neighbor.IP.String()] = neighbor.MAC
+		}
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return table
+	}
+	for _, iface := range ifaces {
+		if len(iface.HardwareAddr) != 6 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ifnet, ok := addr.(*net.IPNet); ok {
+				table[ifnet.IP.String()] = iface.HardwareAddr.String()
+			}
+		}
+	}
+	return table
+}
+
+// ParseProcNetARP parses the Linux /proc/net/arp format.
+// Incomplete entries (flags 0x0 or an all-zero MAC) are skipped.
+func ParseProcNetARP(r io.Reader) ([]Neighbor, error) {
+	var neighbors []Neighbor
+
+	scanner := bufio.NewScanner(r)
+	header := true
+	for scanner.Scan() {
+		if header {
+			header = false
+			continue
+		}
+
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[0])
+		if ip == nil || fields[2] == "0x0" {
+			continue
+		}
+
+		hw, err := net.ParseMAC(fields[3])
+		if err != nil || isZeroMAC(hw) {
+			continue
+		}
+
+		state := "reachable"
+		if fields[2] == "0x6" {
+			state = "permanent"
+		}
+
+		neighbors = append(neighbors, Neighbor{
+			IP:        ip,
+			MAC:       hw.String(),
+			Interface: fields[5],
+			State:     state,
+		})
+	}
+
+	return neighbors, scanner.Err()
+}
+
+// parseARPCommand parses the output of "arp -a" on Windows, macOS and BSD
+func parseARPCommand(output string) []Neighbor {
+	var neighbors []Neighbor
+
+	for _, line := range strings.Split(output, "\n") {
+		var ip net.IP
+		var mac string
+		for _, field := range strings.Fields(line) {
+			field = strings.Trim(field, "()")
+			if parsed := net.ParseIP(field); parsed != nil && parsed.To4() != nil {
+				ip = parsed
+				continue
+			}
+			if hw, err := net.ParseMAC(strings.ReplaceAll(field, "-", ":")); err == nil && len(hw) == 6 {
+				mac = hw.String()
+			}
+		}
+
+		if ip == nil || mac == "" {
+			continue
+		}
+		hw, _ := net.ParseMAC(mac)
+		if isZeroMAC(hw) || hw[0]&0x01 != 0 {
+			// Skip incomplete and broadcast/multicast entries
+			continue
+		}
+
+		neighbors = append(neighbors, Neighbor{IP: ip, MAC: mac, State: "reachable"})
+	}
+
+	return neighbors
+}
+
+// parseNDPCommand parses the IPv6 neighbour listings of "ndp -an" (macOS, BSD)
+// and "netsh interface ipv6 show neighbors" (Windows)
+func parseNDPCommand(output string) []Neighbor {
+	var neighbors []Neighbor
+
+	ifaceName := ""
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// netsh groups entries under "Interface 12: Ethernet"
+		if fields[0] == "Interface" && len(fields) >= 3 && strings.HasSuffix(fields[1], ":") {
+			ifaceName = strings.Join(fields[2:], " ")
+			continue
+		}
+		if len(fields) < 2 {
+			continue
+		}
+
+		addr, zone, _ := strings.Cut(fields[0], "%")
+		ip := net.ParseIP(addr)
+		if ip == nil || ip.To4() != nil || ip.IsMulticast() {
+			continue
+		}
+		hw, ok := parseLooseMAC(fields[1])
+		if !ok || isZeroMAC(hw) || hw[0]&0x01 != 0 {
+			// Skip incomplete and multicast entries
+			continue
+		}
+
+		neighbor := Neighbor{IP: ip, MAC: hw.String(), Interface: ifaceName, State: "reachable"}
+		if zone != "" {
+			neighbor.Interface = zone
+		} else if len(fields) >= 3 && !strings.ContainsAny(fields[2], "()") && ifaceName == "" {
+			neighbor.Interface = fields[2]
+		}
+		if strings.Contains(strings.ToLower(line), "permanent") {
+			neighbor.State = "permanent"
+		}
+		neighbors = append(neighbors, neighbor)
+	}
+
+	return neighbors
+}
+
+// parseLooseMAC parses MACs with ":" or "-" separators and optional leading
+// zeros, as printed by ndp ("0:c:29:aa:bb:cc")
+func parseLooseMAC(s string) (net.HardwareAddr, bool) {
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == ':' || r == '-' })
+	if len(parts) != 6 {
+		return nil, false
+	}
+
+	hw := make(net.HardwareAddr, 6)
+	for i, part := range parts {
+		if len(part) == 0 || len(part) > 2 {
+			return nil, false
+		}
+		var b byte
+		for _, c := range strings.ToLower(part) {
+			switch {
+			case c >= '0' && c <= '9':
+				b = b<<4 | byte(c-'0')
+			case c >= 'a' && c <= 'f':
+				b = b<<4 | byte(c-'a'+10)
+			default:
+				return nil, false
+			}
+		}
+		hw[i] = b
+	}
+	return hw, true
+}
+
+// isZeroMAC reports whether every byte of hw is zero
+func isZeroMAC(hw net.HardwareAddr) bool {
+	for _, b := range hw {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
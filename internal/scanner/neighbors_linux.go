@@ -0,0 +1,144 @@
+//go:build linux
+
+package scanner
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Neighbour table constants from linux/neighbour.h
+const (
+	ndaDst    = 1
+	ndaLLAddr = 2
+
+	nudIncomplete = 0x01
+	nudReachable  = 0x02
+	nudStale      = 0x04
+	nudDelay      = 0x08
+	nudProbe      = 0x10
+	nudFailed     = 0x20
+	nudNoARP      = 0x40
+	nudPermanent  = 0x80
+
+	ndmsgLen = 12
+)
+
+// ReadNeighbors returns the kernel IPv4 and IPv6 neighbour tables. It uses
+// rtnetlink RTM_GETNEIGH dumps and falls back to /proc/net/arp (IPv4 only).
+func ReadNeighbors() ([]Neighbor, error) {
+	neighbors, err := readNetlinkNeighbors(syscall.AF_INET)
+	if err != nil {
+		f, procErr := os.Open("/proc/net/arp")
+		if procErr != nil {
+			return nil, fmt.Errorf("netlink: %v; /proc/net/arp: %w", err, procErr)
+		}
+		defer f.Close()
+		return ParseProcNetARP(f)
+	}
+
+	if neighbors6, err := readNetlinkNeighbors(syscall.AF_INET6); err == nil {
+		neighbors = append(neighbors, neighbors6...)
+	}
+	return neighbors, nil
+}
+
+// readNetlinkNeighbors dumps the neighbour table for an address family
+func readNetlinkNeighbors(family int) ([]Neighbor, error) {
+	tab, err := syscall.NetlinkRIB(syscall.RTM_GETNEIGH, family)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(tab)
+	if err != nil {
+		return nil, err
+	}
+
+	ifaceNames := make(map[int]string)
+	var neighbors []Neighbor
+
+	for _, m := range msgs {
+		if m.Header.Type == syscall.NLMSG_DONE {
+			break
+		}
+		if m.Header.Type != syscall.RTM_NEWNEIGH || len(m.Data) < ndmsgLen {
+			continue
+		}
+
+		// struct ndmsg: family, pad1, pad2, ifindex, state, flags, type
+		ifindex := int(int32(binary.NativeEndian.Uint32(m.Data[4:8])))
+		state := binary.NativeEndian.Uint16(m.Data[8:10])
+		if state&(nudIncomplete|nudFailed|nudNoARP) != 0 {
+			continue
+		}
+
+		var ip net.IP
+		var mac net.HardwareAddr
+		attrs := m.Data[ndmsgLen:]
+		for len(attrs) >= 4 {
+			attrLen := int(binary.NativeEndian.Uint16(attrs[0:2]))
+			attrType := binary.NativeEndian.Uint16(attrs[2:4])
+			if attrLen < 4 || attrLen > len(attrs) {
+				break
+			}
+
+			value := attrs[4:attrLen]
+			switch attrType {
+			case ndaDst:
+				ip = append(net.IP(nil), value...)
+			case ndaLLAddr:
+				mac = append(net.HardwareAddr(nil), value...)
+			}
+
+			// Attributes are padded to 4-byte boundaries
+			aligned := (attrLen + 3) &^ 3
+			if aligned > len(attrs) {
+				break
+			}
+			attrs = attrs[aligned:]
+		}
+
+		if ip == nil || len(mac) != 6 || isZeroMAC(mac) {
+			continue
+		}
+
+		name, ok := ifaceNames[ifindex]
+		if !ok {
+			if iface, err := net.InterfaceByIndex(ifindex); err == nil {
+				name = iface.Name
+			}
+			ifaceNames[ifindex] = name
+		}
+
+		neighbors = append(neighbors, Neighbor{
+			IP:        ip,
+			MAC:       mac.String(),
+			Interface: name,
+			State:     neighborState(state),
+		})
+	}
+
+	return neighbors, nil
+}
+
+// neighborState converts a NUD state bitmask to a readable name
+func neighborState(state uint16) string {
+	switch {
+	case state&nudPermanent != 0:
+		return "permanent"
+	case state&nudReachable != 0:
+		return "reachable"
+	case state&nudDelay != 0:
+		return "delay"
+	case state&nudProbe != 0:
+		return "probe"
+	case state&nudStale != 0:
+		return "stale"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+
+package scanner
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+// ReadNeighbors returns the system ARP table as reported by "arp -a",
+// followed by the IPv6 neighbour table where it can be read
+func ReadNeighbors() ([]Neighbor, error) {
+	output, err := exec.Command("arp", "-a").Output()
+	if err != nil {
+		return nil, err
+	}
+	neighbors := parseARPCommand(string(output))
+
+	if output, err := ipv6NeighborCommand().Output(); err == nil {
+		neighbors = append(neighbors, parseNDPCommand(string(output))...)
+	}
+	return neighbors, nil
+}
+
+// ipv6NeighborCommand returns the command that lists IPv6 neighbours
+func ipv6NeighborCommand() *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("netsh", "interface", "ipv6", "show", "neighbors")
+	}
+	return exec.Command("ndp", "-an")
+}
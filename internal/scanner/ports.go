@@ -1,12 +1,23 @@
 package scanner
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"net"
+	"network-scanner-go/internal/telemetry"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+var (
+	portsProbed = telemetry.NewCounter("scanner_ports_probed_total", "Ports probed on devices.", "protocol")
+	portsOpen   = telemetry.NewCounter("scanner_ports_open_total", "Probed ports that were found open.", "protocol")
+)
+
 // CommonPorts is the list of commonly scanned ports
 var CommonPorts = []int{
 	21,    // FTP
@@ -40,26 +51,85 @@ var CommonPorts = []int{
 	9090,  // Prometheus
 }
 
-// ScanPorts scans the specified ports on the given IP
+// ScanPorts scans the specified ports on the given IP with a fixed connect timeout
 func ScanPorts(ip string, ports []int, timeout time.Duration) []int {
+	openPorts, _ := ScanPortsContext(context.Background(), ip, ports, timeout)
+	return openPorts
+}
+
+// ScanPortsContext is ScanPorts stopping when ctx is done. It returns the
+// open ports found until then along with the context's error.
+func ScanPortsContext(ctx context.Context, ip string, ports []int, timeout time.Duration) ([]int, error) {
+	scanner := &PortScanner{ip: ip, timeout: timeout}
+	return scanner.Scan(ctx, ports)
+}
+
+// ScanCommonPorts scans common ports on the given IP
+func ScanCommonPorts(ip string) []int {
+	return ScanPorts(ip, CommonPorts, 300*time.Millisecond)
+}
+
+// ScanAllPorts scans all ports (1-65535) on the given IP
+func ScanAllPorts(ip string) []int {
+	openPorts, _ := ScanAllPortsContext(context.Background(), ip, nil)
+	return openPorts
+}
+
+// ScanAllPortsContext scans all ports (1-65535) on the given IP, sending a
+// progress event after every chunk of ports. When ctx is done it returns the
+// open ports found until then along with the context's error.
+func ScanAllPortsContext(ctx context.Context, ip string, events chan<- ProgressEvent) ([]int, error) {
+	allPorts := make([]int, 65535)
+	for i := range allPorts {
+		allPorts[i] = i + 1
+	}
+	return NewPortScanner(ip, 100*time.Millisecond, nil).ScanChunked(ctx, allPorts, events)
+}
+
+// PortScanner scans the TCP ports of one host through the shared scheduler
+type PortScanner struct {
+	ip      string
+	timeout time.Duration // Fixed connect timeout, used when rtt is nil
+	rtt     *rttEstimator
+}
+
+// NewPortScanner creates a scanner whose connect timeout starts at timeout and
+// adapts to the round-trip times the host shows, within the bounds of the
+// timing template (nil for the default template)
+func NewPortScanner(ip string, timeout time.Duration, timing *Timing) *PortScanner {
+	if timing == nil {
+		timing, _ = ResolveTiming(DefaultTiming)
+	}
+	return &PortScanner{ip: ip, timeout: timeout, rtt: newRTTEstimator(timeout, timing)}
+}
+
+// Timeout returns the current connect timeout
+func (s *PortScanner) Timeout() time.Duration {
+	if s.rtt == nil {
+		return s.timeout
+	}
+	return s.rtt.Timeout()
+}
+
+// Scan returns the open ports among ports. How many are probed at once is
+// left to the host's timing template. When ctx is done, the ports found so
+// far are returned with the context's error.
+func (s *PortScanner) Scan(ctx context.Context, ports []int) ([]int, error) {
 	var openPorts []int
+	var probed int64
 	var mu sync.Mutex
 	var wg sync.WaitGroup
 
-	// Use a semaphore to limit concurrent connections
-	sem := make(chan struct{}, 100)
-
 	for _, port := range ports {
 		wg.Add(1)
 		go func(p int) {
 			defer wg.Done()
-			sem <- struct{}{}        // Acquire
-			defer func() { <-sem }() // Release
-
-			address := fmt.Sprintf("%s:%d", ip, p)
-			conn, err := net.DialTimeout("tcp", address, timeout)
-			if err == nil {
-				conn.Close()
+			open, err := s.probe(ctx, p)
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&probed, 1)
+			if open {
 				mu.Lock()
 				openPorts = append(openPorts, p)
 				mu.Unlock()
@@ -68,42 +138,63 @@ func ScanPorts(ip string, ports []int, timeout time.Duration) []int {
 	}
 
 	wg.Wait()
-	return openPorts
-}
-
-// ScanCommonPorts scans common ports on the given IP
-func ScanCommonPorts(ip string) []int {
-	return ScanPorts(ip, CommonPorts, 300*time.Millisecond)
+	portsProbed.Add(float64(probed), "tcp")
+	portsOpen.Add(float64(len(openPorts)), "tcp")
+	sort.Ints(openPorts)
+	return openPorts, ctx.Err()
 }
 
-// ScanAllPorts scans all ports (1-65535) on the given IP
-func ScanAllPorts(ip string, progressCallback func(current, total int, openPorts []int)) []int {
-	allPorts := make([]int, 65535)
-	for i := range allPorts {
-		allPorts[i] = i + 1
-	}
-
+// ScanChunked scans ports in chunks of 1000, sending a progress event after
+// each chunk. When ctx is done, the ports found so far are returned with the
+// context's error.
+func (s *PortScanner) ScanChunked(ctx context.Context, ports []int, events chan<- ProgressEvent) ([]int, error) {
 	var openPorts []int
-	var mu sync.Mutex
 
 	chunkSize := 1000
-	for i := 0; i < len(allPorts); i += chunkSize {
+	for i := 0; i < len(ports); i += chunkSize {
 		end := i + chunkSize
-		if end > len(allPorts) {
-			end = len(allPorts)
+		if end > len(ports) {
+			end = len(ports)
 		}
 
-		chunk := allPorts[i:end]
-		chunkOpen := ScanPorts(ip, chunk, 100*time.Millisecond)
-		
-		mu.Lock()
+		chunkOpen, err := s.Scan(ctx, ports[i:end])
 		openPorts = append(openPorts, chunkOpen...)
-		mu.Unlock()
-
-		if progressCallback != nil {
-			progressCallback(end, len(allPorts), openPorts)
+		if err != nil {
+			return openPorts, err
 		}
+
+		emit(ctx, events, ProgressEvent{
+			Stage:     StagePorts,
+			Target:    s.ip,
+			Done:      end,
+			Total:     len(ports),
+			Found:     len(openPorts),
+			OpenPorts: openPorts,
+		})
 	}
 
-	return openPorts
+	return openPorts, nil
+}
+
+// probe connects to a port and reports whether it is open. Both accepted and
+// refused connections measure the host's round-trip time. An error means the
+// port was not probed because ctx is done.
+func (s *PortScanner) probe(ctx context.Context, port int) (bool, error) {
+	release, err := probes.Acquire(ctx, s.ip)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	start := time.Now()
+	dialer := net.Dialer{Timeout: s.Timeout()}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.ip, strconv.Itoa(port)))
+	if s.rtt != nil && (err == nil || errors.Is(err, syscall.ECONNREFUSED)) {
+		s.rtt.Observe(time.Since(start))
+	}
+	if err != nil {
+		return false, ctx.Err()
+	}
+	conn.Close()
+	return true, nil
 }
@@ -0,0 +1,216 @@
+package scanner
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed data/top-1000-tcp.txt
+var top1000Spec string
+
+// DefaultPortProfile is used when a scan target does not name a profile
+const DefaultPortProfile = "common"
+
+// PortProfile is a named set of TCP and UDP ports scanned during enrichment
+type PortProfile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Ports       string `json:"ports"`               // Port spec, e.g. "22,80,8000-8100"
+	UDPPorts    string `json:"udp_ports,omitempty"` // UDP port spec, e.g. "53,123,161"
+	Banners     bool   `json:"banners"`             // Grab service banners from open ports
+	TimeoutMs   int    `json:"timeout_ms"`          // Connect timeout per port (default: 300)
+
+	ports    []int
+	udpPorts []int
+}
+
+// PortList returns the ports of the profile in ascending order
+func (p *PortProfile) PortList() []int {
+	return p.ports
+}
+
+// UDPPortList returns the UDP ports of the profile in ascending order
+func (p *PortProfile) UDPPortList() []int {
+	return p.udpPorts
+}
+
+// Timeout returns the connect timeout per port
+func (p *PortProfile) Timeout() time.Duration {
+	if p.TimeoutMs <= 0 {
+		return 300 * time.Millisecond
+	}
+	return time.Duration(p.TimeoutMs) * time.Millisecond
+}
+
+var (
+	portProfiles   map[string]*PortProfile
+	portProfilesMu sync.RWMutex
+)
+
+func init() {
+	portProfiles = make(map[string]*PortProfile)
+	builtins := []PortProfile{
+		{Name: "quick", Description: "Most common services", Ports: "21-23,25,53,80,443,445,3389,8080"},
+		{Name: "common", Description: "Common services and IoT/dev ports", Ports: joinPorts(CommonPorts), UDPPorts: joinPorts(DefaultUDPPorts), Banners: true},
+		{Name: "top-1000", Description: "The 1000 most frequently open TCP ports", Ports: top1000Ports(), UDPPorts: joinPorts(DefaultUDPPorts), Banners: true},
+		{Name: "full", Description: "All TCP ports (1-65535)", Ports: "1-65535", UDPPorts: joinPorts(DefaultUDPPorts), Banners: true, TimeoutMs: 100},
+		{Name: "none", Description: "No port scan"},
+	}
+	for i := range builtins {
+		if err := addPortProfile(&builtins[i]); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// LoadPortProfiles loads custom port profiles from a JSON file. Profiles with
+// the name of a built-in profile replace it.
+func LoadPortProfiles(configPath string) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	var profiles []PortProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+
+	for i := range profiles {
+		if profiles[i].Name == "" {
+			return fmt.Errorf("port profile %d has no name", i+1)
+		}
+		if err := addPortProfile(&profiles[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDefaultPortProfilesPath returns the likely path for custom port profiles
+func GetDefaultPortProfilesPath() string {
+	return filepath.Join("configs", "port_profiles.json")
+}
+
+// addPortProfile parses a profile's port specs and registers it
+func addPortProfile(profile *PortProfile) error {
+	ports, err := ParsePortSpec(profile.Ports)
+	if err != nil {
+		return fmt.Errorf("port profile %q: %w", profile.Name, err)
+	}
+	profile.ports = ports
+
+	udpPorts, err := ParsePortSpec(profile.UDPPorts)
+	if err != nil {
+		return fmt.Errorf("port profile %q: udp: %w", profile.Name, err)
+	}
+	profile.udpPorts = udpPorts
+
+	portProfilesMu.Lock()
+	portProfiles[profile.Name] = profile
+	portProfilesMu.Unlock()
+	return nil
+}
+
+// ResolvePortProfile returns a named profile. A port spec such as
+// "22,80,8000-8100" is accepted as an ad-hoc custom profile.
+func ResolvePortProfile(name string) (*PortProfile, error) {
+	if name == "" {
+		name = DefaultPortProfile
+	}
+
+	portProfilesMu.RLock()
+	profile, ok := portProfiles[name]
+	portProfilesMu.RUnlock()
+	if ok {
+		return profile, nil
+	}
+
+	if ports, err := ParsePortSpec(name); err == nil && len(ports) > 0 {
+		return &PortProfile{Name: "custom", Ports: name, Banners: true, ports: ports}, nil
+	}
+	return nil, fmt.Errorf("unknown port profile %q", name)
+}
+
+// GetPortProfiles returns all registered profiles sorted by name
+func GetPortProfiles() []*PortProfile {
+	portProfilesMu.RLock()
+	defer portProfilesMu.RUnlock()
+
+	profiles := make([]*PortProfile, 0, len(portProfiles))
+	for _, profile := range portProfiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+	return profiles
+}
+
+// ParsePortSpec parses a comma separated list of ports and ranges ("22,80,8000-8100")
+func ParsePortSpec(spec string) ([]int, error) {
+	seen := make(map[int]bool)
+	var ports []int
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		first, last := part, part
+		if idx := strings.Index(part, "-"); idx >= 0 {
+			first, last = part[:idx], part[idx+1:]
+		}
+		start, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		end, err := strconv.Atoi(strings.TrimSpace(last))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		if start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+
+		for p := start; p <= end; p++ {
+			if !seen[p] {
+				seen[p] = true
+				ports = append(ports, p)
+			}
+		}
+	}
+
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// joinPorts formats ports as a port spec
+func joinPorts(ports []int) string {
+	parts := make([]string, len(ports))
+	for i, p := range ports {
+		parts[i] = strconv.Itoa(p)
+	}
+	return strings.Join(parts, ",")
+}
+
+// top1000Ports returns the embedded top-1000 port spec without its comment lines
+func top1000Ports() string {
+	var lines []string
+	for _, line := range strings.Split(top1000Spec, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, ",")
+}
@@ -0,0 +1,38 @@
+package scanner
+
+import "context"
+
+// Stages reported in progress events
+const (
+	StageDiscovery = "discovery" // Sweeping ranges for live hosts
+	StagePorts     = "ports"     // Scanning TCP ports
+	StageServices  = "services"  // Identifying the services on open ports
+	StageUDP       = "udp"       // Probing UDP services
+	StageMetrics   = "metrics"   // Looking for Prometheus endpoints
+)
+
+// ProgressEvent reports how far a scan has got. Done and Total count ranges
+// during discovery and ports or services during enrichment.
+type ProgressEvent struct {
+	Stage     string `json:"stage"`
+	Target    string `json:"target"` // Address or range the event is about
+	Done      int    `json:"done"`
+	Total     int    `json:"total"`
+	Found     int    `json:"found"`                // Hosts, open ports or services found so far
+	OpenPorts []int  `json:"open_ports,omitempty"` // Open TCP ports found so far
+}
+
+// emit sends an event to a listener, if there is one. A slow listener holds
+// the scan back rather than missing events, until ctx is done.
+func emit(ctx context.Context, events chan<- ProgressEvent, event ProgressEvent) {
+	if events == nil {
+		return
+	}
+	if event.OpenPorts != nil {
+		event.OpenPorts = append([]int(nil), event.OpenPorts...)
+	}
+	select {
+	case events <- event:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,392 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTiming is used when a scan target does not name a timing template
+const DefaultTiming = "normal"
+
+// Timing is a named set of limits on how hard a single host is probed
+type Timing struct {
+	Name            string
+	HostDelay       time.Duration // Minimum delay between probes of one host
+	HostParallelism int           // Probes of one host in flight at once
+	ParallelHosts   int           // Hosts of a scan target enriched at once
+	MinTimeout      time.Duration // Bounds of the connect timeout adapted from RTT
+	MaxTimeout      time.Duration
+}
+
+// timings are the templates, from slowest to fastest
+var timings = []*Timing{
+	{Name: "paranoid", HostDelay: 5 * time.Second, HostParallelism: 1, ParallelHosts: 1, MinTimeout: time.Second, MaxTimeout: 10 * time.Second},
+	{Name: "sneaky", HostDelay: time.Second, HostParallelism: 1, ParallelHosts: 2, MinTimeout: 500 * time.Millisecond, MaxTimeout: 5 * time.Second},
+	{Name: "polite", HostDelay: 400 * time.Millisecond, HostParallelism: 4, ParallelHosts: 4, MinTimeout: 250 * time.Millisecond, MaxTimeout: 3 * time.Second},
+	{Name: "normal", HostParallelism: 100, ParallelHosts: 16, MinTimeout: 100 * time.Millisecond, MaxTimeout: 1500 * time.Millisecond},
+	{Name: "aggressive", HostParallelism: 200, ParallelHosts: 64, MinTimeout: 50 * time.Millisecond, MaxTimeout: 500 * time.Millisecond},
+}
+
+// ResolveTiming returns a timing template by name; an empty name selects the default
+func ResolveTiming(name string) (*Timing, error) {
+	if name == "" {
+		name = DefaultTiming
+	}
+	for _, timing := range timings {
+		if timing.Name == name {
+			return timing, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown timing template %q (expected one of %s)", name, strings.Join(timingNames(), ", "))
+}
+
+// GetTimings returns the timing templates from slowest to fastest
+func GetTimings() []*Timing {
+	return timings
+}
+
+// Scheduler shares a probing budget between all scans: a global rate of
+// packets per second, a cap on open sockets and per-host politeness set by
+// the timing template of the scan probing the host
+type Scheduler struct {
+	mu      sync.Mutex
+	rate    float64       // Packets per second, 0 for no limit
+	sockets chan struct{} // nil for no limit
+	cond    *sync.Cond    // Signalled when a host probe finishes
+	tokens  float64
+	last    time.Time
+	hosts   map[string]*hostState
+}
+
+// hostState tracks the probes of one host
+type hostState struct {
+	timings []*Timing // Templates of the scans probing the host; the politest applies
+	active  int       // Probes in flight
+	next    time.Time // Earliest start of the next probe
+}
+
+// NewScheduler creates a scheduler. A limit of zero disables it.
+func NewScheduler(packetsPerSecond, maxSockets int) *Scheduler {
+	s := &Scheduler{
+		last:  time.Now(),
+		hosts: make(map[string]*hostState),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.SetLimits(packetsPerSecond, maxSockets)
+	return s
+}
+
+// SetLimits changes the packets per second and open sockets of the
+// scheduler. It is safe to call while probes are running; sockets already
+// open are given back to the limit they were taken from.
+func (s *Scheduler) SetLimits(packetsPerSecond, maxSockets int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rate = float64(packetsPerSecond)
+	s.tokens = s.burst()
+	s.sockets = nil
+	if maxSockets > 0 {
+		s.sockets = make(chan struct{}, maxSockets)
+	}
+}
+
+// probes is the scheduler every probe of the scanner goes through
+var probes = NewScheduler(0, 0)
+
+// SetProbeBudget limits the packets per second and open sockets of all
+// probes, including those of scans already running
+func SetProbeBudget(packetsPerSecond, maxSockets int) {
+	probes.SetLimits(packetsPerSecond, maxSockets)
+}
+
+// DialProbe connects to an address through the scheduler shared by all
+// scans, so probes made outside this package count against the same budget
+func DialProbe(ctx context.Context, network, address string, timeout time.Duration) (net.Conn, error) {
+	return probes.Dial(ctx, network, address, timeout)
+}
+
+// ProbeDialContext is DialProbe without a timeout of its own, for use as an
+// http.Transport dialer
+func ProbeDialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return probes.DialContext(ctx, network, address)
+}
+
+// burst is how many packets may be sent at once after an idle period.
+// Callers hold s.mu.
+func (s *Scheduler) burst() float64 {
+	if b := s.rate / 10; b > 1 {
+		return b
+	}
+	return 1
+}
+
+// Wait blocks until the rate limit allows one more packet or ctx is done
+func (s *Scheduler) Wait(ctx context.Context) error {
+	s.mu.Lock()
+	if s.rate <= 0 {
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+	now := time.Now()
+	s.tokens += now.Sub(s.last).Seconds() * s.rate
+	if b := s.burst(); s.tokens > b {
+		s.tokens = b
+	}
+	s.last = now
+	s.tokens-- // Reserve the packet; a negative balance is paid for by waiting
+	wait := time.Duration(-s.tokens / s.rate * float64(time.Second))
+	s.mu.Unlock()
+
+	return sleepContext(ctx, wait)
+}
+
+// UseTiming applies a timing template to the probes of a host until the
+// returned function is called. When scans with different templates probe the
+// same host, the politest one applies.
+func (s *Scheduler) UseTiming(ip string, timing *Timing) (release func()) {
+	s.mu.Lock()
+	h := s.host(ip)
+	h.timings = append(h.timings, timing)
+	s.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			for i, t := range h.timings {
+				if t == timing {
+					h.timings = append(h.timings[:i], h.timings[i+1:]...)
+					break
+				}
+			}
+			s.forget(ip, h)
+			s.cond.Broadcast() // The host may now allow more probes
+			s.mu.Unlock()
+		})
+	}
+}
+
+// Acquire waits for the host's politeness limits, a free socket and the rate
+// limit, and returns the function that gives the socket back. It fails when
+// ctx is done first.
+func (s *Scheduler) Acquire(ctx context.Context, ip string) (release func(), err error) {
+	// Wake the waiters below when ctx is done
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer stop()
+
+	s.mu.Lock()
+	h := s.host(ip)
+	timing := h.timing()
+	for h.active >= timing.HostParallelism {
+		if err := ctx.Err(); err != nil {
+			s.forget(ip, h)
+			s.mu.Unlock()
+			return nil, err
+		}
+		s.cond.Wait()
+		timing = h.timing()
+	}
+	h.active++
+	start := time.Now()
+	if h.next.After(start) {
+		start = h.next
+	}
+	h.next = start.Add(timing.HostDelay)
+	sockets := s.sockets // The socket goes back to this limit even if it changes
+	s.mu.Unlock()
+
+	releaseHost := func() {
+		s.mu.Lock()
+		h.active--
+		s.forget(ip, h)
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+
+	if err := sleepContext(ctx, time.Until(start)); err != nil {
+		releaseHost()
+		return nil, err
+	}
+	if sockets != nil {
+		select {
+		case sockets <- struct{}{}:
+		case <-ctx.Done():
+			releaseHost()
+			return nil, ctx.Err()
+		}
+	}
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			if sockets != nil {
+				<-sockets
+			}
+			releaseHost()
+		})
+	}
+	if err := s.Wait(ctx); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// host returns the state of a host, creating it if needed. Callers hold s.mu.
+func (s *Scheduler) host(ip string) *hostState {
+	h, ok := s.hosts[ip]
+	if !ok {
+		h = &hostState{}
+		s.hosts[ip] = h
+	}
+	return h
+}
+
+// forget drops the state of a host nobody is probing, once its politeness
+// delay has passed. Callers hold s.mu.
+func (s *Scheduler) forget(ip string, h *hostState) {
+	if h.active == 0 && len(h.timings) == 0 && !h.next.After(time.Now()) {
+		delete(s.hosts, ip)
+	}
+}
+
+// timing returns the politest template applied to the host. Callers hold s.mu.
+func (h *hostState) timing() *Timing {
+	for _, timing := range timings {
+		for _, t := range h.timings {
+			if t == timing {
+				return timing
+			}
+		}
+	}
+	timing, _ := ResolveTiming(DefaultTiming)
+	return timing
+}
+
+// Dial connects to an address through the scheduler. The timeout starts
+// once the scheduler lets the probe go, and the socket counts against the
+// budget until the connection is closed.
+func (s *Scheduler) Dial(ctx context.Context, network, address string, timeout time.Duration) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := s.Acquire(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &budgetedConn{Conn: conn, release: release}, nil
+}
+
+// DialContext connects without a timeout of its own, for use as an
+// http.Transport dialer
+func (s *Scheduler) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return s.Dial(ctx, network, address, 0)
+}
+
+// budgetedConn gives its socket back to the scheduler when closed
+type budgetedConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *budgetedConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
+
+// closeOnDone closes conn when ctx is done, so blocked reads and writes
+// return. Call the returned function once the connection is no longer used.
+func closeOnDone(ctx context.Context, conn net.Conn) (stop func() bool) {
+	return context.AfterFunc(ctx, func() { conn.Close() })
+}
+
+// sleepContext pauses for d, returning early with an error when ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rttEstimator adapts a connect timeout to the round-trip times observed on a
+// host, the way TCP computes its retransmission timeout
+type rttEstimator struct {
+	mu       sync.Mutex
+	srtt     time.Duration
+	rttvar   time.Duration
+	min, max time.Duration
+	timeout  time.Duration
+}
+
+// newRTTEstimator starts at the initial timeout, which is then kept within
+// the bounds of the timing template
+func newRTTEstimator(initial time.Duration, timing *Timing) *rttEstimator {
+	return &rttEstimator{min: timing.MinTimeout, max: timing.MaxTimeout, timeout: initial}
+}
+
+// Observe records the time a host took to accept or refuse a connection
+func (e *rttEstimator) Observe(rtt time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.srtt == 0 {
+		e.srtt = rtt
+		e.rttvar = rtt / 2
+	} else {
+		diff := e.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		e.rttvar = (3*e.rttvar + diff) / 4
+		e.srtt = (7*e.srtt + rtt) / 8
+	}
+
+	timeout := e.srtt + 4*e.rttvar
+	if timeout < e.min {
+		timeout = e.min
+	}
+	if timeout > e.max {
+		timeout = e.max
+	}
+	e.timeout = timeout
+}
+
+// Timeout returns the current connect timeout
+func (e *rttEstimator) Timeout() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.timeout
+}
+
+// timingNames lists the template names from slowest to fastest
+func timingNames() []string {
+	names := make([]string, len(timings))
+	for i, timing := range timings {
+		names[i] = timing.Name
+	}
+	return names
+}
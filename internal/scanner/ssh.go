@@ -0,0 +1,327 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"network-scanner-go/internal/database"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SSH message numbers (RFC 4253, RFC 5656)
+const (
+	sshMsgDisconnect = 1
+	sshMsgIgnore     = 2
+	sshMsgDebug      = 4
+	sshMsgKexInit    = 20
+	sshMsgECDHInit   = 30
+	sshMsgECDHReply  = 31
+)
+
+// maxSSHPacket caps the size of a packet read during key exchange
+const maxSSHPacket = 64 * 1024
+
+// sshClientVersion is the identification string sent to servers
+const sshClientVersion = "SSH-2.0-network-scanner"
+
+// sshKexAlgorithms are the key exchanges we can run, in order of preference.
+// Only the server's KEX_ECDH_REPLY is needed, so no keys are ever derived.
+var sshKexAlgorithms = []string{"curve25519-sha256", "curve25519-sha256@libssh.org", "ecdh-sha2-nistp256"}
+
+// sshHostKeyTypes maps the host key algorithms we request to the type of key
+// they return. The RSA signature variants all return the same ssh-rsa key.
+var sshHostKeyTypes = map[string]string{
+	"ssh-ed25519":         "ssh-ed25519",
+	"ecdsa-sha2-nistp256": "ecdsa-sha2-nistp256",
+	"ecdsa-sha2-nistp384": "ecdsa-sha2-nistp384",
+	"ecdsa-sha2-nistp521": "ecdsa-sha2-nistp521",
+	"rsa-sha2-512":        "ssh-rsa",
+	"rsa-sha2-256":        "ssh-rsa",
+	"ssh-rsa":             "ssh-rsa",
+	"ssh-dss":             "ssh-dss",
+}
+
+var errSSHProtocol = errors.New("unexpected SSH message")
+
+// sshKexInit holds the algorithm lists of a server's KEXINIT
+type sshKexInit struct {
+	lists [10][]string // kex, host key, ciphers, MACs, compression, languages (each both ways)
+}
+
+// InspectSSH reads the identification string and host key algorithms of an
+// SSH server and collects one host key of each type it offers. Each key
+// takes its own connection, stopping after the server's key exchange reply.
+func InspectSSH(ctx context.Context, ip string, port int, timeout time.Duration) (*database.SSHInfo, error) {
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+
+	version, kexInit, key, err := fetchSSHHostKey(ctx, address, "", timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &database.SSHInfo{Version: version, HostKeyAlgorithms: kexInit.lists[1]}
+	seen := make(map[string]bool)
+	if key != nil {
+		info.HostKeys = append(info.HostKeys, *key)
+		seen[key.Type] = true
+	}
+	for _, algorithm := range kexInit.lists[1] {
+		keyType, ok := sshHostKeyTypes[algorithm]
+		if !ok || seen[keyType] {
+			continue
+		}
+		seen[keyType] = true
+		if _, _, key, err := fetchSSHHostKey(ctx, address, algorithm, timeout); err == nil && key != nil {
+			info.HostKeys = append(info.HostKeys, *key)
+		}
+	}
+	return info, nil
+}
+
+// fetchSSHHostKey runs a key exchange far enough to receive the server's host
+// key. With an empty algorithm the first supported one the server offers is
+// used. The key is nil if no common algorithms exist.
+func fetchSSHHostKey(ctx context.Context, address, hostKeyAlgorithm string, timeout time.Duration) (string, *sshKexInit, *database.SSHHostKey, error) {
+	conn, err := DialProbe(ctx, "tcp", address, timeout)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+	conn.SetDeadline(time.Now().Add(3 * timeout))
+
+	if _, err := conn.Write([]byte(sshClientVersion + "\r\n")); err != nil {
+		return "", nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	version, err := readSSHVersion(reader)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	payload, err := readSSHMessage(reader)
+	if err != nil {
+		return version, nil, nil, err
+	}
+	if payload[0] != sshMsgKexInit {
+		return version, nil, nil, errSSHProtocol
+	}
+	kexInit, err := parseSSHKexInit(payload)
+	if err != nil {
+		return version, nil, nil, err
+	}
+
+	kex := firstCommon(sshKexAlgorithms, kexInit.lists[0])
+	if hostKeyAlgorithm == "" {
+		for _, algorithm := range kexInit.lists[1] {
+			if _, ok := sshHostKeyTypes[algorithm]; ok {
+				hostKeyAlgorithm = algorithm
+				break
+			}
+		}
+	}
+	if kex == "" || hostKeyAlgorithm == "" {
+		return version, kexInit, nil, nil
+	}
+
+	var private *ecdh.PrivateKey
+	if kex == "ecdh-sha2-nistp256" {
+		private, err = ecdh.P256().GenerateKey(rand.Reader)
+	} else {
+		private, err = ecdh.X25519().GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return version, kexInit, nil, err
+	}
+
+	// Echo the server's cipher, MAC and compression lists: they are never
+	// used, but must not make the negotiation fail
+	lists := kexInit.lists
+	lists[0] = []string{kex}
+	lists[1] = []string{hostKeyAlgorithm}
+	if err := writeSSHPacket(conn, buildSSHKexInit(lists)); err != nil {
+		return version, kexInit, nil, err
+	}
+	init := []byte{sshMsgECDHInit}
+	init = appendSSHString(init, private.PublicKey().Bytes())
+	if err := writeSSHPacket(conn, init); err != nil {
+		return version, kexInit, nil, err
+	}
+
+	payload, err = readSSHMessage(reader)
+	if err != nil {
+		return version, kexInit, nil, err
+	}
+	if payload[0] != sshMsgECDHReply {
+		return version, kexInit, nil, errSSHProtocol
+	}
+	blob, _, ok := readSSHString(payload[1:])
+	if !ok {
+		return version, kexInit, nil, errSSHProtocol
+	}
+	key, err := parseSSHHostKey(blob)
+	return version, kexInit, key, err
+}
+
+// readSSHVersion returns the server's identification string, skipping any
+// lines the server sends before it
+func readSSHVersion(reader *bufio.Reader) (string, error) {
+	for i := 0; i < 20; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if line = strings.TrimRight(line, "\r\n"); strings.HasPrefix(line, "SSH-") {
+			return line, nil
+		}
+	}
+	return "", errors.New("no SSH identification string")
+}
+
+// readSSHMessage reads unencrypted packets until one that is not IGNORE or
+// DEBUG arrives and returns its payload
+func readSSHMessage(reader io.Reader) ([]byte, error) {
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return nil, err
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		padding := uint32(header[4])
+		if length < padding+2 || length > maxSSHPacket {
+			return nil, errSSHProtocol
+		}
+		body := make([]byte, length-1)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return nil, err
+		}
+		payload := body[:len(body)-int(padding)]
+
+		switch payload[0] {
+		case sshMsgIgnore, sshMsgDebug:
+			continue
+		case sshMsgDisconnect:
+			return nil, errors.New("SSH server disconnected")
+		}
+		return payload, nil
+	}
+}
+
+// writeSSHPacket sends an unencrypted packet padded to a multiple of 8 bytes
+func writeSSHPacket(w io.Writer, payload []byte) error {
+	padding := 8 - (len(payload)+5)%8
+	if padding < 4 {
+		padding += 8
+	}
+	packet := make([]byte, 5, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(packet, uint32(1+len(payload)+padding))
+	packet[4] = byte(padding)
+	packet = append(packet, payload...)
+	packet = append(packet, make([]byte, padding)...)
+	_, err := w.Write(packet)
+	return err
+}
+
+// parseSSHKexInit reads the algorithm lists of a KEXINIT message
+func parseSSHKexInit(payload []byte) (*sshKexInit, error) {
+	if len(payload) < 17 {
+		return nil, errSSHProtocol
+	}
+	data := payload[17:] // Message number and cookie
+	kexInit := &sshKexInit{}
+	for i := range kexInit.lists {
+		value, rest, ok := readSSHString(data)
+		if !ok {
+			return nil, errSSHProtocol
+		}
+		if len(value) > 0 {
+			kexInit.lists[i] = strings.Split(string(value), ",")
+		}
+		data = rest
+	}
+	return kexInit, nil
+}
+
+// buildSSHKexInit encodes a KEXINIT message with the given algorithm lists
+func buildSSHKexInit(lists [10][]string) []byte {
+	payload := make([]byte, 17, 512)
+	payload[0] = sshMsgKexInit
+	rand.Read(payload[1:17])
+	for _, list := range lists {
+		payload = appendSSHString(payload, []byte(strings.Join(list, ",")))
+	}
+	return append(payload, 0, 0, 0, 0, 0) // first_kex_packet_follows, reserved
+}
+
+// parseSSHHostKey describes a public key blob
+func parseSSHHostKey(blob []byte) (*database.SSHHostKey, error) {
+	keyType, rest, ok := readSSHString(blob)
+	if !ok {
+		return nil, errSSHProtocol
+	}
+
+	sum := sha256.Sum256(blob)
+	key := &database.SSHHostKey{
+		Type:        string(keyType),
+		Fingerprint: "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]),
+	}
+
+	switch key.Type {
+	case "ssh-ed25519":
+		key.Bits = 256
+	case "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521":
+		key.Bits, _ = strconv.Atoi(strings.TrimPrefix(key.Type, "ecdsa-sha2-nistp"))
+	case "ssh-rsa":
+		// e, then the modulus n
+		if _, rest, ok = readSSHString(rest); ok {
+			if n, _, ok := readSSHString(rest); ok {
+				key.Bits = new(big.Int).SetBytes(n).BitLen()
+			}
+		}
+	case "ssh-dss":
+		if p, _, ok := readSSHString(rest); ok {
+			key.Bits = new(big.Int).SetBytes(p).BitLen()
+		}
+	}
+	return key, nil
+}
+
+// readSSHString reads a length-prefixed string
+func readSSHString(data []byte) ([]byte, []byte, bool) {
+	if len(data) < 4 {
+		return nil, nil, false
+	}
+	length := binary.BigEndian.Uint32(data)
+	if uint32(len(data)-4) < length {
+		return nil, nil, false
+	}
+	return data[4 : 4+length], data[4+length:], true
+}
+
+// appendSSHString appends a length-prefixed string
+func appendSSHString(b, value []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(value)))
+	return append(b, value...)
+}
+
+// firstCommon returns the first of our algorithms the server also supports
+func firstCommon(ours, theirs []string) string {
+	for _, algorithm := range ours {
+		for _, other := range theirs {
+			if algorithm == other {
+				return algorithm
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,416 @@
+package scanner
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MaxHostsPerScope limits how many addresses a single range may expand to
+const MaxHostsPerScope = 1 << 16
+
+// ScanTarget describes a set of addresses that are scanned on a common schedule
+type ScanTarget struct {
+	Name        string   `json:"name"`
+	Ranges      []string `json:"ranges"`       // CIDRs, "first-last" ranges, single IPs or interface names (IPv4 and IPv6)
+	Interval    int      `json:"interval"`     // Seconds between scans (0 = use the global default)
+	PortProfile string   `json:"port_profile"` // Port profile used for enrichment
+	Timing      string   `json:"timing"`       // Timing template: paranoid, sneaky, polite, normal or aggressive
+	Exclude     []string `json:"exclude"`      // IPs, CIDRs or ranges that are never probed
+}
+
+// IntervalDuration returns the scan interval, falling back to def when unset
+func (t *ScanTarget) IntervalDuration(def time.Duration) time.Duration {
+	if t.Interval <= 0 {
+		return def
+	}
+	return time.Duration(t.Interval) * time.Second
+}
+
+// Scope is a list of hosts that share one network, so they can be swept together.
+// IPv6 networks are too large to sweep, so IPv6 scopes have no Hosts and are
+// discovered with NDP on their Interface instead.
+type Scope struct {
+	Network   *net.IPNet
+	Hosts     []net.IP
+	Interface string // Link of an IPv6 scope
+
+	exclude *exclusionList
+}
+
+// Contains reports whether ip belongs to the scope and is not excluded
+func (s *Scope) Contains(ip net.IP) bool {
+	if s.exclude.contains(ip) || !s.Network.Contains(ip) {
+		return false
+	}
+	if s.Network.IP.To4() == nil {
+		return true
+	}
+	for _, h := range s.Hosts {
+		if h.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsIPv6 reports whether the scope is discovered with NDP
+func (s *Scope) IsIPv6() bool {
+	return s.Network.IP.To4() == nil
+}
+
+// LoadTargets loads scan targets from a JSON file
+func LoadTargets(configPath string) ([]ScanTarget, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []ScanTarget
+	if err := json.Unmarshal(data, &targets); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+
+	names := make(map[string]bool)
+	for i := range targets {
+		t := &targets[i]
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("target-%d", i+1)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("duplicate scan target name %q", t.Name)
+		}
+		names[t.Name] = true
+
+		if len(t.Ranges) == 0 {
+			return nil, fmt.Errorf("scan target %q has no ranges", t.Name)
+		}
+	}
+
+	return targets, nil
+}
+
+// GetDefaultTargetsPath returns the likely path for the scan target configuration
+func GetDefaultTargetsPath() string {
+	return filepath.Join("configs", "scan_targets.json")
+}
+
+// Resolve expands the target into scopes. Interface names are resolved against
+// the current interface addresses, so it should be called before every scan.
+func (t *ScanTarget) Resolve() ([]Scope, error) {
+	excluded, err := parseExclusions(t.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("target %q: %w", t.Name, err)
+	}
+
+	var scopes []Scope
+	for _, spec := range t.Ranges {
+		specScopes, err := resolveSpec(spec)
+		if err != nil {
+			return nil, fmt.Errorf("target %q: %w", t.Name, err)
+		}
+
+		for _, scope := range specScopes {
+			scope.exclude = excluded
+			if scope.IsIPv6() {
+				scopes = append(scopes, scope)
+				continue
+			}
+
+			hosts := scope.Hosts[:0]
+			for _, ip := range scope.Hosts {
+				if !excluded.contains(ip) {
+					hosts = append(hosts, ip)
+				}
+			}
+			scope.Hosts = hosts
+			if len(scope.Hosts) > 0 {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	return scopes, nil
+}
+
+// resolveSpec expands a single range specification
+func resolveSpec(spec string) ([]Scope, error) {
+	spec = strings.TrimSpace(spec)
+
+	// CIDR
+	if strings.Contains(spec, "/") {
+		_, ipnet, err := net.ParseCIDR(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", spec, err)
+		}
+		if ipnet.IP.To4() == nil {
+			return ipv6Scope(ipnet)
+		}
+		if err := checkScopeSize(ipnet); err != nil {
+			return nil, err
+		}
+		return []Scope{{Network: ipnet, Hosts: hostsInRange(ipnet)}}, nil
+	}
+
+	// Single IPv6 address
+	if ip := net.ParseIP(spec); ip != nil && ip.To4() == nil {
+		return ipv6Scope(&net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+	}
+
+	// Range or single IP
+	if first, last, ok := parseIPRange(spec); ok {
+		hosts, err := expandRange(first, last)
+		if err != nil {
+			return nil, err
+		}
+		return []Scope{{Network: coveringNetwork(first, last), Hosts: hosts}}, nil
+	}
+
+	// Interface name
+	iface, err := net.InterfaceByName(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a CIDR, range or interface name", spec)
+	}
+	networks, err := interfaceNetworks(iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs6, err := interfaceIPv6Addrs(iface)
+	if err != nil {
+		return nil, err
+	}
+	if len(networks) == 0 && len(addrs6) == 0 {
+		return nil, fmt.Errorf("interface %s has no IP address", spec)
+	}
+
+	var scopes []Scope
+	for _, ipnet := range networks {
+		if err := checkScopeSize(ipnet); err != nil {
+			return nil, fmt.Errorf("interface %s: %w", spec, err)
+		}
+		scopes = append(scopes, Scope{Network: ipnet, Hosts: hostsInRange(ipnet)})
+	}
+	if len(addrs6) > 0 {
+		// Every IPv6 neighbour on the link, link-local and global
+		scopes = append(scopes, Scope{
+			Network:   &net.IPNet{IP: net.IPv6unspecified, Mask: net.CIDRMask(0, 128)},
+			Interface: iface.Name,
+		})
+	}
+	return scopes, nil
+}
+
+// ipv6Scope attaches an IPv6 prefix to the local interface it is on
+func ipv6Scope(ipnet *net.IPNet) ([]Scope, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := interfaceIPv6Addrs(iface)
+		if err != nil {
+			continue
+		}
+		for _, ifnet := range addrs {
+			if ifnet.Contains(ipnet.IP) || ipnet.Contains(ifnet.IP) {
+				return []Scope{{Network: ipnet, Interface: iface.Name}}, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%s: %w", ipnet, ErrNotOnLink)
+}
+
+// LocalNetworks returns the IPv4 networks of all active non-loopback interfaces,
+// using each address's real prefix length
+func LocalNetworks() ([]*net.IPNet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var networks []*net.IPNet
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifNetworks, err := interfaceNetworks(iface)
+		if err != nil {
+			continue
+		}
+		networks = append(networks, ifNetworks...)
+	}
+	return networks, nil
+}
+
+// interfaceNetworks returns the IPv4 networks assigned to an interface
+func interfaceNetworks(iface *net.Interface) ([]*net.IPNet, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var networks []*net.IPNet
+	for _, addr := range addrs {
+		ifnet, ok := addr.(*net.IPNet)
+		if !ok || ifnet.IP.To4() == nil {
+			continue
+		}
+		networks = append(networks, &net.IPNet{
+			IP:   ifnet.IP.Mask(ifnet.Mask).To4(),
+			Mask: ifnet.Mask[len(ifnet.Mask)-4:],
+		})
+	}
+	return networks, nil
+}
+
+// checkScopeSize rejects networks that would expand to too many hosts
+func checkScopeSize(ipnet *net.IPNet) error {
+	ones, bits := ipnet.Mask.Size()
+	if bits != 32 {
+		return fmt.Errorf("%s is not an IPv4 network", ipnet)
+	}
+	if uint64(1)<<uint(bits-ones) > MaxHostsPerScope {
+		return fmt.Errorf("%s is larger than a /16", ipnet)
+	}
+	return nil
+}
+
+// parseIPRange parses "a.b.c.d-e.f.g.h", "a.b.c.d-h" or a single address
+func parseIPRange(spec string) (net.IP, net.IP, bool) {
+	if ip := net.ParseIP(spec).To4(); ip != nil {
+		return ip, ip, true
+	}
+
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return nil, nil, false
+	}
+
+	first := net.ParseIP(strings.TrimSpace(parts[0])).To4()
+	if first == nil {
+		return nil, nil, false
+	}
+
+	lastSpec := strings.TrimSpace(parts[1])
+	if !strings.Contains(lastSpec, ".") {
+		// Short form: 192.168.1.10-50
+		idx := strings.LastIndex(parts[0], ".")
+		lastSpec = strings.TrimSpace(parts[0][:idx+1]) + lastSpec
+	}
+	last := net.ParseIP(lastSpec).To4()
+	if last == nil {
+		return nil, nil, false
+	}
+	return first, last, true
+}
+
+// expandRange lists every address from first to last inclusive
+func expandRange(first, last net.IP) ([]net.IP, error) {
+	start := binary.BigEndian.Uint32(first)
+	end := binary.BigEndian.Uint32(last)
+	if end < start {
+		return nil, fmt.Errorf("range %s-%s is reversed", first, last)
+	}
+	if uint64(end-start)+1 > MaxHostsPerScope {
+		return nil, fmt.Errorf("range %s-%s has more than %d addresses", first, last, MaxHostsPerScope)
+	}
+
+	hosts := make([]net.IP, 0, end-start+1)
+	for v := uint64(start); v <= uint64(end); v++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(v))
+		hosts = append(hosts, ip)
+	}
+	return hosts, nil
+}
+
+// coveringNetwork returns the smallest network containing both addresses
+func coveringNetwork(first, last net.IP) *net.IPNet {
+	a := binary.BigEndian.Uint32(first)
+	b := binary.BigEndian.Uint32(last)
+
+	ones := 32
+	for ones > 0 && a>>(32-ones) != b>>(32-ones) {
+		ones--
+	}
+	mask := net.CIDRMask(ones, 32)
+	return &net.IPNet{IP: first.Mask(mask), Mask: mask}
+}
+
+// exclusionList holds the addresses a target must never probe
+type exclusionList struct {
+	v4 [][2]uint32 // Inclusive address intervals
+	v6 []*net.IPNet
+}
+
+// parseExclusions converts exclusion specs into address intervals and IPv6 prefixes
+func parseExclusions(specs []string) (*exclusionList, error) {
+	excluded := &exclusionList{}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if strings.Contains(spec, "/") {
+			_, ipnet, err := net.ParseCIDR(spec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exclusion %q", spec)
+			}
+			if ipnet.IP.To4() == nil {
+				excluded.v6 = append(excluded.v6, ipnet)
+				continue
+			}
+			ones, _ := ipnet.Mask.Size()
+			start := binary.BigEndian.Uint32(ipnet.IP.To4())
+			end := start | uint32(uint64(1)<<uint(32-ones)-1)
+			excluded.v4 = append(excluded.v4, [2]uint32{start, end})
+			continue
+		}
+
+		if ip := net.ParseIP(spec); ip != nil && ip.To4() == nil {
+			excluded.v6 = append(excluded.v6, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+			continue
+		}
+
+		first, last, ok := parseIPRange(spec)
+		if !ok {
+			return nil, fmt.Errorf("invalid exclusion %q", spec)
+		}
+		excluded.v4 = append(excluded.v4, [2]uint32{binary.BigEndian.Uint32(first), binary.BigEndian.Uint32(last)})
+	}
+	return excluded, nil
+}
+
+// contains reports whether ip is excluded
+func (e *exclusionList) contains(ip net.IP) bool {
+	if e == nil {
+		return false
+	}
+
+	ip4 := ip.To4()
+	if ip4 == nil {
+		for _, ipnet := range e.v6 {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	v := binary.BigEndian.Uint32(ip4)
+	for _, interval := range e.v4 {
+		if v >= interval[0] && v <= interval[1] {
+			return true
+		}
+	}
+	return false
+}
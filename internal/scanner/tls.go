@@ -0,0 +1,112 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"network-scanner-go/internal/database"
+	"strconv"
+	"time"
+)
+
+// tlsPorts are the ports that speak TLS from the first byte
+var tlsPorts = map[int]bool{
+	443: true, 465: true, 636: true, 853: true, 993: true, 995: true,
+	5001: true, 8443: true, 8883: true, 9443: true,
+}
+
+// IsTLSPort reports whether a port usually runs a TLS service
+func IsTLSPort(port int) bool {
+	return tlsPorts[port]
+}
+
+// dialTLS performs a handshake without verifying the certificate, since
+// self-signed and expired certificates are what we are looking for
+func dialTLS(ctx context.Context, ip string, port int, timeout time.Duration, minVersion, maxVersion uint16) (*tls.Conn, error) {
+	conn, err := DialProbe(ctx, "tcp", net.JoinHostPort(ip, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         minVersion,
+		MaxVersion:         maxVersion,
+	})
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// InspectTLS performs a TLS handshake and describes the session and the
+// server certificate. When a newer version was negotiated, a second
+// handshake checks whether TLS 1.0 or 1.1 is still accepted.
+func InspectTLS(ctx context.Context, ip string, port int, timeout time.Duration) (*database.TLSInfo, error) {
+	conn, err := dialTLS(ctx, ip, port, timeout, tls.VersionTLS10, 0)
+	if err != nil {
+		return nil, err
+	}
+	state := conn.ConnectionState()
+	conn.Close() // Checking for legacy versions takes another of the host's probes
+
+	return describeTLS(ctx, state, ip, port, timeout), nil
+}
+
+// describeTLS fills a TLSInfo from a completed handshake
+func describeTLS(ctx context.Context, state tls.ConnectionState, ip string, port int, timeout time.Duration) *database.TLSInfo {
+	info := &database.TLSInfo{
+		Version: tls.VersionName(state.Version),
+		Cipher:  tls.CipherSuiteName(state.CipherSuite),
+	}
+
+	if state.Version <= tls.VersionTLS11 {
+		info.LegacyVersions = []string{info.Version}
+	} else if legacy, err := dialTLS(ctx, ip, port, timeout, tls.VersionTLS10, tls.VersionTLS11); err == nil {
+		info.LegacyVersions = []string{tls.VersionName(legacy.ConnectionState().Version)}
+		legacy.Close()
+	}
+
+	if len(state.PeerCertificates) > 0 {
+		describeCertificate(info, state.PeerCertificates[0])
+	}
+	return info
+}
+
+// describeCertificate copies the details of a leaf certificate
+func describeCertificate(info *database.TLSInfo, cert *x509.Certificate) {
+	info.Subject = cert.Subject.String()
+	info.Issuer = cert.Issuer.String()
+	info.SANs = append(info.SANs, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+	info.NotBefore = cert.NotBefore
+	info.NotAfter = cert.NotAfter
+	info.KeyType, info.KeyBits = publicKeyInfo(cert)
+	// Device certificates are often self-signed without the CA flag that
+	// CheckSignatureFrom insists on, so the signature is checked directly
+	info.SelfSigned = bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// publicKeyInfo returns the algorithm and size of a certificate's key
+func publicKeyInfo(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+	return cert.PublicKeyAlgorithm.String(), 0
+}
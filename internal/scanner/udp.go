@@ -0,0 +1,292 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"network-scanner-go/internal/database"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultUDPPorts are the ports with a protocol-specific probe
+var DefaultUDPPorts = []int{53, 123, 161, 1900, 5353, 5683}
+
+// udpProbe holds the payload and reply parser for a UDP service
+type udpProbe struct {
+	service string
+	payload func(ip string) []byte
+	parse   func(reply []byte) string // Returns a short description of the reply
+}
+
+var udpProbes = map[int]udpProbe{
+	53:   {service: "domain", payload: dnsProbe, parse: parseDNSReply},
+	123:  {service: "ntp", payload: ntpProbe, parse: parseNTPReply},
+	161:  {service: "snmp", payload: snmpProbe, parse: parseSNMPReply},
+	1900: {service: "ssdp", payload: ssdpProbe, parse: parseSSDPReply},
+	5353: {service: "mdns", payload: mdnsProbe, parse: parseDNSReply},
+	5683: {service: "coap", payload: coapProbe, parse: parseCoAPReply},
+}
+
+// ScanUDPPorts probes UDP ports on the given IP. A reply marks a port open, an
+// ICMP port unreachable marks it closed, and silence leaves it open|filtered.
+// Each port gets a second probe before it is declared silent.
+func ScanUDPPorts(ctx context.Context, ip string, ports []int, timeout time.Duration) []database.Service {
+	results := make([]database.Service, len(ports))
+	var wg sync.WaitGroup
+
+	for i, port := range ports {
+		wg.Add(1)
+		go func(i, port int) {
+			defer wg.Done()
+			results[i] = probeUDP(ctx, ip, port, timeout)
+		}(i, port)
+	}
+
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Port < results[j].Port
+	})
+
+	portsProbed.Add(float64(len(ports)), "udp")
+	for _, result := range results {
+		if result.State == database.StateOpen {
+			portsOpen.Inc("udp")
+		}
+	}
+	return results
+}
+
+// probeUDP sends the probe for a port up to twice and classifies the answer
+func probeUDP(ctx context.Context, ip string, port int, timeout time.Duration) database.Service {
+	probe, known := udpProbes[port]
+	now := time.Now()
+	result := database.Service{
+		Protocol:  "udp",
+		Port:      port,
+		State:     database.StateOpenFiltered, // Open but silent, or dropped by a firewall
+		Name:      probe.service,
+		FirstSeen: now,
+		LastSeen:  now,
+	}
+
+	// A connected socket reports ICMP port unreachable as a read error
+	conn, err := DialProbe(ctx, "udp", net.JoinHostPort(ip, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return result
+	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	payload := []byte{}
+	if known {
+		payload = probe.payload(ip)
+	}
+
+	buf := make([]byte, 4096)
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := conn.Write(payload); err != nil {
+			if ctx.Err() == nil {
+				result.State = database.StateClosed
+			}
+			return result
+		}
+
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := conn.Read(buf)
+		if err == nil {
+			result.State = database.StateOpen
+			if known {
+				result.Banner = probe.parse(buf[:n])
+			}
+			return result
+		}
+
+		if isPortUnreachable(err) {
+			result.State = database.StateClosed
+			return result
+		}
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			// Host or network unreachable: nothing is known about the port
+			return result
+		}
+	}
+
+	return result
+}
+
+// isPortUnreachable reports whether a read failed because of an ICMP port
+// unreachable, which Windows surfaces as WSAECONNRESET
+func isPortUnreachable(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == syscall.ECONNREFUSED || (runtime.GOOS == "windows" && errno == wsaeConnReset)
+}
+
+// wsaeConnReset is WSAECONNRESET, defined by the syscall package on Windows only
+const wsaeConnReset = syscall.Errno(10054)
+
+// dnsQuery builds a DNS query with a single question
+func dnsQuery(id uint16, name string, qtype, qclass uint16) []byte {
+	msg := make([]byte, 12, 64)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	binary.BigEndian.PutUint16(msg[4:6], 1) // QDCOUNT
+	msg = append(msg, encodeDNSName(name)...)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, qclass)
+	return msg
+}
+
+// encodeDNSName encodes a dotted name as DNS labels
+func encodeDNSName(name string) []byte {
+	var out []byte
+	for _, label := range bytes.Split([]byte(name), []byte(".")) {
+		if len(label) == 0 {
+			continue
+		}
+		out = append(out, byte(len(label)))
+		out = append(out, label...)
+	}
+	return append(out, 0)
+}
+
+// dnsProbe asks for the root name servers, which any resolver or server answers
+// (even if only with REFUSED)
+func dnsProbe(ip string) []byte {
+	msg := dnsQuery(uint16(os.Getpid()), ".", 2, 1) // NS IN
+	msg[2] = 0x01                                   // Recursion desired
+	return msg
+}
+
+// mdnsProbe sends a legacy unicast DNS-SD service enumeration query, which
+// responders answer directly to our source port
+func mdnsProbe(ip string) []byte {
+	return dnsQuery(uint16(os.Getpid()), "_services._dns-sd._udp.local", 12, 1) // PTR IN
+}
+
+// parseDNSReply reports the response code and answer count
+func parseDNSReply(reply []byte) string {
+	if len(reply) < 12 || reply[2]&0x80 == 0 {
+		return ""
+	}
+	rcodes := []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}
+	rcode := int(reply[3] & 0x0f)
+	name := fmt.Sprintf("RCODE%d", rcode)
+	if rcode < len(rcodes) {
+		name = rcodes[rcode]
+	}
+	return fmt.Sprintf("%s, %d answers", name, binary.BigEndian.Uint16(reply[6:8]))
+}
+
+// ntpProbe sends an NTPv4 client request
+func ntpProbe(ip string) []byte {
+	msg := make([]byte, 48)
+	msg[0] = 0x23 // LI 0, version 4, mode 3 (client)
+	return msg
+}
+
+// parseNTPReply reports the server version and stratum
+func parseNTPReply(reply []byte) string {
+	if len(reply) < 48 {
+		return ""
+	}
+	version := (reply[0] >> 3) & 0x07
+	return fmt.Sprintf("NTPv%d stratum %d", version, reply[1])
+}
+
+// snmpProbe sends an SNMPv2c GetRequest for sysDescr.0 with community "public".
+// Agents with another community stay silent, so they show up as open|filtered.
+func snmpProbe(ip string) []byte {
+	return []byte{
+		0x30, 0x29, // SEQUENCE
+		0x02, 0x01, 0x01, // version: v2c
+		0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c', // community
+		0xa0, 0x1c, // GetRequest PDU
+		0x02, 0x04, 0x4e, 0x53, 0x43, 0x4e, // request-id
+		0x02, 0x01, 0x00, // error-status
+		0x02, 0x01, 0x00, // error-index
+		0x30, 0x0e, // varbind list
+		0x30, 0x0c, // varbind
+		0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00, // 1.3.6.1.2.1.1.1.0
+		0x05, 0x00, // NULL
+	}
+}
+
+// parseSNMPReply returns the printable sysDescr value of a GetResponse
+func parseSNMPReply(reply []byte) string {
+	oid := []byte{0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00}
+	idx := bytes.Index(reply, oid)
+	if idx < 0 || idx+len(oid)+2 > len(reply) {
+		return "SNMP response"
+	}
+	value := reply[idx+len(oid):]
+	if value[0] != 0x04 { // OCTET STRING
+		return "SNMP response"
+	}
+	length, offset := int(value[1]), 2
+	if length&0x80 != 0 {
+		// Long form length
+		n := length & 0x7f
+		if n > 2 || 2+n > len(value) {
+			return "SNMP response"
+		}
+		length = 0
+		for _, b := range value[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		offset = 2 + n
+	}
+	if offset+length > len(value) {
+		length = len(value) - offset
+	}
+	return cleanBanner(string(value[offset : offset+length]))
+}
+
+// ssdpProbe sends a unicast M-SEARCH for all devices
+func ssdpProbe(ip string) []byte {
+	return []byte("M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + net.JoinHostPort(ip, "1900") + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 1\r\n" +
+		"ST: ssdp:all\r\n\r\n")
+}
+
+var ssdpServerRe = regexp.MustCompile(`(?im)^SERVER:\s*(.+?)\s*$`)
+
+// parseSSDPReply returns the SERVER header of an M-SEARCH response
+func parseSSDPReply(reply []byte) string {
+	if m := ssdpServerRe.FindSubmatch(reply); m != nil {
+		return string(m[1])
+	}
+	return cleanBanner(string(reply))
+}
+
+// coapProbe sends a confirmable CoAP GET for /.well-known/core
+func coapProbe(ip string) []byte {
+	msg := []byte{0x40, 0x01, byte(os.Getpid() >> 8), byte(os.Getpid())} // Ver 1, CON, GET, message ID
+	msg = append(msg, 0xbb)                                              // Uri-Path (11), length 11
+	msg = append(msg, ".well-known"...)
+	msg = append(msg, 0x04) // Uri-Path (delta 0), length 4
+	msg = append(msg, "core"...)
+	return msg
+}
+
+// parseCoAPReply reports the response code, e.g. "2.05"
+func parseCoAPReply(reply []byte) string {
+	if len(reply) < 4 || reply[0]>>6 != 1 {
+		return ""
+	}
+	return fmt.Sprintf("CoAP %d.%02d", reply[1]>>5, reply[1]&0x1f)
+}
@@ -8,13 +8,26 @@ import (
 
 // DeviceQuery represents a parsed search query
 type DeviceQuery struct {
-	Text    string   // General search text
-	Tags    []string // tag:value
-	Ports   []int    // port:80
-	IsKnown *bool    // known:true/false
-	Vendor  string   // vendor:apple
-	Type    string   // type:server
-	Group   string   // group:home
+	Text    string       // General search text
+	Tags    []string     // tag:value
+	Ports   []PortFilter // port:80, port:53/udp
+	IsKnown *bool        // known:true/false
+	Vendor  string       // vendor:apple
+	Type    string       // type:server
+	Group   string       // group:home
+	Service string       // service:ssh
+	Product string       // product:openssh
+	Version string       // version:8.9 (prefix)
+
+	HTTPTitle  string // http.title:router
+	HTTPServer string // http.server:lighttpd (Server or X-Powered-By)
+	Switch     string // switch:core-sw1 (name, IP or MAC of the switch a device is connected to)
+}
+
+// PortFilter matches an open port, on any protocol unless one is given
+type PortFilter struct {
+	Port     int
+	Protocol string // tcp, udp or empty for both
 }
 
 // Parse parses a search string into a DeviceQuery
@@ -32,8 +45,8 @@ func Parse(queryStr string) DeviceQuery {
 			case "tag":
 				query.Tags = append(query.Tags, value)
 			case "port":
-				if p, err := strconv.Atoi(value); err == nil {
-					query.Ports = append(query.Ports, p)
+				if filter, ok := parsePortFilter(value); ok {
+					query.Ports = append(query.Ports, filter)
 				}
 			case "known":
 				k := value == "true" || value == "yes" || value == "1"
@@ -44,6 +57,18 @@ func Parse(queryStr string) DeviceQuery {
 				query.Type = strings.ToLower(value)
 			case "group":
 				query.Group = strings.ToLower(value)
+			case "service":
+				query.Service = strings.ToLower(value)
+			case "product":
+				query.Product = strings.ToLower(value)
+			case "version":
+				query.Version = value
+			case "http.title":
+				query.HTTPTitle = strings.ToLower(value)
+			case "http.server":
+				query.HTTPServer = strings.ToLower(value)
+			case "switch":
+				query.Switch = strings.ToLower(value)
 			default:
 				// Treat as general text if key is unknown
 				if query.Text == "" {
@@ -64,19 +89,131 @@ func Parse(queryStr string) DeviceQuery {
 	return query
 }
 
+// parsePortFilter parses "80", "80/tcp" or "53/udp"
+func parsePortFilter(value string) (PortFilter, bool) {
+	var filter PortFilter
+	if idx := strings.Index(value, "/"); idx >= 0 {
+		filter.Protocol = strings.ToLower(value[idx+1:])
+		if filter.Protocol != "tcp" && filter.Protocol != "udp" {
+			return filter, false
+		}
+		value = value[:idx]
+	}
+	p, err := strconv.Atoi(value)
+	if err != nil {
+		return filter, false
+	}
+	filter.Port = p
+	return filter, true
+}
+
+// hasOpenPort reports whether the device has the port open
+func (f PortFilter) hasOpenPort(d *database.Device) bool {
+	for _, service := range d.Services {
+		if service.Port == f.Port && service.State == database.StateOpen &&
+			(f.Protocol == "" || f.Protocol == service.Protocol) {
+			return true
+		}
+	}
+	if f.Protocol == "" || f.Protocol == "tcp" {
+		for _, dPort := range d.OpenPorts {
+			if dPort == f.Port {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchService checks if an open service satisfies all service filters
+func (q *DeviceQuery) matchService(service *database.Service) bool {
+	if service.State != database.StateOpen {
+		return false
+	}
+	if q.Service != "" && !strings.EqualFold(q.Service, service.Name) {
+		return false
+	}
+	if q.Product != "" && !strings.Contains(strings.ToLower(service.Product), q.Product) {
+		return false
+	}
+	if q.Version != "" && !strings.HasPrefix(service.Version, q.Version) {
+		return false
+	}
+	if q.HTTPTitle != "" || q.HTTPServer != "" {
+		if service.HTTP == nil {
+			return false
+		}
+		if q.HTTPTitle != "" && !strings.Contains(strings.ToLower(service.HTTP.Title), q.HTTPTitle) {
+			return false
+		}
+		server := strings.ToLower(service.HTTP.Server + " " + service.HTTP.PoweredBy)
+		if q.HTTPServer != "" && !strings.Contains(server, q.HTTPServer) {
+			return false
+		}
+	}
+	return true
+}
+
+// serviceText returns the names, products, page titles, certificate names and
+// SSH host key fingerprints of a device's open services, the services it
+// advertises over mDNS, its UPnP description and what its SNMP agent reported
+func serviceText(d *database.Device) string {
+	var parts []string
+	for _, service := range d.Services {
+		if service.State == database.StateOpen {
+			parts = append(parts, service.Name, service.Product)
+			if service.TLS != nil {
+				parts = append(parts, service.TLS.Subject)
+				parts = append(parts, service.TLS.SANs...)
+			}
+			if service.HTTP != nil {
+				parts = append(parts, service.HTTP.Title)
+			}
+			if service.SSH != nil {
+				for _, key := range service.SSH.HostKeys {
+					parts = append(parts, key.Fingerprint)
+				}
+			}
+		}
+	}
+	for _, service := range d.MDNSServices {
+		parts = append(parts, service.Name, service.Type)
+	}
+	if d.UPnP != nil {
+		parts = append(parts, d.UPnP.FriendlyName, d.UPnP.Manufacturer, d.UPnP.ModelName, d.UPnP.ModelNumber)
+	}
+	if d.SNMP != nil {
+		parts = append(parts, d.SNMP.SysName, d.SNMP.SysDescr, d.SNMP.SysLocation)
+	}
+	return strings.Join(parts, " ")
+}
+
+// nameText returns all resolved names of a device
+func nameText(d *database.Device) string {
+	parts := make([]string, 0, len(d.Names))
+	for _, name := range d.Names {
+		parts = append(parts, name.Name)
+	}
+	return strings.Join(parts, " ")
+}
+
 // Match checks if a device matches the query
 func (q *DeviceQuery) Match(d *database.Device) bool {
-	// Text Search (IP, MAC, Name, Vendor, Notes)
+	// Text Search (IP, MAC, Name, Hostname, Vendor, Notes)
 	if q.Text != "" {
 		text := strings.ToLower(q.Text)
 		match := strings.Contains(strings.ToLower(d.IP), text) ||
 			strings.Contains(strings.ToLower(d.MAC), text) ||
 			strings.Contains(strings.ToLower(d.CustomName), text) ||
+			strings.Contains(strings.ToLower(d.Hostname), text) ||
+			strings.Contains(strings.ToLower(nameText(d)), text) ||
 			strings.Contains(strings.ToLower(d.Vendor), text) ||
 			strings.Contains(strings.ToLower(d.Notes), text) ||
 			strings.Contains(strings.ToLower(d.Type), text) ||
 			strings.Contains(strings.ToLower(d.CustomType), text) ||
-			strings.Contains(strings.ToLower(d.GroupName), text)
+			strings.Contains(strings.ToLower(d.GroupName), text) ||
+			strings.Contains(strings.ToLower(strings.Join(d.Addresses, " ")), text) ||
+			strings.Contains(strings.ToLower(serviceText(d)), text)
 
 		if !match {
 			return false
@@ -99,9 +236,16 @@ func (q *DeviceQuery) Match(d *database.Device) bool {
 
 	// Ports
 	for _, qPort := range q.Ports {
+		if !qPort.hasOpenPort(d) {
+			return false
+		}
+	}
+
+	// Service attributes must all match the same service
+	if q.Service != "" || q.Product != "" || q.Version != "" || q.HTTPTitle != "" || q.HTTPServer != "" {
 		found := false
-		for _, dPort := range d.OpenPorts {
-			if qPort == dPort {
+		for i := range d.Services {
+			if q.matchService(&d.Services[i]) {
 				found = true
 				break
 			}
@@ -139,6 +283,17 @@ func (q *DeviceQuery) Match(d *database.Device) bool {
 		}
 	}
 
+	// Switch
+	if q.Switch != "" {
+		port := d.SwitchPort
+		if port == nil {
+			return false
+		}
+		if !strings.Contains(strings.ToLower(port.SwitchName), q.Switch) && port.SwitchIP != q.Switch && port.SwitchMAC != q.Switch {
+			return false
+		}
+	}
+
 	return true
 }
 
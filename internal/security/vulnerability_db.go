@@ -3,17 +3,31 @@ package security
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"network-scanner-go/internal/database"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-// VulnerabilityRule represents a security rule
+// VulnerabilityRule represents a security rule. A rule matches an open
+// service by port, service attributes and/or a TLS check, or a UPnP gateway
+// with upnp_igd.
 type VulnerabilityRule struct {
 	ID          string   `json:"id"`
 	Name        string   `json:"name"`
-	Port        int      `json:"port"`
-	Severity    string   `json:"severity"` // low, medium, high, critical
+	Port        int      `json:"port,omitempty"`
+	Protocol    string   `json:"protocol,omitempty"`    // tcp (default) or udp
+	Service     string   `json:"service,omitempty"`     // Service name, e.g. ssh, vnc
+	Product     string   `json:"product,omitempty"`     // Case-insensitive substring of the product
+	Version     string   `json:"version,omitempty"`     // Version prefix, e.g. "7." for 7.x
+	UPnPIGD     bool     `json:"upnp_igd,omitempty"`    // Device is a UPnP gateway accepting port mappings
+	TLSCheck    string   `json:"tls_check,omitempty"`   // expired, expiring, weak_key, legacy_version or self_signed
+	ExpiryDays  int      `json:"expiry_days,omitempty"` // Window of the expiring check (default 30)
+	Severity    string   `json:"severity"`              // low, medium, high, critical
 	Description string   `json:"description"`
 	Solution    string   `json:"solution"`
 	MoreInfo    string   `json:"more_info"`
@@ -21,70 +35,180 @@ type VulnerabilityRule struct {
 	Types       []string `json:"types,omitempty"`       // Only apply to these device types
 }
 
-var rules []VulnerabilityRule
+var (
+	rules   []VulnerabilityRule
+	rulesMu sync.RWMutex
+)
 
-// LoadRules loads security rules from a JSON file
+// LoadRules loads security rules from a JSON file, replacing the rules
+// loaded before. The current rules are kept when the file is invalid.
 func LoadRules(configPath string) error {
 	data, err := os.ReadFile(configPath)
 	if err != nil {
 		return err
 	}
 
-	return json.Unmarshal(data, &rules)
+	var loaded []VulnerabilityRule
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+
+	rulesMu.Lock()
+	rules = loaded
+	rulesMu.Unlock()
+	return nil
 }
 
-// CheckDevice checks a device for vulnerabilities
-func CheckDevice(openPorts []int, deviceType string) []database.Vulnerability {
+// matches reports whether the rule applies to a service
+func (r *VulnerabilityRule) matches(service *database.Service) bool {
+	if r.Port == 0 && r.Service == "" && r.Product == "" && r.TLSCheck == "" {
+		return false
+	}
+	if service.State != database.StateOpen {
+		return false
+	}
+
+	protocol := r.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	if service.Protocol != protocol {
+		return false
+	}
+	if r.Port > 0 && service.Port != r.Port {
+		return false
+	}
+	if r.Service != "" && !strings.EqualFold(service.Name, r.Service) {
+		return false
+	}
+	if r.Product != "" && !strings.Contains(strings.ToLower(service.Product), strings.ToLower(r.Product)) {
+		return false
+	}
+	if r.Version != "" && !strings.HasPrefix(service.Version, r.Version) {
+		return false
+	}
+	if r.TLSCheck != "" && !r.matchesTLS(service.TLS) {
+		return false
+	}
+	return true
+}
+
+// matchesTLS runs the rule's TLS check against a service's TLS details
+func (r *VulnerabilityRule) matchesTLS(info *database.TLSInfo) bool {
+	if info == nil {
+		return false
+	}
+
+	now := time.Now()
+	switch r.TLSCheck {
+	case "expired":
+		return info.Expired(now)
+	case "expiring":
+		days := r.ExpiryDays
+		if days <= 0 {
+			days = 30
+		}
+		return info.ExpiresWithin(now, time.Duration(days)*24*time.Hour)
+	case "weak_key":
+		return info.WeakKey()
+	case "legacy_version":
+		return len(info.LegacyVersions) > 0
+	case "self_signed":
+		return info.SelfSigned
+	}
+	return false
+}
+
+// CheckDevice checks the open services of a device for vulnerabilities
+func CheckDevice(device *database.Device) []database.Vulnerability {
 	matches := make([]database.Vulnerability, 0)
 
-	for _, rule := range rules {
-		// ... (keep logic but use database.Vulnerability)
-		if rule.Port > 0 {
-			portFound := false
-			for _, p := range openPorts {
-				if p == rule.Port {
-					portFound = true
+	services := device.Services
+	if len(services) == 0 {
+		// Devices that were never scanned with service detection
+		for _, port := range device.OpenPorts {
+			services = append(services, database.Service{Protocol: "tcp", Port: port, State: database.StateOpen})
+		}
+	}
+
+	rulesMu.RLock()
+	current := rules
+	rulesMu.RUnlock()
+
+	for _, rule := range current {
+		// Check if rule is type-specific
+		if len(rule.Types) > 0 {
+			typeMatch := false
+			for _, t := range rule.Types {
+				if t == device.Type {
+					typeMatch = true
 					break
 				}
 			}
+			if !typeMatch {
+				continue
+			}
+		}
 
-			if portFound {
-				// Check if rule is type-specific
-				if len(rule.Types) > 0 {
-					typeMatch := false
-					for _, t := range rule.Types {
-						if t == deviceType {
-							typeMatch = true
-							break
-						}
-					}
-					if !typeMatch {
-						continue
-					}
-				}
+		if rule.UPnPIGD {
+			if device.UPnP == nil || !device.UPnP.IGD {
+				continue
+			}
+			if rule.Port == 0 && rule.Service == "" && rule.Product == "" {
+				matches = append(matches, rule.vulnerability(upnpPort(device.UPnP), "tcp"))
+				continue
+			}
+		}
 
-				matches = append(matches, database.Vulnerability{
-					RuleID:      rule.ID,
-					Name:        rule.Name,
-					Severity:    rule.Severity,
-					Description: rule.Description,
-					Solution:    rule.Solution,
-					MoreInfo:    rule.MoreInfo,
-					Port:        rule.Port,
-				})
-
-				// If there's a CVE keyword, search for CVEs (in a real app this would be async or background)
-				if rule.CVEKeyword != "" {
-					cveVulns := SearchCVEsForKeyword(rule.CVEKeyword)
-					matches = append(matches, cveVulns...)
-				}
+		for i := range services {
+			service := &services[i]
+			if !rule.matches(service) {
+				continue
+			}
+
+			matches = append(matches, rule.vulnerability(service.Port, service.Protocol))
+
+			// If there's a CVE keyword, search for CVEs (in a real app this would be async or background)
+			if rule.CVEKeyword != "" {
+				cveVulns := SearchCVEsForKeyword(rule.CVEKeyword)
+				matches = append(matches, cveVulns...)
 			}
+			break
 		}
 	}
 
 	return matches
 }
 
+// vulnerability creates the finding for a rule match
+func (r *VulnerabilityRule) vulnerability(port int, protocol string) database.Vulnerability {
+	return database.Vulnerability{
+		RuleID:      r.ID,
+		Name:        r.Name,
+		Severity:    r.Severity,
+		Description: r.Description,
+		Solution:    r.Solution,
+		MoreInfo:    r.MoreInfo,
+		Port:        port,
+		Protocol:    protocol,
+	}
+}
+
+// upnpPort returns the port serving a UPnP device description
+func upnpPort(info *database.UPnPInfo) int {
+	u, err := url.Parse(info.Location)
+	if err != nil {
+		return 0
+	}
+	if port, err := strconv.Atoi(u.Port()); err == nil {
+		return port
+	}
+	if u.Scheme == "https" {
+		return 443
+	}
+	return 80
+}
+
 // SearchCVEsForKeyword searches for recent CVEs related to a keyword
 func SearchCVEsForKeyword(keyword string) []database.Vulnerability {
 	// Note: In a real app we'd search the database for all CVEs related to this keyword
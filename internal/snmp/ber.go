@@ -0,0 +1,338 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER tags used by SNMP
+const (
+	tagInteger        = 0x02
+	tagOctetString    = 0x04
+	tagNull           = 0x05
+	tagOID            = 0x06
+	tagSequence       = 0x30
+	tagIPAddress      = 0x40
+	tagCounter32      = 0x41
+	tagGauge32        = 0x42
+	tagTimeTicks      = 0x43
+	tagOpaque         = 0x44
+	tagCounter64      = 0x46
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+)
+
+// PDU types
+const (
+	pduGetRequest     = 0xa0
+	pduGetNextRequest = 0xa1
+	pduResponse       = 0xa2
+	pduGetBulkRequest = 0xa5
+	pduReport         = 0xa8
+)
+
+var errMalformed = errors.New("malformed SNMP message")
+
+// Variable is an OID and its value. Value is an int64 (INTEGER), uint64
+// (counters, gauges, TimeTicks), []byte (OCTET STRING, IpAddress, Opaque),
+// string (OBJECT IDENTIFIER) or nil.
+type Variable struct {
+	OID   string
+	Type  byte
+	Value interface{}
+}
+
+// String returns the value as text
+func (v Variable) String() string {
+	switch value := v.Value.(type) {
+	case []byte:
+		if v.Type == tagIPAddress && len(value) == 4 {
+			return fmt.Sprintf("%d.%d.%d.%d", value[0], value[1], value[2], value[3])
+		}
+		return string(value)
+	case string:
+		return value
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v.Value)
+}
+
+// Int returns a numeric value, or 0
+func (v Variable) Int() int64 {
+	switch value := v.Value.(type) {
+	case int64:
+		return value
+	case uint64:
+		return int64(value)
+	}
+	return 0
+}
+
+// Bytes returns an OCTET STRING value, or nil
+func (v Variable) Bytes() []byte {
+	if value, ok := v.Value.([]byte); ok {
+		return value
+	}
+	return nil
+}
+
+// Exists reports whether the agent returned a value rather than an exception
+func (v Variable) Exists() bool {
+	return v.Type != tagNoSuchObject && v.Type != tagNoSuchInstance && v.Type != tagEndOfMibView
+}
+
+// pdu is a request or response PDU. For GetBulk, ErrorStatus and ErrorIndex
+// hold non-repeaters and max-repetitions.
+type pdu struct {
+	Type        byte
+	RequestID   int32
+	ErrorStatus int
+	ErrorIndex  int
+	Variables   []Variable
+}
+
+// encodeTLV encodes a tag, length and value
+func encodeTLV(tag byte, value []byte) []byte {
+	out := []byte{tag}
+	out = append(out, encodeLength(len(value))...)
+	return append(out, value...)
+}
+
+// encodeLength encodes a BER length in short or long form
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// encodeInteger encodes a signed INTEGER in the fewest bytes
+func encodeInteger(n int64) []byte {
+	b := []byte{byte(n)}
+	for n > 127 || n < -128 {
+		n >>= 8
+		b = append([]byte{byte(n)}, b...)
+	}
+	return encodeTLV(tagInteger, b)
+}
+
+// encodeOID encodes a dotted OBJECT IDENTIFIER
+func encodeOID(oid string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", oid)
+	}
+	ids := make([]uint64, len(parts))
+	for i, part := range parts {
+		id, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", oid)
+		}
+		ids[i] = id
+	}
+
+	b := encodeSubidentifier(nil, ids[0]*40+ids[1])
+	for _, id := range ids[2:] {
+		b = encodeSubidentifier(b, id)
+	}
+	return encodeTLV(tagOID, b), nil
+}
+
+// encodeSubidentifier appends a base-128 OID component
+func encodeSubidentifier(b []byte, id uint64) []byte {
+	var tmp []byte
+	tmp = append(tmp, byte(id&0x7f))
+	for id >>= 7; id > 0; id >>= 7 {
+		tmp = append([]byte{byte(id&0x7f) | 0x80}, tmp...)
+	}
+	return append(b, tmp...)
+}
+
+// encodePDU encodes a request PDU with NULL values
+func encodePDU(p *pdu) ([]byte, error) {
+	var varbinds []byte
+	for _, v := range p.Variables {
+		oid, err := encodeOID(v.OID)
+		if err != nil {
+			return nil, err
+		}
+		varbinds = append(varbinds, encodeTLV(tagSequence, append(oid, tagNull, 0))...)
+	}
+
+	var body []byte
+	body = append(body, encodeInteger(int64(p.RequestID))...)
+	body = append(body, encodeInteger(int64(p.ErrorStatus))...)
+	body = append(body, encodeInteger(int64(p.ErrorIndex))...)
+	body = append(body, encodeTLV(tagSequence, varbinds)...)
+	return encodeTLV(p.Type, body), nil
+}
+
+// readTLV splits the first element off data
+func readTLV(data []byte) (tag byte, value, rest []byte, err error) {
+	if len(data) < 2 {
+		return 0, nil, nil, errMalformed
+	}
+	tag = data[0]
+	length, offset := int(data[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(data) < 2+n {
+			return 0, nil, nil, errMalformed
+		}
+		length = 0
+		for _, b := range data[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		offset = 2 + n
+	}
+	if length < 0 || offset+length > len(data) {
+		return 0, nil, nil, errMalformed
+	}
+	return tag, data[offset : offset+length], data[offset+length:], nil
+}
+
+// readExpected reads an element and checks its tag
+func readExpected(data []byte, want byte) ([]byte, []byte, error) {
+	tag, value, rest, err := readTLV(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tag != want {
+		return nil, nil, errMalformed
+	}
+	return value, rest, nil
+}
+
+// readInteger reads an INTEGER element
+func readInteger(data []byte) (int64, []byte, error) {
+	value, rest, err := readExpected(data, tagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	return decodeInteger(value), rest, nil
+}
+
+// decodeInteger decodes a two's complement integer
+func decodeInteger(b []byte) int64 {
+	var n int64
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n = -1
+	}
+	for _, c := range b {
+		n = n<<8 | int64(c)
+	}
+	return n
+}
+
+// decodeUnsigned decodes an unsigned integer
+func decodeUnsigned(b []byte) uint64 {
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
+
+// decodeOID decodes an OBJECT IDENTIFIER to dotted form
+func decodeOID(b []byte) (string, error) {
+	if len(b) == 0 {
+		return "", errMalformed
+	}
+	var ids []uint64
+	var id uint64
+	for i, c := range b {
+		id = id<<7 | uint64(c&0x7f)
+		if c&0x80 == 0 {
+			ids = append(ids, id)
+			id = 0
+		} else if i == len(b)-1 {
+			return "", errMalformed
+		}
+	}
+
+	parts := make([]string, 0, len(ids)+1)
+	first := ids[0]
+	switch {
+	case first < 40:
+		parts = append(parts, "0", strconv.FormatUint(first, 10))
+	case first < 80:
+		parts = append(parts, "1", strconv.FormatUint(first-40, 10))
+	default:
+		parts = append(parts, "2", strconv.FormatUint(first-80, 10))
+	}
+	for _, id := range ids[1:] {
+		parts = append(parts, strconv.FormatUint(id, 10))
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// decodePDU decodes a response or report PDU
+func decodePDU(data []byte) (*pdu, error) {
+	tag, body, _, err := readTLV(data)
+	if err != nil {
+		return nil, err
+	}
+	p := &pdu{Type: tag}
+
+	id, body, err := readInteger(body)
+	if err != nil {
+		return nil, err
+	}
+	p.RequestID = int32(id)
+	status, body, err := readInteger(body)
+	if err != nil {
+		return nil, err
+	}
+	p.ErrorStatus = int(status)
+	index, body, err := readInteger(body)
+	if err != nil {
+		return nil, err
+	}
+	p.ErrorIndex = int(index)
+
+	varbinds, _, err := readExpected(body, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	for len(varbinds) > 0 {
+		var varbind []byte
+		varbind, varbinds, err = readExpected(varbinds, tagSequence)
+		if err != nil {
+			return nil, err
+		}
+		oidBytes, rest, err := readExpected(varbind, tagOID)
+		if err != nil {
+			return nil, err
+		}
+		oid, err := decodeOID(oidBytes)
+		if err != nil {
+			return nil, err
+		}
+		valueTag, value, _, err := readTLV(rest)
+		if err != nil {
+			return nil, err
+		}
+
+		v := Variable{OID: oid, Type: valueTag}
+		switch valueTag {
+		case tagInteger:
+			v.Value = decodeInteger(value)
+		case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+			v.Value = decodeUnsigned(value)
+		case tagOctetString, tagIPAddress, tagOpaque:
+			v.Value = append([]byte(nil), value...)
+		case tagOID:
+			v.Value, _ = decodeOID(value)
+		}
+		p.Variables = append(p.Variables, v)
+	}
+	return p, nil
+}
@@ -0,0 +1,470 @@
+// Package snmp is a small SNMP v2c/v3 client and the poller that reads
+// system details, interfaces and the bridge forwarding table of agents.
+package snmp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"network-scanner-go/internal/scanner"
+	"strings"
+	"time"
+)
+
+// maxMessageSize is the largest message we accept
+const maxMessageSize = 65507
+
+// maxWalkVariables stops walks of very large tables
+const maxWalkVariables = 20000
+
+// usmStatsNotInTimeWindows is reported when the engine time we sent is stale
+const usmStatsNotInTimeWindows = "1.3.6.1.6.3.15.1.1.2.0"
+
+var errTimeout = errors.New("no response from SNMP agent")
+
+// Client talks to one SNMP agent over UDP
+type Client struct {
+	Community string // v2c community, used when User is nil
+	User      *User  // v3 user
+	Timeout   time.Duration
+	Retries   int
+
+	conn      net.Conn
+	stop      func() bool // Stops closing conn when the dial context is done
+	requestID int32
+	salt      uint64
+
+	// v3 engine state, learned by discovery
+	engineID   []byte
+	engineBoot int32
+	engineTime int32
+	timeBase   time.Time
+	authKey    []byte
+	privKey    []byte
+}
+
+// Dial creates a client for an agent. address may omit the port (161). The
+// socket counts against the scanner's probe budget until the client is closed.
+// When ctx is done the socket is closed and pending requests fail.
+func Dial(ctx context.Context, address, community string, user *User, timeout time.Duration) (*Client, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "161")
+	}
+	conn, err := scanner.DialProbe(ctx, "udp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		Community: community,
+		User:      user,
+		Timeout:   timeout,
+		Retries:   1,
+		conn:      conn,
+		stop:      context.AfterFunc(ctx, func() { conn.Close() }),
+		requestID: rand.Int31(),
+		salt:      rand.Uint64(),
+	}, nil
+}
+
+// Close closes the socket
+func (c *Client) Close() error {
+	c.stop()
+	return c.conn.Close()
+}
+
+// Get reads the values of OIDs
+func (c *Client) Get(oids ...string) ([]Variable, error) {
+	p := &pdu{Type: pduGetRequest}
+	for _, oid := range oids {
+		p.Variables = append(p.Variables, Variable{OID: oid})
+	}
+	resp, err := c.request(p)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Variables, nil
+}
+
+// Walk reads every variable below root with GetBulk requests
+func (c *Client) Walk(root string) ([]Variable, error) {
+	prefix := strings.TrimPrefix(root, ".") + "."
+	var variables []Variable
+	next := root
+	for len(variables) < maxWalkVariables {
+		resp, err := c.request(&pdu{
+			Type:       pduGetBulkRequest,
+			ErrorIndex: 25, // max-repetitions
+			Variables:  []Variable{{OID: next}},
+		})
+		if err != nil {
+			return variables, err
+		}
+		if len(resp.Variables) == 0 {
+			return variables, nil
+		}
+		for _, v := range resp.Variables {
+			if !v.Exists() || !strings.HasPrefix(v.OID, prefix) || v.OID == next {
+				return variables, nil
+			}
+			variables = append(variables, v)
+			next = v.OID
+		}
+	}
+	return variables, nil
+}
+
+// request sends a PDU and waits for the matching response, retrying on
+// timeouts. SNMPv3 engines are discovered on first use.
+func (c *Client) request(p *pdu) (*pdu, error) {
+	if c.User != nil && c.engineID == nil {
+		if err := c.discover(); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		c.requestID++
+		p.RequestID = c.requestID
+
+		resp, err := c.exchange(p)
+		if err == errTimeout {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if resp.Type == pduReport {
+			// The engine rebooted or our clock drifted: resynchronize once
+			if len(resp.Variables) > 0 && resp.Variables[0].OID == usmStatsNotInTimeWindows && attempt < c.Retries {
+				continue
+			}
+			return nil, fmt.Errorf("SNMP report %s", reportOID(resp))
+		}
+		if resp.ErrorStatus != 0 {
+			return nil, fmt.Errorf("SNMP error status %d", resp.ErrorStatus)
+		}
+		return resp, nil
+	}
+	return nil, errTimeout
+}
+
+// exchange sends one request and reads responses until the one with our ID
+func (c *Client) exchange(p *pdu) (*pdu, error) {
+	message, err := c.encode(p)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(message); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(c.Timeout)
+	buf := make([]byte, maxMessageSize)
+	for {
+		c.conn.SetReadDeadline(deadline)
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return nil, errTimeout
+			}
+			return nil, err
+		}
+		resp, err := c.decode(buf[:n])
+		if err != nil || (resp.RequestID != p.RequestID && resp.Type != pduReport) {
+			continue // Stale or foreign reply
+		}
+		return resp, nil
+	}
+}
+
+// encode builds a v2c or v3 message
+func (c *Client) encode(p *pdu) ([]byte, error) {
+	pduBytes, err := encodePDU(p)
+	if err != nil {
+		return nil, err
+	}
+	if c.User == nil {
+		var body []byte
+		body = append(body, encodeInteger(1)...) // version-2c
+		body = append(body, encodeTLV(tagOctetString, []byte(c.Community))...)
+		body = append(body, pduBytes...)
+		return encodeTLV(tagSequence, body), nil
+	}
+	return c.encodeV3(pduBytes, c.User.flags()|0x04, c.User.Name)
+}
+
+// encodeV3 builds an SNMPv3 message with the given security flags. The
+// message is authenticated after encoding, by filling in the placeholder of
+// the authentication parameters.
+func (c *Client) encodeV3(pduBytes []byte, flags byte, userName string) ([]byte, error) {
+	scoped := encodeTLV(tagSequence, append(append(
+		encodeTLV(tagOctetString, c.engineID),
+		encodeTLV(tagOctetString, nil)...), // context name
+		pduBytes...))
+
+	boots, engineTime := c.engineBoot, c.currentEngineTime()
+	var privParams []byte
+	msgData := scoped
+	if flags&0x02 != 0 {
+		c.salt++
+		encrypted, params, err := encryptScopedPDU(c.User.PrivProtocol, c.privKey, boots, engineTime, c.salt, scoped)
+		if err != nil {
+			return nil, err
+		}
+		privParams = params
+		msgData = encodeTLV(tagOctetString, encrypted)
+	}
+
+	authLength := 0
+	if flags&0x01 != 0 {
+		authLength = c.User.authParamsLength()
+	}
+
+	var secFields []byte
+	secFields = append(secFields, encodeTLV(tagOctetString, c.engineID)...)
+	secFields = append(secFields, encodeInteger(int64(boots))...)
+	secFields = append(secFields, encodeInteger(int64(engineTime))...)
+	secFields = append(secFields, encodeTLV(tagOctetString, []byte(userName))...)
+	authOffset := len(secFields) + 2 // Tag and short length of the auth parameters
+	secFields = append(secFields, encodeTLV(tagOctetString, make([]byte, authLength))...)
+	secFields = append(secFields, encodeTLV(tagOctetString, privParams)...)
+	secSeq := encodeTLV(tagSequence, secFields)
+	authOffset += len(secSeq) - len(secFields)
+	secParams := encodeTLV(tagOctetString, secSeq)
+	authOffset += len(secParams) - len(secSeq)
+
+	c.requestID++
+	var global []byte
+	global = append(global, encodeInteger(int64(c.requestID))...) // msgID
+	global = append(global, encodeInteger(maxMessageSize)...)
+	global = append(global, encodeTLV(tagOctetString, []byte{flags})...)
+	global = append(global, encodeInteger(3)...) // USM
+
+	var body []byte
+	body = append(body, encodeInteger(3)...)
+	body = append(body, encodeTLV(tagSequence, global)...)
+	authOffset += len(body)
+	body = append(body, secParams...)
+	body = append(body, msgData...)
+	message := encodeTLV(tagSequence, body)
+	authOffset += len(message) - len(body)
+
+	if authLength > 0 {
+		mac := authenticate(c.User.newHash(), c.authKey, message, authLength)
+		copy(message[authOffset:], mac)
+	}
+	return message, nil
+}
+
+// decode parses a v2c or v3 response
+func (c *Client) decode(data []byte) (*pdu, error) {
+	body, _, err := readExpected(data, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	version, rest, err := readInteger(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if version != 3 {
+		if c.User != nil {
+			return nil, errMalformed
+		}
+		community, rest, err := readExpected(rest, tagOctetString)
+		if err != nil {
+			return nil, err
+		}
+		if string(community) != c.Community {
+			return nil, errMalformed
+		}
+		return decodePDU(rest)
+	}
+	if c.User == nil {
+		return nil, errMalformed
+	}
+	return c.decodeV3(data, rest)
+}
+
+// decodeV3 parses an SNMPv3 response after the version field, checking its
+// authentication and decrypting it as needed
+func (c *Client) decodeV3(message, data []byte) (*pdu, error) {
+	global, rest, err := readExpected(data, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	_, global, err = readInteger(global) // msgID
+	if err != nil {
+		return nil, err
+	}
+	_, global, err = readInteger(global) // msgMaxSize
+	if err != nil {
+		return nil, err
+	}
+	flagBytes, _, err := readExpected(global, tagOctetString)
+	if err != nil || len(flagBytes) != 1 {
+		return nil, errMalformed
+	}
+	flags := flagBytes[0]
+
+	secParams, msgData, err := readExpected(rest, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+	secFields, _, err := readExpected(secParams, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	engineID, secFields, err := readExpected(secFields, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+	boots, secFields, err := readInteger(secFields)
+	if err != nil {
+		return nil, err
+	}
+	engineTime, secFields, err := readInteger(secFields)
+	if err != nil {
+		return nil, err
+	}
+	_, secFields, err = readExpected(secFields, tagOctetString) // user name
+	if err != nil {
+		return nil, err
+	}
+	authParams, secFields, err := readExpected(secFields, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+	privParams, _, err := readExpected(secFields, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+
+	if flags&0x01 != 0 {
+		if c.authKey == nil || len(authParams) != c.User.authParamsLength() {
+			return nil, errMalformed
+		}
+		// Check the HMAC over the message with the parameters zeroed. The
+		// parameters are a slice of message, so their offset follows from
+		// the capacities.
+		idx := cap(message) - cap(authParams)
+		zeroed := append([]byte(nil), message...)
+		copy(zeroed[idx:idx+len(authParams)], make([]byte, len(authParams)))
+		if !bytes.Equal(authenticate(c.User.newHash(), c.authKey, zeroed, len(authParams)), authParams) {
+			return nil, errors.New("SNMP response failed authentication")
+		}
+	}
+
+	// Keep the engine clock in step with authenticated responses and reports
+	if len(engineID) > 0 && (c.engineID == nil || flags&0x01 != 0 || bytes.Equal(engineID, c.engineID)) {
+		c.engineBoot, c.engineTime, c.timeBase = int32(boots), int32(engineTime), time.Now()
+	}
+
+	scoped := msgData
+	if flags&0x02 != 0 {
+		encrypted, _, err := readExpected(msgData, tagOctetString)
+		if err != nil {
+			return nil, err
+		}
+		scoped, err = decryptScopedPDU(c.User.PrivProtocol, c.privKey, int32(boots), int32(engineTime), privParams, encrypted)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	scopedBody, _, err := readExpected(scoped, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	_, scopedBody, err = readExpected(scopedBody, tagOctetString) // context engine ID
+	if err != nil {
+		return nil, err
+	}
+	_, scopedBody, err = readExpected(scopedBody, tagOctetString) // context name
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodePDU(scopedBody)
+	if err != nil {
+		return nil, err
+	}
+	if c.engineID == nil {
+		c.engineID = append([]byte(nil), engineID...)
+	}
+	return p, nil
+}
+
+// discover learns the agent's engine ID, boots and time with an
+// unauthenticated request (RFC 3414 section 4) and localizes the user's keys
+func (c *Client) discover() error {
+	probe, err := encodePDU(&pdu{Type: pduGetRequest, RequestID: c.requestID})
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		message, err := c.encodeV3(probe, 0x04, "")
+		if err != nil {
+			return err
+		}
+		if _, err := c.conn.Write(message); err != nil {
+			return err
+		}
+
+		c.conn.SetReadDeadline(time.Now().Add(c.Timeout))
+		buf := make([]byte, maxMessageSize)
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		if _, err := c.decode(buf[:n]); err != nil {
+			return err
+		}
+		if len(c.engineID) == 0 {
+			return errors.New("SNMP agent did not report its engine ID")
+		}
+
+		if c.User.AuthProtocol != AuthNone {
+			c.authKey = localizeKey(c.User.newHash(), c.User.AuthPassphrase, c.engineID)
+		}
+		if c.User.PrivProtocol != PrivNone {
+			// The privacy key is localized with the authentication hash
+			c.privKey = localizeKey(c.User.newHash(), c.User.PrivPassphrase, c.engineID)
+		}
+		return nil
+	}
+	return errTimeout
+}
+
+// currentEngineTime estimates the agent's engine time now
+func (c *Client) currentEngineTime() int32 {
+	if c.timeBase.IsZero() {
+		return c.engineTime
+	}
+	return c.engineTime + int32(time.Since(c.timeBase)/time.Second)
+}
+
+// reportOID names the counter a report carries, e.g. usmStatsUnknownUserNames
+func reportOID(p *pdu) string {
+	if len(p.Variables) == 0 {
+		return "without variables"
+	}
+	names := map[string]string{
+		"1.3.6.1.6.3.15.1.1.1.0": "usmStatsUnsupportedSecLevels",
+		"1.3.6.1.6.3.15.1.1.2.0": "usmStatsNotInTimeWindows",
+		"1.3.6.1.6.3.15.1.1.3.0": "usmStatsUnknownUserNames",
+		"1.3.6.1.6.3.15.1.1.4.0": "usmStatsUnknownEngineIDs",
+		"1.3.6.1.6.3.15.1.1.5.0": "usmStatsWrongDigests",
+		"1.3.6.1.6.3.15.1.1.6.0": "usmStatsDecryptionErrors",
+	}
+	if name, ok := names[p.Variables[0].OID]; ok {
+		return name
+	}
+	return p.Variables[0].OID
+}
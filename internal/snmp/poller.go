@@ -0,0 +1,464 @@
+package snmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"network-scanner-go/internal/database"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// System, entity and host resources OIDs
+const (
+	oidSysDescr             = "1.3.6.1.2.1.1.1.0"
+	oidSysObjectID          = "1.3.6.1.2.1.1.2.0"
+	oidSysUpTime            = "1.3.6.1.2.1.1.3.0"
+	oidSysName              = "1.3.6.1.2.1.1.5.0"
+	oidSysLocation          = "1.3.6.1.2.1.1.6.0"
+	oidEntPhysicalModelName = "1.3.6.1.2.1.47.1.1.1.1.13.1"
+	oidHrDeviceDescr        = "1.3.6.1.2.1.25.3.2.1.3.1"
+)
+
+// Interface table columns (IF-MIB)
+const (
+	oidIfDescr       = "1.3.6.1.2.1.2.2.1.2"
+	oidIfType        = "1.3.6.1.2.1.2.2.1.3"
+	oidIfSpeed       = "1.3.6.1.2.1.2.2.1.5"
+	oidIfPhysAddress = "1.3.6.1.2.1.2.2.1.6"
+	oidIfOperStatus  = "1.3.6.1.2.1.2.2.1.8"
+	oidIfName        = "1.3.6.1.2.1.31.1.1.1.1"
+	oidIfHighSpeed   = "1.3.6.1.2.1.31.1.1.1.15"
+)
+
+// Forwarding tables (BRIDGE-MIB and Q-BRIDGE-MIB)
+const (
+	oidDot1dBasePortIfIndex = "1.3.6.1.2.1.17.1.4.1.2"
+	oidDot1dTpFdbPort       = "1.3.6.1.2.1.17.4.3.1.2"
+	oidDot1dTpFdbStatus     = "1.3.6.1.2.1.17.4.3.1.3"
+	oidDot1qTpFdbPort       = "1.3.6.1.2.1.17.7.1.2.2.1.2"
+	oidDot1qTpFdbStatus     = "1.3.6.1.2.1.17.7.1.2.2.1.3"
+)
+
+// fdbStatusLearned is dot1dTpFdbStatus learned(3); self(4) and mgmt(5)
+// entries are the switch's own addresses
+const fdbStatusLearned = 3
+
+// ifOperStatusUp is ifOperStatus up(1)
+const ifOperStatusUp = 1
+
+// Poller reads system details, interfaces and forwarding tables of the SNMP
+// agents of devices, using the credentials configured for their subnet.
+// Credentials are read from the database by Refresh, once per scan cycle.
+type Poller struct {
+	Timeout time.Duration
+
+	mu          sync.RWMutex
+	credentials []credential // Most specific subnet first
+}
+
+// NewPoller creates a poller
+func NewPoller(timeout time.Duration) *Poller {
+	return &Poller{Timeout: timeout}
+}
+
+// NewUser converts a stored SNMPv3 user
+func NewUser(record *database.SNMPUser) *User {
+	return &User{
+		Name:           record.Name,
+		AuthProtocol:   strings.ToUpper(record.AuthProtocol),
+		AuthPassphrase: record.AuthPassphrase,
+		PrivProtocol:   strings.ToUpper(record.PrivProtocol),
+		PrivPassphrase: record.PrivPassphrase,
+	}
+}
+
+// ValidateCredential checks a credential and normalizes its subnet. A plain
+// address becomes a single-host subnet.
+func ValidateCredential(credential *database.SNMPCredential) error {
+	subnet := credential.Subnet
+	if !strings.Contains(subnet, "/") {
+		ip := net.ParseIP(subnet)
+		if ip == nil {
+			return fmt.Errorf("invalid subnet %q", credential.Subnet)
+		}
+		if ip.To4() != nil {
+			subnet += "/32"
+		} else {
+			subnet += "/128"
+		}
+	}
+	_, network, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return fmt.Errorf("invalid subnet %q", credential.Subnet)
+	}
+	credential.Subnet = network.String()
+
+	switch credential.Version {
+	case database.SNMPVersion2c:
+		if credential.Community == "" {
+			return errors.New("community is required for SNMPv2c")
+		}
+		credential.User = ""
+	case database.SNMPVersion3:
+		if credential.User == "" {
+			return errors.New("user is required for SNMPv3")
+		}
+		credential.Community = ""
+	default:
+		return fmt.Errorf("unsupported SNMP version %q (use 2c or 3)", credential.Version)
+	}
+	return nil
+}
+
+// Poll queries the agent at ip. Credentials of the most specific subnets
+// containing ip are tried first, and the first one that answers is used.
+// It returns nil without error when no credential covers ip.
+func (p *Poller) Poll(ctx context.Context, ip string) (*database.SNMPInfo, []database.FDBEntry, error) {
+	credentials := p.credentialsFor(ip)
+	if len(credentials) == 0 {
+		return nil, nil, nil
+	}
+
+	var lastErr error
+	for _, credential := range credentials {
+		client, err := Dial(ctx, ip, credential.Community, credential.user, p.Timeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		info, entries, err := poll(client)
+		client.Close()
+		if err == nil {
+			return info, entries, nil
+		}
+		lastErr = err
+	}
+	return nil, nil, lastErr
+}
+
+// credential is a stored credential with its v3 user resolved
+type credential struct {
+	*database.SNMPCredential
+	network *net.IPNet
+	user    *User
+}
+
+// Refresh reads and decrypts the stored credentials. Polls use them until the
+// next refresh; when reading fails, the previous credentials are kept.
+func (p *Poller) Refresh() error {
+	records, err := database.GetSNMPCredentials()
+	if err != nil {
+		return err
+	}
+	var users []*database.SNMPUser
+
+	var loaded []credential
+	for _, record := range records {
+		_, network, err := net.ParseCIDR(record.Subnet)
+		if err != nil {
+			continue
+		}
+		c := credential{SNMPCredential: record, network: network}
+		if record.Version == database.SNMPVersion3 {
+			if users == nil {
+				if users, err = database.GetSNMPUsers(); err != nil {
+					return err
+				}
+			}
+			for _, u := range users {
+				if u.Name == record.User {
+					c.user = NewUser(u)
+				}
+			}
+			if c.user == nil {
+				log.Printf("SNMP credential for %s refers to unknown user %q", record.Subnet, record.User)
+				continue
+			}
+		}
+		loaded = append(loaded, c)
+	}
+
+	sort.SliceStable(loaded, func(i, j int) bool {
+		a, _ := loaded[i].network.Mask.Size()
+		b, _ := loaded[j].network.Mask.Size()
+		return a > b
+	})
+
+	p.mu.Lock()
+	p.credentials = loaded
+	p.mu.Unlock()
+	return nil
+}
+
+// credentialsFor returns the credentials whose subnet contains ip, most
+// specific first
+func (p *Poller) credentialsFor(ip string) []credential {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var matched []credential
+	for _, c := range p.credentials {
+		if c.network.Contains(addr) {
+			matched = append(matched, c)
+		}
+	}
+	return matched
+}
+
+// poll reads everything from one agent
+func poll(client *Client) (*database.SNMPInfo, []database.FDBEntry, error) {
+	system, err := client.Get(oidSysDescr, oidSysObjectID, oidSysUpTime, oidSysName, oidSysLocation)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := &database.SNMPInfo{PolledAt: time.Now()}
+	for _, v := range system {
+		if !v.Exists() {
+			continue
+		}
+		switch v.OID {
+		case oidSysDescr:
+			info.SysDescr = cleanText(v.String())
+		case oidSysObjectID:
+			info.SysObjectID = v.String()
+		case oidSysUpTime:
+			info.UptimeSeconds = v.Int() / 100 // TimeTicks are hundredths of a second
+		case oidSysName:
+			info.SysName = cleanText(v.String())
+		case oidSysLocation:
+			info.SysLocation = cleanText(v.String())
+		}
+	}
+
+	// Agents without these MIBs answer noSuchObject
+	if model, err := client.Get(oidEntPhysicalModelName, oidHrDeviceDescr); err == nil {
+		for _, v := range model {
+			if text := cleanText(v.String()); v.Exists() && text != "" && info.Model == "" {
+				info.Model = text
+			}
+		}
+	}
+
+	info.Interfaces = interfaces(client)
+	entries := forwardingTable(client, info.Interfaces)
+	return info, entries, nil
+}
+
+// interfaces walks the interface table
+func interfaces(client *Client) []database.SNMPInterface {
+	byIndex := make(map[int]*database.SNMPInterface)
+	var order []int
+	row := func(index int) *database.SNMPInterface {
+		iface, ok := byIndex[index]
+		if !ok {
+			iface = &database.SNMPInterface{Index: index}
+			byIndex[index] = iface
+			order = append(order, index)
+		}
+		return iface
+	}
+
+	columns := []string{oidIfDescr, oidIfType, oidIfSpeed, oidIfPhysAddress, oidIfOperStatus, oidIfName, oidIfHighSpeed}
+	for _, column := range columns {
+		variables, err := client.Walk(column)
+		if err != nil && len(variables) == 0 {
+			continue
+		}
+		for _, v := range variables {
+			index, err := strconv.Atoi(strings.TrimPrefix(v.OID, column+"."))
+			if err != nil {
+				continue
+			}
+			iface := row(index)
+			switch column {
+			case oidIfDescr:
+				iface.Description = cleanText(v.String())
+			case oidIfType:
+				iface.Type = int(v.Int())
+			case oidIfSpeed:
+				if iface.SpeedMbps == 0 {
+					iface.SpeedMbps = v.Int() / 1000000
+				}
+			case oidIfPhysAddress:
+				if b := v.Bytes(); len(b) == 6 {
+					iface.MAC = net.HardwareAddr(b).String()
+				}
+			case oidIfOperStatus:
+				iface.Up = v.Int() == ifOperStatusUp
+			case oidIfName:
+				iface.Name = cleanText(v.String())
+			case oidIfHighSpeed:
+				// ifSpeed saturates at 4294 Mbit/s
+				if speed := v.Int(); speed > 0 {
+					iface.SpeedMbps = speed
+				}
+			}
+		}
+	}
+
+	sort.Ints(order)
+	result := make([]database.SNMPInterface, 0, len(order))
+	for _, index := range order {
+		iface := byIndex[index]
+		if iface.Name == "" {
+			iface.Name = iface.Description
+		}
+		result = append(result, *iface)
+	}
+	return result
+}
+
+// forwardingTable reads the MAC addresses learned on each bridge port,
+// preferring the per-VLAN Q-BRIDGE table
+func forwardingTable(client *Client, ifaces []database.SNMPInterface) []database.FDBEntry {
+	// Bridge port numbers are not interface indexes
+	basePorts, _ := client.Walk(oidDot1dBasePortIfIndex)
+	if len(basePorts) == 0 {
+		return nil // Not a bridge
+	}
+	ifIndexOf := make(map[int]int)
+	for _, v := range basePorts {
+		if port, err := strconv.Atoi(strings.TrimPrefix(v.OID, oidDot1dBasePortIfIndex+".")); err == nil {
+			ifIndexOf[port] = int(v.Int())
+		}
+	}
+	names := make(map[int]string)
+	for _, iface := range ifaces {
+		names[iface.Index] = iface.Name
+	}
+
+	// Q-BRIDGE rows are indexed by FDB ID and MAC, BRIDGE rows by MAC alone
+	portColumn, statusColumn, vlanIndexed := oidDot1qTpFdbPort, oidDot1qTpFdbStatus, true
+	ports, _ := client.Walk(portColumn)
+	if len(ports) == 0 {
+		portColumn, statusColumn, vlanIndexed = oidDot1dTpFdbPort, oidDot1dTpFdbStatus, false
+		ports, _ = client.Walk(portColumn)
+	}
+	statuses := make(map[string]int64)
+	if variables, err := client.Walk(statusColumn); err == nil || len(variables) > 0 {
+		for _, v := range variables {
+			statuses[strings.TrimPrefix(v.OID, statusColumn+".")] = v.Int()
+		}
+	}
+
+	now := time.Now()
+	var entries []database.FDBEntry
+	for _, v := range ports {
+		index := strings.TrimPrefix(v.OID, portColumn+".")
+		if status, ok := statuses[index]; ok && status != fdbStatusLearned {
+			continue
+		}
+		vlan, mac, ok := parseFDBIndex(index, vlanIndexed)
+		if !ok {
+			continue
+		}
+		ifIndex, ok := ifIndexOf[int(v.Int())]
+		if !ok {
+			continue
+		}
+		entries = append(entries, database.FDBEntry{
+			MAC:      mac,
+			IfIndex:  ifIndex,
+			Port:     names[ifIndex],
+			VLAN:     vlan,
+			LastSeen: now,
+		})
+	}
+	return entries
+}
+
+// parseFDBIndex splits the index of a forwarding table row into the FDB ID
+// (when vlanIndexed) and the MAC address
+func parseFDBIndex(index string, vlanIndexed bool) (int, string, bool) {
+	parts := strings.Split(index, ".")
+	vlan := 0
+	if vlanIndexed {
+		if len(parts) != 7 {
+			return 0, "", false
+		}
+		vlan, _ = strconv.Atoi(parts[0])
+		parts = parts[1:]
+	}
+	if len(parts) != 6 {
+		return 0, "", false
+	}
+	mac := make(net.HardwareAddr, 6)
+	for i, part := range parts {
+		b, err := strconv.Atoi(part)
+		if err != nil || b < 0 || b > 255 {
+			return 0, "", false
+		}
+		mac[i] = byte(b)
+	}
+	return vlan, mac.String(), true
+}
+
+// LocatePorts works out the switch port of every MAC in the forwarding
+// tables. A MAC is learned on every switch between it and the poller, so the
+// port with the fewest learned addresses is taken: uplinks carry many, the
+// access port of a device few. Switches are looked up by MAC with device.
+func LocatePorts(entries []database.FDBEntry, device func(mac string) *database.Device) map[string]*database.SwitchPort {
+	type portKey struct {
+		switchMAC string
+		ifIndex   int
+	}
+	counts := make(map[portKey]int)
+	for _, entry := range entries {
+		counts[portKey{entry.SwitchMAC, entry.IfIndex}]++
+	}
+
+	best := make(map[string]database.FDBEntry)
+	for _, entry := range entries {
+		if entry.MAC == entry.SwitchMAC {
+			continue
+		}
+		current, ok := best[entry.MAC]
+		count := counts[portKey{entry.SwitchMAC, entry.IfIndex}]
+		if !ok || count < counts[portKey{current.SwitchMAC, current.IfIndex}] {
+			best[entry.MAC] = entry
+		}
+	}
+
+	locations := make(map[string]*database.SwitchPort, len(best))
+	switches := make(map[string]*database.Device) // nil for switches not in the inventory
+	for mac, entry := range best {
+		location := &database.SwitchPort{
+			SwitchMAC: entry.SwitchMAC,
+			Port:      entry.Port,
+			IfIndex:   entry.IfIndex,
+			VLAN:      entry.VLAN,
+		}
+		if location.Port == "" {
+			location.Port = strconv.Itoa(entry.IfIndex)
+		}
+		sw, ok := switches[entry.SwitchMAC]
+		if !ok {
+			sw = device(entry.SwitchMAC)
+			switches[entry.SwitchMAC] = sw
+		}
+		if sw != nil {
+			location.SwitchIP = sw.IP
+			location.SwitchName = sw.Hostname
+			if sw.SNMP != nil && sw.SNMP.SysName != "" {
+				location.SwitchName = sw.SNMP.SysName
+			}
+		}
+		locations[mac] = location
+	}
+	return locations
+}
+
+// cleanText trims whitespace and NUL padding from a DisplayString
+func cleanText(s string) string {
+	return strings.TrimSpace(strings.Trim(s, "\x00"))
+}
@@ -0,0 +1,227 @@
+package snmp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Authentication and privacy protocols of the User-based Security Model
+const (
+	AuthNone   = ""
+	AuthMD5    = "MD5"
+	AuthSHA    = "SHA"
+	AuthSHA256 = "SHA256"
+	PrivNone   = ""
+	PrivDES    = "DES"
+	PrivAES    = "AES"
+)
+
+// User is an SNMPv3 user of the User-based Security Model (RFC 3414)
+type User struct {
+	Name           string
+	AuthProtocol   string // MD5, SHA, SHA256 or empty for noAuth
+	AuthPassphrase string
+	PrivProtocol   string // DES, AES (AES-128) or empty for noPriv
+	PrivPassphrase string
+}
+
+// Validate checks the protocols and passphrases of a user
+func (u *User) Validate() error {
+	if u.Name == "" {
+		return fmt.Errorf("user name is required")
+	}
+	switch strings.ToUpper(u.AuthProtocol) {
+	case AuthNone:
+		if u.PrivProtocol != PrivNone {
+			return fmt.Errorf("privacy requires authentication")
+		}
+		return nil
+	case AuthMD5, AuthSHA, AuthSHA256:
+	default:
+		return fmt.Errorf("unknown auth protocol %q", u.AuthProtocol)
+	}
+	if len(u.AuthPassphrase) < 8 {
+		return fmt.Errorf("auth passphrase must have at least 8 characters")
+	}
+	switch strings.ToUpper(u.PrivProtocol) {
+	case PrivNone:
+	case PrivDES, PrivAES:
+		if len(u.PrivPassphrase) < 8 {
+			return fmt.Errorf("privacy passphrase must have at least 8 characters")
+		}
+	default:
+		return fmt.Errorf("unknown privacy protocol %q", u.PrivProtocol)
+	}
+	return nil
+}
+
+// flags returns the msgFlags security level bits
+func (u *User) flags() byte {
+	var flags byte
+	if u.AuthProtocol != AuthNone {
+		flags |= 0x01
+	}
+	if u.PrivProtocol != PrivNone {
+		flags |= 0x02
+	}
+	return flags
+}
+
+// newHash returns the hash function of the auth protocol
+func (u *User) newHash() func() hash.Hash {
+	switch strings.ToUpper(u.AuthProtocol) {
+	case AuthMD5:
+		return md5.New
+	case AuthSHA256:
+		return sha256.New
+	}
+	return sha1.New
+}
+
+// authParamsLength is the length of the truncated HMAC: 12 bytes for
+// HMAC-MD5-96 and HMAC-SHA-96, 24 for HMAC-SHA-256-192 (RFC 7860)
+func (u *User) authParamsLength() int {
+	if u.AuthProtocol == AuthNone {
+		return 0
+	}
+	if strings.ToUpper(u.AuthProtocol) == AuthSHA256 {
+		return 24
+	}
+	return 12
+}
+
+// localizeKey turns a passphrase into a key for one engine (RFC 3414 A.2)
+func localizeKey(newHash func() hash.Hash, passphrase string, engineID []byte) []byte {
+	h := newHash()
+	password := []byte(passphrase)
+	buf := make([]byte, 64)
+	for count, i := 0, 0; count < 1048576; count += 64 {
+		for j := range buf {
+			buf[j] = password[i%len(password)]
+			i++
+		}
+		h.Write(buf)
+	}
+	ku := h.Sum(nil)
+
+	h.Reset()
+	h.Write(ku)
+	h.Write(engineID)
+	h.Write(ku)
+	return h.Sum(nil)
+}
+
+// authenticate computes the truncated HMAC of a whole message
+func authenticate(newHash func() hash.Hash, key, message []byte, length int) []byte {
+	mac := hmac.New(newHash, key)
+	mac.Write(message)
+	return mac.Sum(nil)[:length]
+}
+
+// encryptScopedPDU encrypts a scoped PDU and returns it with the privacy
+// parameters (salt) to send
+func encryptScopedPDU(protocol string, key []byte, boots, engineTime int32, salt uint64, plaintext []byte) ([]byte, []byte, error) {
+	switch strings.ToUpper(protocol) {
+	case PrivDES:
+		// Salt is engine boots and a local counter; IV is salt XOR pre-IV
+		params := make([]byte, 8)
+		binary.BigEndian.PutUint32(params, uint32(boots))
+		binary.BigEndian.PutUint32(params[4:], uint32(salt))
+		block, err := des.NewCipher(key[:8])
+		if err != nil {
+			return nil, nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = key[8+i] ^ params[i]
+		}
+		padded := append([]byte(nil), plaintext...)
+		if rem := len(padded) % 8; rem != 0 {
+			padded = append(padded, make([]byte, 8-rem)...)
+		}
+		out := make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, padded)
+		return out, params, nil
+
+	case PrivAES:
+		// RFC 3826: IV is boots, time and a 64-bit local salt
+		params := make([]byte, 8)
+		binary.BigEndian.PutUint64(params, salt)
+		block, err := aes.NewCipher(key[:16])
+		if err != nil {
+			return nil, nil, err
+		}
+		return cfb128(block, aesIV(boots, engineTime, params), plaintext, false), params, nil
+	}
+	return nil, nil, fmt.Errorf("unknown privacy protocol %q", protocol)
+}
+
+// decryptScopedPDU decrypts the scoped PDU of a response
+func decryptScopedPDU(protocol string, key []byte, boots, engineTime int32, params, ciphertext []byte) ([]byte, error) {
+	if len(params) != 8 {
+		return nil, errMalformed
+	}
+	switch strings.ToUpper(protocol) {
+	case PrivDES:
+		if len(ciphertext)%8 != 0 {
+			return nil, errMalformed
+		}
+		block, err := des.NewCipher(key[:8])
+		if err != nil {
+			return nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = key[8+i] ^ params[i]
+		}
+		out := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, ciphertext)
+		return out, nil
+
+	case PrivAES:
+		block, err := aes.NewCipher(key[:16])
+		if err != nil {
+			return nil, err
+		}
+		return cfb128(block, aesIV(boots, engineTime, params), ciphertext, true), nil
+	}
+	return nil, fmt.Errorf("unknown privacy protocol %q", protocol)
+}
+
+// aesIV builds the AES-CFB initialization vector of RFC 3826 section 3.1.2.1
+func aesIV(boots, engineTime int32, salt []byte) []byte {
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint32(iv, uint32(boots))
+	binary.BigEndian.PutUint32(iv[4:], uint32(engineTime))
+	copy(iv[8:], salt)
+	return iv
+}
+
+// cfb128 runs AES in 128-bit CFB mode, as RFC 3826 requires
+func cfb128(block cipher.Block, iv, data []byte, decrypt bool) []byte {
+	out := make([]byte, len(data))
+	register := append([]byte(nil), iv...)
+	stream := make([]byte, aes.BlockSize)
+	for i := 0; i < len(data); i += aes.BlockSize {
+		block.Encrypt(stream, register)
+		end := min(i+aes.BlockSize, len(data))
+		for j := i; j < end; j++ {
+			out[j] = data[j] ^ stream[j-i]
+		}
+		if decrypt {
+			copy(register, data[i:end])
+		} else {
+			copy(register, out[i:end])
+		}
+	}
+	return out
+}
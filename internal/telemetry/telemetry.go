@@ -0,0 +1,301 @@
+// Package telemetry keeps the scanner's own counters, gauges and histograms
+// and writes them in the Prometheus text exposition format.
+package telemetry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets suit latencies from a few milliseconds to ten seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ScanBuckets suit scans that take seconds to many minutes
+var ScanBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// family is a metric name with its series, one per combination of label values
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	fn      func() float64                                       // Gauge functions are read when written
+	collect func(set func(value float64, labelValues ...string)) // So are labelled gauge functions
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is one set of label values of a family
+type series struct {
+	labelValues []string
+	value       float64  // Counter and gauge value
+	counts      []uint64 // Histogram observations per bucket (not cumulative)
+	sum         float64
+	count       uint64
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]*family)
+)
+
+// register adds a family. A name can only be registered once; a second
+// family with the same name would hide the first, so it panics.
+func register(f *family) *family {
+	f.series = make(map[string]*series)
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[f.name]; ok {
+		panic(fmt.Sprintf("telemetry: %s registered twice", f.name))
+	}
+	registry[f.name] = f
+	return f
+}
+
+// get returns the series for the label values, creating it if needed.
+// Callers hold f.mu.
+func (f *family) get(labelValues []string) *series {
+	return f.seriesIn(f.series, labelValues)
+}
+
+// seriesIn returns the series for the label values in set, creating it if
+// needed
+func (f *family) seriesIn(set map[string]*series, labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("telemetry: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := set[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		set[key] = s
+	}
+	return s
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	f *family
+}
+
+// NewCounter registers a counter with the given label names
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{register(&family{name: name, help: help, kind: kindCounter, labels: labels})}
+}
+
+// Inc adds one to the series of the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the series of the label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.f.mu.Lock()
+	c.f.get(labelValues).value += v
+	c.f.mu.Unlock()
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	f *family
+}
+
+// NewGauge registers a gauge with the given label names
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{register(&family{name: name, help: help, kind: kindGauge, labels: labels})}
+}
+
+// Set sets the series of the label values to v
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	g.f.get(labelValues).value = v
+	g.f.mu.Unlock()
+}
+
+// NewGaugeFunc registers an unlabelled gauge whose value is read from fn
+// each time the metrics are written
+func NewGaugeFunc(name, help string, fn func() float64) {
+	register(&family{name: name, help: help, kind: kindGauge, fn: fn})
+}
+
+// NewGaugeVecFunc registers a gauge with the given label names whose series
+// are collected each time the metrics are written: collect calls set once
+// per combination of label values. Each scrape sees only what collect set,
+// so series that disappear are never left behind.
+func NewGaugeVecFunc(name, help string, collect func(set func(value float64, labelValues ...string)), labels ...string) {
+	register(&family{name: name, help: help, kind: kindGauge, labels: labels, collect: collect})
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	f *family
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds,
+// in increasing order, and label names
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{register(&family{name: name, help: help, kind: kindHistogram, labels: labels, buckets: buckets})}
+}
+
+// Observe records v in the series of the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+
+	s := h.f.get(labelValues)
+	if i := sort.SearchFloat64s(h.f.buckets, v); i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// ObserveDuration records the seconds elapsed since start
+func (h *Histogram) ObserveDuration(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format,
+// ordered by name and label values
+func WriteText(w io.Writer) error {
+	registryMu.Lock()
+	families := make([]*family, 0, len(registry))
+	for _, f := range registry {
+		families = append(families, f)
+	}
+	registryMu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// write writes the HELP and TYPE lines and the samples of a family
+func (f *family) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	if f.fn != nil {
+		writeSample(w, f.name, nil, nil, f.fn())
+		return
+	}
+	if f.collect != nil {
+		collected := make(map[string]*series)
+		f.collect(func(value float64, labelValues ...string) {
+			f.seriesIn(collected, labelValues).value = value
+		})
+		f.writeSeries(w, collected)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeSeries(w, f.series)
+}
+
+// writeSeries writes the samples of a set of series, ordered by label values
+func (f *family) writeSeries(w *bufio.Writer, set map[string]*series) {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := set[key]
+		if f.kind != kindHistogram {
+			writeSample(w, f.name, f.labels, s.labelValues, s.value)
+			continue
+		}
+
+		names := append(append([]string(nil), f.labels...), "le")
+		values := append(append([]string(nil), s.labelValues...), "")
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i]
+			values[len(values)-1] = formatFloat(bound)
+			writeSample(w, f.name+"_bucket", names, values, float64(cumulative))
+		}
+		values[len(values)-1] = "+Inf"
+		writeSample(w, f.name+"_bucket", names, values, float64(s.count))
+		writeSample(w, f.name+"_sum", f.labels, s.labelValues, s.sum)
+		writeSample(w, f.name+"_count", f.labels, s.labelValues, float64(s.count))
+	}
+}
+
+// writeSample writes `name{label="value",...} value`
+func writeSample(w *bufio.Writer, name string, labels, labelValues []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabelValue(labelValues[i]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// formatFloat formats a value the way Prometheus expects
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// Handler serves the metrics for Prometheus to scrape
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w)
+	})
+}
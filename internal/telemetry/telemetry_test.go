@@ -0,0 +1,46 @@
+package telemetry
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegisterTwicePanics(t *testing.T) {
+	NewCounter("test_registered_twice_total", "First.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	NewGauge("test_registered_twice_total", "Second.")
+}
+
+func TestGaugeVecFuncCollectsOnWrite(t *testing.T) {
+	counts := map[string]float64{"router": 2, "printer": 1}
+	NewGaugeVecFunc("test_collected", "Collected when written.", func(set func(float64, ...string)) {
+		for kind, count := range counts {
+			set(count, kind)
+		}
+	}, "type")
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`test_collected{type="printer"} 1`, `test_collected{type="router"} 2`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output lacks %s", want)
+		}
+	}
+
+	// A series that is no longer collected is not written again
+	delete(counts, "printer")
+	buf.Reset()
+	if err := WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), `type="printer"`) {
+		t.Error("stale series written after it was no longer collected")
+	}
+}
@@ -1,19 +1,26 @@
 package web
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
 	"network-scanner-go/internal/database"
 	"network-scanner-go/internal/management"
+	"network-scanner-go/internal/scanjobs"
 	"network-scanner-go/internal/scanner"
 	"network-scanner-go/internal/search"
 	"network-scanner-go/internal/security"
+	"network-scanner-go/internal/snmp"
+	"network-scanner-go/internal/telemetry"
 	"sort"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -25,46 +32,58 @@ var indexHTML string
 //go:embed static
 var staticFS embed.FS
 
-var (
-	scanState = make(map[string]*database.ScanProgress)
-	stateMu   sync.RWMutex
-)
-
 // Server represents the web server
 type Server struct {
-	router    *mux.Router
-	port      string
-	wsManager *WSManager
+	router     *mux.Router
+	port       string
+	httpServer *http.Server
+	wsManager  *WSManager
+	scanJobs   *scanjobs.Manager
+	inventory  *database.Inventory
 }
 
-// NewServer creates a new web server
-func NewServer(port string) *Server {
+// NewServer creates a new web server for the devices of inventory
+func NewServer(port string, inventory *database.Inventory) *Server {
 	s := &Server{
 		router:    mux.NewRouter(),
 		port:      port,
 		wsManager: NewWSManager(),
+		inventory: inventory,
 	}
 
 	go s.wsManager.Run()
-	// Load security rules
-	security.LoadRules(security.GetDefaultRulesPath())
+	telemetry.NewGaugeFunc("scanner_websocket_clients", "Connected WebSocket clients.", func() float64 {
+		return float64(s.wsManager.ClientCount())
+	})
+	telemetry.NewGaugeVecFunc("scanner_devices", "Devices in the inventory by type and known status.", s.collectDeviceCounts, "type", "known")
 
 	s.setupRoutes()
+	s.httpServer = &http.Server{Addr: ":" + port, Handler: s.router}
 	return s
 }
 
 // setupRoutes configures the HTTP routes
 func (s *Server) setupRoutes() {
 	s.router.HandleFunc("/", s.handleIndex).Methods("GET")
+	s.router.HandleFunc("/metrics", s.handleMetrics).Methods("GET")
 	s.router.HandleFunc("/api/devices", s.handleSearch).Methods("GET")
 	s.router.HandleFunc("/api/devices/{mac}", s.handleUpdateDevice).Methods("PUT")
 	s.router.HandleFunc("/api/devices/{mac}/check-vulnerabilities", s.handleCheckVulnerabilities).Methods("POST")
+	s.router.HandleFunc("/api/devices/{mac}/fingerprint", s.handleFingerprintDevice).Methods("POST")
+	s.router.HandleFunc("/api/devices/{mac}/metrics", s.handleGetDeviceMetrics).Methods("GET")
 
 	// Static files
 	s.router.PathPrefix("/static/").Handler(http.FileServer(http.FS(staticFS)))
 
 	s.router.HandleFunc("/api/scan-all-ports/{ip}", s.handleScanAllPorts).Methods("POST")
 	s.router.HandleFunc("/api/scan-progress/{ip}", s.handleScanProgress).Methods("GET")
+	s.router.HandleFunc("/api/port-profiles", s.handleGetPortProfiles).Methods("GET")
+
+	// Scan job endpoints
+	s.router.HandleFunc("/api/scans", s.handleListScans).Methods("GET")
+	s.router.HandleFunc("/api/scans", s.handleCreateScan).Methods("POST")
+	s.router.HandleFunc("/api/scans/{id}", s.handleGetScan).Methods("GET")
+	s.router.HandleFunc("/api/scans/{id}", s.handleDeleteScan).Methods("DELETE")
 
 	// Notification endpoints
 	s.router.HandleFunc("/api/notifications", s.handleGetNotifications).Methods("GET")
@@ -82,6 +101,14 @@ func (s *Server) setupRoutes() {
 	s.router.HandleFunc("/api/stats/trends", s.handleGetNetworkTrends).Methods("GET")
 	s.router.HandleFunc("/api/stats/uptime/{mac}", s.handleGetDeviceUptime).Methods("GET")
 
+	// SNMP credentials and SNMPv3 users; secrets are write-only
+	s.router.HandleFunc("/api/snmp/credentials", s.handleGetSNMPCredentials).Methods("GET")
+	s.router.HandleFunc("/api/snmp/credentials", s.handleAddSNMPCredential).Methods("POST")
+	s.router.HandleFunc("/api/snmp/credentials/{id}", s.handleDeleteSNMPCredential).Methods("DELETE")
+	s.router.HandleFunc("/api/snmp/users", s.handleGetSNMPUsers).Methods("GET")
+	s.router.HandleFunc("/api/snmp/users", s.handleSaveSNMPUser).Methods("POST")
+	s.router.HandleFunc("/api/snmp/users/{name}", s.handleDeleteSNMPUser).Methods("DELETE")
+
 	// WebSocket endpoint
 	s.router.HandleFunc("/ws", s.wsManager.HandleConnections)
 
@@ -92,17 +119,14 @@ func (s *Server) setupRoutes() {
 
 // handleIndex renders the dashboard
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
-	devices, err := database.GetAllDevices()
-	if err != nil {
-		http.Error(w, "Failed to load devices", http.StatusInternalServerError)
-		return
-	}
-
+	// Only the matching devices are copied
+	var match func(*database.Device) bool
 	searchQuery := r.URL.Query().Get("q")
 	if searchQuery != "" {
 		q := search.Parse(searchQuery)
-		devices = q.Filter(devices)
+		match = q.Match
 	}
+	devices := s.inventory.SelectDevices(match)
 
 	// Sort by IP address
 	sort.Slice(devices, func(i, j int) bool {
@@ -117,6 +141,44 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		"add": func(a, b int) int {
 			return a + b
 		},
+		"hostport": func(host string, port int) string {
+			return net.JoinHostPort(host, strconv.Itoa(port))
+		},
+		"service": func(device *database.Device, port int) string {
+			svc := device.FindService("tcp", port)
+			if svc == nil {
+				return ""
+			}
+			text := strings.TrimSpace(fmt.Sprintf("%s %s %s", svc.Name, svc.Product, svc.Version))
+			if svc.HTTP != nil && svc.HTTP.Title != "" {
+				text += fmt.Sprintf(" \"%s\"", svc.HTTP.Title)
+			}
+			if svc.SSH != nil && len(svc.SSH.HostKeys) > 0 {
+				text += fmt.Sprintf(" - %s %s", svc.SSH.HostKeys[0].Type, svc.SSH.HostKeys[0].Fingerprint)
+			}
+			if svc.TLS != nil {
+				text += fmt.Sprintf(" - %s, %s, expires %s", svc.TLS.Version, svc.TLS.Subject, svc.TLS.NotAfter.Format("2006-01-02"))
+			}
+			return strings.TrimSpace(text)
+		},
+		"tls": func(device *database.Device, port int) *database.TLSInfo {
+			if svc := device.FindService("tcp", port); svc != nil {
+				return svc.TLS
+			}
+			return nil
+		},
+		"percent": func(fraction float64) string {
+			return fmt.Sprintf("%.0f%%", fraction*100)
+		},
+		"openUDP": func(device *database.Device) []database.Service {
+			var open []database.Service
+			for _, svc := range device.Services {
+				if svc.Protocol == "udp" && svc.State == database.StateOpen {
+					open = append(open, svc)
+				}
+			}
+			return open
+		},
 	}
 
 	tmpl, err := template.New("index").Funcs(funcMap).Parse(indexHTML)
@@ -191,19 +253,41 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleSearch returns a filtered list of devices in JSON format
-func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
-	devices, err := database.GetAllDevices()
-	if err != nil {
-		http.Error(w, "Failed to load devices", http.StatusInternalServerError)
-		return
+// handleMetrics serves the scanner's own metrics for Prometheus
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	telemetry.Handler().ServeHTTP(w, r)
+}
+
+// collectDeviceCounts reports the devices of the inventory by type and
+// known status each time the metrics are written
+func (s *Server) collectDeviceCounts(set func(value float64, labelValues ...string)) {
+	counts := make(map[[2]string]int)
+	s.inventory.RangeDevices(func(device *database.Device) bool {
+		deviceType := device.CustomType
+		if deviceType == "" {
+			deviceType = device.Type
+		}
+		if deviceType == "" {
+			deviceType = "Unknown"
+		}
+		counts[[2]string{deviceType, strconv.FormatBool(device.IsKnown)}]++
+		return true
+	})
+	for key, count := range counts {
+		set(float64(count), key[0], key[1])
 	}
+}
 
+// handleSearch returns a filtered list of devices in JSON format
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	// Only the matching devices are copied
+	var match func(*database.Device) bool
 	searchQuery := r.URL.Query().Get("q")
 	if searchQuery != "" {
 		q := search.Parse(searchQuery)
-		devices = q.Filter(devices)
+		match = q.Match
 	}
+	devices := s.inventory.SelectDevices(match)
 
 	// Sort by IP address
 	sort.Slice(devices, func(i, j int) bool {
@@ -214,146 +298,97 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(devices)
 }
 
-// handleScanAllPorts initiates a full port scan
+// handleScanAllPorts queues a port scan with the profile given by ?profile= (default: full)
 func (s *Server) handleScanAllPorts(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	ip := vars["ip"]
+	ip := mux.Vars(r)["ip"]
 
-	stateMu.Lock()
-	if progress, exists := scanState[ip]; exists && progress.Status == "running" {
-		stateMu.Unlock()
+	job, err := s.scanJobs.Submit(ip, r.URL.Query().Get("profile"), requesterOf(r))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Scan already running for this IP",
+			"error": err.Error(),
 		})
 		return
 	}
 
-	// Initialize scan state
-	scanState[ip] = &database.ScanProgress{
-		Status:      "running",
-		Progress:    0,
-		CurrentPort: 0,
-		TotalPorts:  65535,
-		OpenPorts:   []int{},
-		PortsFound:  0,
-		StartTime:   time.Now(),
-	}
-	stateMu.Unlock()
-
-	// Start scan in goroutine
-	go func() {
-		log.Printf("Starting full port scan for %s\n", ip)
-
-		openPorts := scanner.ScanAllPorts(ip, func(current, total int, ports []int) {
-			stateMu.Lock()
-			if state, ok := scanState[ip]; ok {
-				state.Progress = (current * 100) / total
-				state.CurrentPort = current
-				state.OpenPorts = ports
-				state.PortsFound = len(ports)
-
-				s.Broadcast(map[string]interface{}{
-					"type": "scan_progress",
-					"ip":   ip,
-					"data": state,
-				})
-			}
-			stateMu.Unlock()
-		})
-
-		// Mark as complete
-		stateMu.Lock()
-		if state, ok := scanState[ip]; ok {
-			state.Status = "complete"
-			state.Progress = 100
-			now := time.Now()
-			state.EndTime = &now
-			state.OpenPorts = openPorts
-			state.PortsFound = len(openPorts)
-
-			s.Broadcast(map[string]interface{}{
-				"type": "scan_complete",
-				"ip":   ip,
-				"data": state,
-			})
-		}
-		stateMu.Unlock()
-
-		// Update database and check vulnerabilities
-		devices, _ := database.GetAllDevices()
-		for _, device := range devices {
-			if device.IP == ip {
-				device.OpenPorts = openPorts
-
-				// Check for vulnerabilities
-				device.Vulnerabilities = security.CheckDevice(openPorts, device.Type)
-
-				database.UpsertDevice(device)
-
-				// Notify if critical vulnerabilities found
-				for _, v := range device.Vulnerabilities {
-					if v.Severity == "critical" || v.Severity == "high" {
-						database.SaveNotification(&database.Notification{
-							Type:      "security_alert",
-							DeviceIP:  device.IP,
-							DeviceMAC: device.MAC,
-							Message:   fmt.Sprintf("Security Risk: %s detected on %s", v.Name, v.Severity),
-							Timestamp: time.Now(),
-							Read:      false,
-							Severity:  v.Severity,
-						})
-						s.Broadcast(map[string]interface{}{
-							"type": "notification_alert", // Special type for security
-							"data": v,
-						})
-					}
-				}
-				break
-			}
-		}
-
-		log.Printf("Full port scan complete for %s. Found %d open ports\n", ip, len(openPorts))
-	}()
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status": "started",
 		"ip":     ip,
+		"id":     job.ID,
 	})
 }
 
-// handleScanProgress returns the progress of a port scan
+// handleScanProgress returns the progress of the latest port scan of an IP
 func (s *Server) handleScanProgress(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	ip := vars["ip"]
-
-	stateMu.RLock()
-	progress, exists := scanState[ip]
-	stateMu.RUnlock()
+	ip := mux.Vars(r)["ip"]
 
-	if !exists {
+	jobs, err := database.GetScanJobs(ip, 1)
+	if err != nil {
+		http.Error(w, "Failed to load scans", http.StatusInternalServerError)
+		return
+	}
+	if len(jobs) == 0 {
 		http.Error(w, "No scan found for this IP", http.StatusNotFound)
 		return
 	}
+	job := jobs[0]
 
 	w.Header().Set("Content-Type", "application/json")
 	response := map[string]interface{}{
-		"status":       progress.Status,
-		"progress":     progress.Progress,
-		"current_port": progress.CurrentPort,
-		"total_ports":  progress.TotalPorts,
-		"open_ports":   progress.OpenPorts,
-		"ports_found":  progress.PortsFound,
-		"elapsed_time": progress.ElapsedTime(),
+		"id":           job.ID,
+		"status":       job.Status,
+		"progress":     job.Progress,
+		"current_port": job.CurrentPort,
+		"total_ports":  job.TotalPorts,
+		"open_ports":   job.OpenPorts,
+		"ports_found":  job.PortsFound,
+		"elapsed_time": job.ElapsedTime(),
+		"error":        job.Error,
 	}
 
 	json.NewEncoder(w).Encode(response)
 }
 
-// Start starts the web server
+// handleGetPortProfiles lists the available port profiles
+func (s *Server) handleGetPortProfiles(w http.ResponseWriter, r *http.Request) {
+	type profileInfo struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Ports       int    `json:"ports"`
+		Banners     bool   `json:"banners"`
+	}
+
+	profiles := make([]profileInfo, 0)
+	for _, profile := range scanner.GetPortProfiles() {
+		profiles = append(profiles, profileInfo{
+			Name:        profile.Name,
+			Description: profile.Description,
+			Ports:       len(profile.PortList()),
+			Banners:     profile.Banners,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profiles)
+}
+
+// Start serves the web interface until Shutdown is called
 func (s *Server) Start() error {
 	log.Printf("Starting web server on port %s\n", s.port)
-	return http.ListenAndServe(":"+s.port, s.router)
+	if err := s.httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections, waits for pending requests until ctx
+// is done and closes the WebSocket connections, which Shutdown does not track
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.httpServer.Shutdown(ctx)
+	s.wsManager.Close()
+	return err
 }
 
 // Broadcast sends a message to all connected WebSocket clients
@@ -492,28 +527,24 @@ func (s *Server) handleGetNetworkHistory(w http.ResponseWriter, r *http.Request)
 
 // handleGetStatsOverview returns an overview of network statistics
 func (s *Server) handleGetStatsOverview(w http.ResponseWriter, r *http.Request) {
-	devices, err := database.GetAllDevices()
-	if err != nil {
-		http.Error(w, "Failed to load devices", http.StatusInternalServerError)
-		return
-	}
-
 	// Calculate statistics
-	totalDevices := len(devices)
+	totalDevices := 0
 	activeDevices := 0
 	totalPorts := 0
 	now := time.Now()
 	oneDayAgo := now.Add(-24 * time.Hour)
 
-	for _, device := range devices {
+	s.inventory.RangeDevices(func(device *database.Device) bool {
+		totalDevices++
 		if device.LastSeen.After(oneDayAgo) {
 			activeDevices++
 		}
 		totalPorts += len(device.OpenPorts)
-	}
+		return true
+	})
 
 	// Get today's stats
-	todayStats, _ := database.CalculateDailyStats(now)
+	todayStats, _ := database.CalculateDailyStats(now, s.inventory)
 
 	overview := map[string]interface{}{
 		"total_devices":      totalDevices,
@@ -578,6 +609,30 @@ func (s *Server) handleGetDeviceUptime(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleGetDeviceMetrics returns the metrics scraped from a device
+func (s *Server) handleGetDeviceMetrics(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	mac := vars["mac"]
+
+	// Get time range from query parameters (default: last 24 hours)
+	hours := 24
+	if hoursParam := r.URL.Query().Get("hours"); hoursParam != "" {
+		fmt.Sscanf(hoursParam, "%d", &hours)
+	}
+
+	to := time.Now()
+	from := to.Add(-time.Duration(hours) * time.Hour)
+
+	series, err := database.GetMetricSeries(mac, r.URL.Query().Get("name"), from, to)
+	if err != nil {
+		http.Error(w, "Failed to load device metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(series)
+}
+
 // handleUpdateDevice updates device details
 func (s *Server) handleUpdateDevice(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -597,7 +652,7 @@ func (s *Server) handleUpdateDevice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := database.UpdateDeviceDetails(mac, req.CustomName, req.CustomType, req.IsKnown, req.Tags, req.Notes, req.GroupName); err != nil {
+	if err := s.inventory.UpdateDeviceDetails(mac, req.CustomName, req.CustomType, req.IsKnown, req.Tags, req.Notes, req.GroupName); err != nil {
 		http.Error(w, "Failed to update device: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -626,36 +681,43 @@ func (s *Server) handleDeleteAllNotifications(w http.ResponseWriter, r *http.Req
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
-// handleCheckVulnerabilities performs a vulnerability check on a specific device
-func (s *Server) handleCheckVulnerabilities(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	mac := vars["mac"]
+// handleFingerprintDevice classifies a device again, returning every rule that
+// matched so the rules can be tuned
+func (s *Server) handleFingerprintDevice(w http.ResponseWriter, r *http.Request) {
+	mac := mux.Vars(r)["mac"]
+	w.Header().Set("Content-Type", "application/json")
 
-	devices, err := database.GetAllDevices()
-	if err != nil {
-		http.Error(w, "Failed to load devices", http.StatusInternalServerError)
+	device := s.inventory.GetDevice(mac)
+	if device == nil {
+		http.Error(w, "Device not found", http.StatusNotFound)
 		return
 	}
 
-	var targetDevice *database.Device
-	for _, d := range devices {
-		if d.MAC == mac {
-			targetDevice = d
-			break
-		}
+	result := scanner.ClassifyDevice(device)
+	if err := s.inventory.UpsertDevice(device); err != nil {
+		http.Error(w, "Failed to update device", http.StatusInternalServerError)
+		return
 	}
+	json.NewEncoder(w).Encode(result)
+}
 
+// handleCheckVulnerabilities performs a vulnerability check on a specific device
+func (s *Server) handleCheckVulnerabilities(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	mac := vars["mac"]
+
+	targetDevice := s.inventory.GetDevice(mac)
 	if targetDevice == nil {
 		http.Error(w, "Device not found", http.StatusNotFound)
 		return
 	}
 
 	// Run vulnerability check
-	vulns := security.CheckDevice(targetDevice.OpenPorts, targetDevice.Type)
+	vulns := security.CheckDevice(targetDevice)
 	targetDevice.Vulnerabilities = vulns
 
 	// Save back to database
-	if err := database.UpsertDevice(targetDevice); err != nil {
+	if err := s.inventory.UpsertDevice(targetDevice); err != nil {
 		http.Error(w, "Failed to update device vulnerabilities", http.StatusInternalServerError)
 		return
 	}
@@ -684,7 +746,7 @@ func (s *Server) handleCheckVulnerabilities(w http.ResponseWriter, r *http.Reque
 
 // handleExport handles the device export
 func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
-	data, err := management.ExportDevices()
+	data, err := management.ExportDevices(s.inventory)
 	if err != nil {
 		http.Error(w, "Export failed: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -716,7 +778,7 @@ func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
 	n, _ := file.Read(content)
 	content = content[:n]
 
-	count, err := management.ImportDevices(content)
+	count, err := management.ImportDevices(s.inventory, content)
 	if err != nil {
 		http.Error(w, "Import failed: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -728,3 +790,260 @@ func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
 		"count":  count,
 	})
 }
+
+// maskedSecret replaces stored secrets in API responses
+const maskedSecret = "********"
+
+// handleGetSNMPCredentials returns the SNMP credentials with masked communities
+func (s *Server) handleGetSNMPCredentials(w http.ResponseWriter, r *http.Request) {
+	credentials, err := database.GetSNMPCredentials()
+	if err != nil {
+		http.Error(w, "Failed to load SNMP credentials", http.StatusInternalServerError)
+		return
+	}
+
+	for _, credential := range credentials {
+		if credential.Community != "" {
+			credential.Community = maskedSecret
+		}
+	}
+	if credentials == nil {
+		credentials = []*database.SNMPCredential{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credentials)
+}
+
+// handleAddSNMPCredential adds a v2c community or v3 user for a subnet
+func (s *Server) handleAddSNMPCredential(w http.ResponseWriter, r *http.Request) {
+	var credential database.SNMPCredential
+	if err := json.NewDecoder(r.Body).Decode(&credential); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := snmp.ValidateCredential(&credential); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if credential.Version == database.SNMPVersion3 {
+		users, err := database.GetSNMPUsers()
+		if err != nil {
+			http.Error(w, "Failed to load SNMP users", http.StatusInternalServerError)
+			return
+		}
+		found := false
+		for _, user := range users {
+			found = found || user.Name == credential.User
+		}
+		if !found {
+			http.Error(w, "Unknown SNMP user", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := database.SaveSNMPCredential(&credential); err != nil {
+		http.Error(w, "Failed to save SNMP credential", http.StatusInternalServerError)
+		return
+	}
+	if credential.Community != "" {
+		credential.Community = maskedSecret
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credential)
+}
+
+// handleDeleteSNMPCredential deletes an SNMP credential
+func (s *Server) handleDeleteSNMPCredential(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid credential ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := database.DeleteSNMPCredential(id); err != nil {
+		http.Error(w, "Failed to delete SNMP credential: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// handleGetSNMPUsers returns the SNMPv3 users without their passphrases
+func (s *Server) handleGetSNMPUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := database.GetSNMPUsers()
+	if err != nil {
+		http.Error(w, "Failed to load SNMP users", http.StatusInternalServerError)
+		return
+	}
+
+	for _, user := range users {
+		if user.AuthPassphrase != "" {
+			user.AuthPassphrase = maskedSecret
+		}
+		if user.PrivPassphrase != "" {
+			user.PrivPassphrase = maskedSecret
+		}
+	}
+	if users == nil {
+		users = []*database.SNMPUser{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// handleSaveSNMPUser adds or replaces an SNMPv3 user
+func (s *Server) handleSaveSNMPUser(w http.ResponseWriter, r *http.Request) {
+	var user database.SNMPUser
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	user.AuthProtocol = strings.ToUpper(user.AuthProtocol)
+	user.PrivProtocol = strings.ToUpper(user.PrivProtocol)
+	if err := snmp.NewUser(&user).Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := database.SaveSNMPUser(&user); err != nil {
+		http.Error(w, "Failed to save SNMP user", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// handleDeleteSNMPUser deletes an SNMPv3 user
+func (s *Server) handleDeleteSNMPUser(w http.ResponseWriter, r *http.Request) {
+	if err := database.DeleteSNMPUser(mux.Vars(r)["name"]); err != nil {
+		http.Error(w, "Failed to delete SNMP user: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// SetScanJobs sets the manager that runs on-demand port scans
+func (s *Server) SetScanJobs(manager *scanjobs.Manager) {
+	s.scanJobs = manager
+}
+
+// requesterOf names who asked for a scan: the client address
+func requesterOf(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// handleListScans returns past and pending scans, newest first
+func (s *Server) handleListScans(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		fmt.Sscanf(limitParam, "%d", &limit)
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+
+	jobs, err := database.GetScanJobs(r.URL.Query().Get("target"), limit)
+	if err != nil {
+		http.Error(w, "Failed to load scans", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// handleCreateScan queues a port scan
+func (s *Server) handleCreateScan(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Target      string `json:"target"`
+		Profile     string `json:"profile"`
+		RequestedBy string `json:"requested_by"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.RequestedBy == "" {
+		request.RequestedBy = requesterOf(r)
+	}
+
+	job, err := s.scanJobs.Submit(request.Target, request.Profile, request.RequestedBy)
+	if err == scanjobs.ErrQueueFull {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleGetScan returns a scan with its progress and results
+func (s *Server) handleGetScan(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid scan ID", http.StatusBadRequest)
+		return
+	}
+
+	job, err := database.GetScanJob(id)
+	if err != nil {
+		http.Error(w, "Failed to load scan", http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		http.Error(w, "Scan not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleDeleteScan cancels a queued or running scan, or deletes a finished
+// one from the history
+func (s *Server) handleDeleteScan(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid scan ID", http.StatusBadRequest)
+		return
+	}
+
+	cancelled, err := s.scanJobs.Cancel(id)
+	if err != nil {
+		http.Error(w, "Failed to cancel scan", http.StatusInternalServerError)
+		return
+	}
+	status := "cancelled"
+	if !cancelled {
+		deleted, err := database.DeleteScanJob(id)
+		if err != nil {
+			http.Error(w, "Failed to delete scan", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Scan not found", http.StatusNotFound)
+			return
+		}
+		status = "deleted"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -21,6 +22,8 @@ type WSManager struct {
 	clients   map[*websocket.Conn]bool
 	broadcast chan interface{} // Accept any JSON-serializable object
 	mu        sync.Mutex
+	done      chan struct{} // Closed by Close
+	closed    bool
 }
 
 // NewWSManager creates a new WebSocket manager
@@ -28,13 +31,19 @@ func NewWSManager() *WSManager {
 	return &WSManager{
 		clients:   make(map[*websocket.Conn]bool),
 		broadcast: make(chan interface{}),
+		done:      make(chan struct{}),
 	}
 }
 
-// Run starts the message broadcasting loop
+// Run starts the message broadcasting loop. It returns once the manager is closed.
 func (m *WSManager) Run() {
 	for {
-		msg := <-m.broadcast
+		var msg interface{}
+		select {
+		case msg = <-m.broadcast:
+		case <-m.done:
+			return
+		}
 
 		m.mu.Lock()
 		for client := range m.clients {
@@ -58,6 +67,11 @@ func (m *WSManager) HandleConnections(w http.ResponseWriter, r *http.Request) {
 	}
 
 	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		closeClient(ws)
+		return
+	}
 	m.clients[ws] = true
 	m.mu.Unlock()
 
@@ -82,10 +96,43 @@ func (m *WSManager) HandleConnections(w http.ResponseWriter, r *http.Request) {
 	}()
 }
 
+// ClientCount returns the number of connected clients
+func (m *WSManager) ClientCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.clients)
+}
+
 // Broadcast sends a message to all connected clients
 func (m *WSManager) Broadcast(msg interface{}) {
 	// Non-blocking send to avoid hanging if Run loop is busy
 	go func() {
-		m.broadcast <- msg
+		select {
+		case m.broadcast <- msg:
+		case <-m.done:
+		}
 	}()
 }
+
+// Close stops broadcasting and disconnects every client with a close frame
+func (m *WSManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	m.closed = true
+	close(m.done)
+
+	for client := range m.clients {
+		closeClient(client)
+	}
+	log.Printf("Closed %d WebSocket connections", len(m.clients))
+}
+
+// closeClient tells a client the server is going away and closes the connection
+func closeClient(ws *websocket.Conn) {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	ws.Close()
+}